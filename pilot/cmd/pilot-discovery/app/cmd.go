@@ -147,6 +147,12 @@ func addFlags(c *cobra.Command) {
 		"DNS domain suffix")
 	c.PersistentFlags().StringVar((*string)(&serverArgs.RegistryOptions.KubeOptions.ClusterID), "clusterID", features.ClusterName,
 		"The ID of the cluster that this Istiod instance resides")
+	c.PersistentFlags().IntVar(&serverArgs.RegistryOptions.KubeOptions.IngressMaxAttempts, "ingressMaxAttempts", 0,
+		"Maximum number of times an ingress event is processed before it is dropped. If 0, failed events are retried until they succeed")
+	c.PersistentFlags().DurationVar(&serverArgs.RegistryOptions.KubeOptions.IngressRetryDelay, "ingressRetryDelay", time.Second,
+		"Delay before a failed ingress event is retried")
+	c.PersistentFlags().StringSliceVar(&serverArgs.RegistryOptions.KubeOptions.IngressNamespaces, "ingressNamespaces", nil,
+		"Namespaces to watch for ingresses. If empty, ingresses in all namespaces are watched")
 
 	// using address, so it can be configured as localhost:.. (possibly UDS in future)
 	c.PersistentFlags().StringVar(&serverArgs.ServerOptions.HTTPAddr, "httpAddr", ":8080",
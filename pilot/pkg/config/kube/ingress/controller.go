@@ -21,19 +21,22 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
-	"time"
 
 	"github.com/hashicorp/go-multierror"
 	ingress "k8s.io/api/networking/v1beta1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	klabels "k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/informers/networking/v1beta1"
 	listerv1 "k8s.io/client-go/listers/core/v1"
+	listerv1beta1 "k8s.io/client-go/listers/networking/v1beta1"
 	"k8s.io/client-go/tools/cache"
 
 	meshconfig "istio.io/api/mesh/v1alpha1"
 	"istio.io/istio/pilot/pkg/model"
 	kubecontroller "istio.io/istio/pilot/pkg/serviceregistry/kube/controller"
+	"istio.io/istio/pilot/pkg/util/sets"
 	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/constants"
 	"istio.io/istio/pkg/config/mesh"
@@ -44,6 +47,7 @@ import (
 	"istio.io/istio/pkg/queue"
 	"istio.io/pkg/env"
 	"istio.io/pkg/log"
+	"istio.io/pkg/monitoring"
 )
 
 // In 1.0, the Gateway is defined in the namespace where the actual controller runs, and needs to be managed by
@@ -61,8 +65,10 @@ import (
 // instead of the ingress labels.
 
 // Follows mesh.IngressControllerMode setting to enable - OFF|STRICT|DEFAULT.
-// STRICT requires "kubernetes.io/ingress.class" == mesh.IngressClass
+// STRICT requires "kubernetes.io/ingress.class" to be one of the comma separated classes of mesh.IngressClass
 // DEFAULT allows Ingress without explicit class.
+// Ingresses without class are also processed when the IngressClass marked as default in the cluster is Istio's.
+// Changes to these mesh settings, and to IngressClasses, re-sync all ingresses.
 
 // In 1.1:
 // - K8S_INGRESS_NS - namespace of the Gateway that will act as ingress.
@@ -74,19 +80,53 @@ var schemas = collection.SchemasFor(
 	collections.IstioNetworkingV1Alpha3Virtualservices,
 	collections.IstioNetworkingV1Alpha3Gateways)
 
+var ingressRetriesExhausted = monitoring.NewSum(
+	"pilot_k8s_ingress_retries_exhausted",
+	"Number of ingress events dropped after exhausting all processing attempts.",
+)
+
+var ingressUnresolvedNamedPorts = monitoring.NewSum(
+	"pilot_k8s_ingress_unresolved_named_ports",
+	"Number of ingress backends whose service port name could not be resolved when ingresses are added or updated.",
+)
+
+var (
+	reasonTag = monitoring.MustCreateLabel("reason")
+
+	ingressInvalid = monitoring.NewSum(
+		"pilot_k8s_ingress_invalid",
+		"Number of invalid ingress rules and paths found when ingresses are added or updated.",
+		monitoring.WithLabels(reasonTag),
+	)
+)
+
+func init() {
+	monitoring.MustRegister(ingressRetriesExhausted, ingressUnresolvedNamedPorts, ingressInvalid)
+}
+
 // Control needs RBAC permissions to write to Pods.
 
 type controller struct {
 	meshWatcher  mesh.Holder
 	domainSuffix string
+	// domainSuffixResolver, if set, overrides domainSuffix per namespace.
+	domainSuffixResolver func(namespace string) string
 
 	queue                  queue.Instance
+	maxAttempts            int
 	virtualServiceHandlers []func(config.Config, config.Config, model.Event)
 	gatewayHandlers        []func(config.Config, config.Config, model.Event)
 
-	ingressInformer cache.SharedInformer
-	serviceInformer cache.SharedInformer
-	serviceLister   listerv1.ServiceLister
+	// namespaces the controller is scoped to. If empty, all namespaces are processed.
+	namespaces sets.Set
+
+	// ingressInformers hold the ingresses: the shared informer of the client or, if the controller is
+	// scoped, an informer per namespace, so the ingresses of other namespaces are not cached.
+	ingressInformers []cache.SharedIndexInformer
+	// informerFactories create the namespace scoped informers, and are started with the controller.
+	informerFactories []informers.SharedInformerFactory
+	serviceInformer   cache.SharedInformer
+	serviceLister     listerv1.ServiceLister
 	// May be nil if ingress class is not supported in the cluster
 	classes v1beta1.IngressClassInformer
 }
@@ -138,13 +178,22 @@ func NetworkingIngressAvailable(client kube.Client) bool {
 func NewController(client kube.Client, meshWatcher mesh.Holder,
 	options kubecontroller.Options) model.ConfigStoreCache {
 	// queue requires a time duration for a retry delay after a handler error
-	q := queue.NewQueue(1 * time.Second)
+	q := queue.NewQueue(options.GetIngressRetryDelay())
 
 	if ingressNamespace == "" {
 		ingressNamespace = constants.IstioIngressNamespace
 	}
 
-	ingressInformer := client.KubeInformer().Networking().V1beta1().Ingresses().Informer()
+	var ingressInformers []cache.SharedIndexInformer
+	var informerFactories []informers.SharedInformerFactory
+	if len(options.IngressNamespaces) == 0 {
+		ingressInformers = append(ingressInformers, client.KubeInformer().Networking().V1beta1().Ingresses().Informer())
+	}
+	for _, ns := range sets.NewSet(options.IngressNamespaces...).SortedList() {
+		f := informers.NewSharedInformerFactoryWithOptions(client.Kube(), options.ResyncPeriod, informers.WithNamespace(ns))
+		ingressInformers = append(ingressInformers, f.Networking().V1beta1().Ingresses().Informer())
+		informerFactories = append(informerFactories, f)
+	}
 
 	serviceInformer := client.KubeInformer().Core().V1().Services()
 
@@ -158,51 +207,148 @@ func NewController(client kube.Client, meshWatcher mesh.Holder,
 	}
 
 	c := &controller{
-		meshWatcher:     meshWatcher,
-		domainSuffix:    options.DomainSuffix,
-		queue:           q,
-		ingressInformer: ingressInformer,
-		classes:         classes,
-		serviceInformer: serviceInformer.Informer(),
-		serviceLister:   serviceInformer.Lister(),
+		meshWatcher:          meshWatcher,
+		domainSuffix:         options.DomainSuffix,
+		domainSuffixResolver: options.IngressDomainSuffix,
+		queue:                q,
+		maxAttempts:          options.IngressMaxAttempts,
+		namespaces:           sets.NewSet(options.IngressNamespaces...),
+		ingressInformers:     ingressInformers,
+		informerFactories:    informerFactories,
+		classes:              classes,
+		serviceInformer:      serviceInformer.Informer(),
+		serviceLister:        serviceInformer.Lister(),
+	}
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			q.Push(c.withMaxAttempts(func() error {
+				return c.onEvent(nil, obj, model.EventAdd)
+			}))
+		},
+		UpdateFunc: func(old, cur interface{}) {
+			if !reflect.DeepEqual(old, cur) {
+				q.Push(c.withMaxAttempts(func() error {
+					return c.onEvent(old, cur, model.EventUpdate)
+				}))
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			q.Push(c.withMaxAttempts(func() error {
+				return c.onEvent(nil, obj, model.EventDelete)
+			}))
+		},
+	}
+	for _, informer := range ingressInformers {
+		informer.AddEventHandler(handler)
+	}
+
+	// Ingresses are converted on List, so handlers only need to be notified when the ingress settings change.
+	if w, ok := meshWatcher.(mesh.Watcher); ok {
+		mode, class := w.Mesh().IngressControllerMode, w.Mesh().IngressClass
+		w.AddMeshHandler(func() {
+			m := w.Mesh()
+			if m.IngressControllerMode == mode && m.IngressClass == class {
+				return
+			}
+			mode, class = m.IngressControllerMode, m.IngressClass
+			q.Push(c.withMaxAttempts(c.resync))
+		})
 	}
 
-	ingressInformer.AddEventHandler(
-		cache.ResourceEventHandlerFuncs{
+	// Ingresses without class depend on the default IngressClass, so IngressClass changes re-sync all ingresses.
+	if c.classes != nil {
+		c.classes.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 			AddFunc: func(obj interface{}) {
-				q.Push(func() error {
-					return c.onEvent(nil, obj, model.EventAdd)
-				})
+				q.Push(c.withMaxAttempts(c.resync))
 			},
 			UpdateFunc: func(old, cur interface{}) {
 				if !reflect.DeepEqual(old, cur) {
-					q.Push(func() error {
-						return c.onEvent(old, cur, model.EventUpdate)
-					})
+					q.Push(c.withMaxAttempts(c.resync))
 				}
 			},
 			DeleteFunc: func(obj interface{}) {
-				q.Push(func() error {
-					return c.onEvent(nil, obj, model.EventDelete)
-				})
+				q.Push(c.withMaxAttempts(c.resync))
 			},
 		})
+	}
 
 	return c
 }
 
 func (c *controller) shouldProcessIngress(mesh *meshconfig.MeshConfig, i *ingress.Ingress) (bool, error) {
+	if !c.inScope(i.Namespace) {
+		return false, nil
+	}
+	var classes listerv1beta1.IngressClassLister
+	if c.classes != nil {
+		classes = c.classes.Lister()
+	}
+	return shouldProcessIngressWithLister(mesh, i, classes)
+}
+
+// shouldProcessIngressWithLister determines whether the given ingress should be processed, looking up its
+// IngressClass with the lister. The lister is nil if the cluster does not support IngressClass.
+func shouldProcessIngressWithLister(mesh *meshconfig.MeshConfig, i *ingress.Ingress,
+	classes listerv1beta1.IngressClassLister) (bool, error) {
 	var class *ingress.IngressClass
-	if c.classes != nil && i.Spec.IngressClassName != nil {
-		c, err := c.classes.Lister().Get(*i.Spec.IngressClassName)
+	if classes != nil && i.Spec.IngressClassName != nil {
+		c, err := classes.Get(*i.Spec.IngressClassName)
 		if err != nil && !kerrors.IsNotFound(err) {
 			return false, fmt.Errorf("failed to get ingress class %v: %v", i.Spec.IngressClassName, err)
 		}
 		class = c
 	}
+	// The default class only applies while the ingress controller is enabled: once a class is set,
+	// shouldProcessIngressWithClass no longer considers IngressControllerMode.
+	if class == nil && i.Spec.IngressClassName == nil && mesh.IngressControllerMode != meshconfig.MeshConfig_OFF {
+		class = defaultIngressClass(classes)
+	}
 	return shouldProcessIngressWithClass(mesh, i, class), nil
 }
 
+// defaultIngressClass returns the IngressClass marked as default with the is-default-class annotation, if it is
+// handled by Istio. As in Kubernetes, no class is the default when several classes are marked as default.
+func defaultIngressClass(classes listerv1beta1.IngressClassLister) *ingress.IngressClass {
+	if classes == nil {
+		return nil
+	}
+	list, err := classes.List(klabels.Everything())
+	if err != nil {
+		return nil
+	}
+	var def *ingress.IngressClass
+	for _, class := range list {
+		if class.Annotations[ingress.AnnotationIsDefaultIngressClass] != "true" {
+			continue
+		}
+		if def != nil {
+			log.Debugf("multiple default ingress classes: %s and %s, ignoring both", def.Name, class.Name)
+			return nil
+		}
+		def = class
+	}
+	if def == nil || def.Spec.Controller != IstioIngressController {
+		return nil
+	}
+	return def
+}
+
+// domainSuffixFor returns the domain suffix used to convert the ingresses of the given namespace.
+func (c *controller) domainSuffixFor(namespace string) string {
+	if c.domainSuffixResolver != nil {
+		if suffix := c.domainSuffixResolver(namespace); suffix != "" {
+			return suffix
+		}
+	}
+	return c.domainSuffix
+}
+
+// inScope returns true if ingresses in the given namespace should be processed by this controller.
+func (c *controller) inScope(namespace string) bool {
+	return len(c.namespaces) == 0 || c.namespaces.Contains(namespace)
+}
+
 // shouldProcessIngressUpdate checks whether we should renotify registered handlers about an update event
 func (c *controller) shouldProcessIngressUpdate(oldObj, curObj interface{}) (bool, error) {
 	var shouldProcess bool
@@ -242,6 +388,28 @@ func (c *controller) shouldProcessIngressUpdate(oldObj, curObj interface{}) (boo
 	return shouldProcess, nil
 }
 
+// withMaxAttempts wraps a queue task so that it is dropped once it has failed maxAttempts times,
+// rather than being retried forever. Without maxAttempts, the task is retried until it succeeds.
+func (c *controller) withMaxAttempts(task queue.Task) queue.Task {
+	if c.maxAttempts <= 0 {
+		return task
+	}
+	attempts := 0
+	return func() error {
+		err := task()
+		if err == nil {
+			return nil
+		}
+		attempts++
+		if attempts >= c.maxAttempts {
+			log.Errorf("dropping ingress event after %d attempts: %v", attempts, err)
+			ingressRetriesExhausted.Increment()
+			return nil
+		}
+		return err
+	}
+}
+
 func (c *controller) onEvent(oldObj, curObj interface{}, event model.Event) error {
 	if !c.HasSynced() {
 		return errors.New("waiting till full synchronization")
@@ -254,8 +422,27 @@ func (c *controller) onEvent(oldObj, curObj interface{}, event model.Event) erro
 	if !shouldProcess {
 		return nil
 	}
+	if ing, ok := curObj.(*ingress.Ingress); ok && event != model.EventDelete {
+		if process, _ := c.shouldProcessIngress(c.meshWatcher.Mesh(), ing); process {
+			validateIngress(ing, c.serviceLister)
+		}
+	}
 
-	// Trigger updates for Gateway and VirtualService
+	c.notifyHandlers(event)
+	return nil
+}
+
+// resync re-syncs all ingresses, after the mesh ingress settings or the IngressClasses changed.
+func (c *controller) resync() error {
+	if !c.HasSynced() {
+		return errors.New("waiting till full synchronization")
+	}
+	c.notifyHandlers(model.EventUpdate)
+	return nil
+}
+
+// notifyHandlers triggers updates for Gateway and VirtualService.
+func (c *controller) notifyHandlers(event model.Event) {
 	// TODO: we could be smarter here and only trigger when real changes were found
 	for _, f := range c.virtualServiceHandlers {
 		f(config.Config{}, config.Config{
@@ -271,8 +458,6 @@ func (c *controller) onEvent(oldObj, curObj interface{}, event model.Event) erro
 			},
 		}, event)
 	}
-
-	return nil
 }
 
 func (c *controller) RegisterEventHandler(kind config.GroupVersionKind, f func(config.Config, config.Config, model.Event)) {
@@ -289,18 +474,27 @@ func (c *controller) SetWatchErrorHandler(handler func(r *cache.Reflector, err e
 	if err := c.serviceInformer.SetWatchErrorHandler(handler); err != nil {
 		errs = multierror.Append(err, errs)
 	}
-	if err := c.ingressInformer.SetWatchErrorHandler(handler); err != nil {
-		errs = multierror.Append(err, errs)
+	for _, informer := range c.ingressInformers {
+		if err := informer.SetWatchErrorHandler(handler); err != nil {
+			errs = multierror.Append(err, errs)
+		}
 	}
 	return errs
 }
 
 func (c *controller) HasSynced() bool {
-	return c.ingressInformer.HasSynced() && c.serviceInformer.HasSynced() &&
-		(c.classes == nil || c.classes.Informer().HasSynced())
+	for _, informer := range c.ingressInformers {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+	return c.serviceInformer.HasSynced() && (c.classes == nil || c.classes.Informer().HasSynced())
 }
 
 func (c *controller) Run(stop <-chan struct{}) {
+	for _, f := range c.informerFactories {
+		f.Start(stop)
+	}
 	if !cache.WaitForCacheSync(stop, c.HasSynced) {
 		log.Error("Failed to sync controller cache")
 		return
@@ -347,7 +541,11 @@ func (c *controller) List(typ config.GroupVersionKind, namespace string) ([]conf
 
 	ingressByHost := map[string]*config.Config{}
 
-	for _, ingress := range sortIngressByCreationTime(c.ingressInformer.GetStore().List()) {
+	var ingresses []interface{}
+	for _, informer := range c.ingressInformers {
+		ingresses = append(ingresses, informer.GetStore().List()...)
+	}
+	for _, ingress := range sortIngressByCreationTime(ingresses) {
 		if namespace != "" && namespace != ingress.Namespace {
 			continue
 		}
@@ -361,9 +559,9 @@ func (c *controller) List(typ config.GroupVersionKind, namespace string) ([]conf
 
 		switch typ {
 		case gvk.VirtualService:
-			ConvertIngressVirtualService(*ingress, c.domainSuffix, ingressByHost, c.serviceLister)
+			ConvertIngressVirtualService(*ingress, c.domainSuffixFor(ingress.Namespace), ingressByHost, c.serviceLister)
 		case gvk.Gateway:
-			gateways := ConvertIngressV1alpha3(*ingress, c.meshWatcher.Mesh(), c.domainSuffix)
+			gateways := ConvertIngressV1alpha3(*ingress, c.meshWatcher.Mesh(), c.domainSuffixFor(ingress.Namespace))
 			out = append(out, gateways)
 		}
 	}
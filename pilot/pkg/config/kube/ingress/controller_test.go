@@ -0,0 +1,364 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ingress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ingress "k8s.io/api/networking/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	kubeversion "k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/model"
+	kubecontroller "istio.io/istio/pilot/pkg/serviceregistry/kube/controller"
+	"istio.io/istio/pkg/config"
+	"istio.io/istio/pkg/config/constants"
+	"istio.io/istio/pkg/config/mesh"
+	"istio.io/istio/pkg/config/schema/gvk"
+	kubelib "istio.io/istio/pkg/kube"
+	"istio.io/istio/pkg/test/util/retry"
+)
+
+func TestWithMaxAttempts(t *testing.T) {
+	c := &controller{maxAttempts: 3}
+	calls := 0
+	task := c.withMaxAttempts(func() error {
+		calls++
+		return errors.New("failed")
+	})
+	for i := 1; i < 3; i++ {
+		if err := task(); err == nil {
+			t.Fatalf("attempt %d: expected error to trigger a retry", i)
+		}
+	}
+	if err := task(); err != nil {
+		t.Fatalf("expected task to be dropped after max attempts, got %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+
+	// Without max attempts, the task is retried until it succeeds.
+	c = &controller{}
+	task = c.withMaxAttempts(func() error {
+		return errors.New("failed")
+	})
+	for i := 0; i < 10; i++ {
+		if err := task(); err == nil {
+			t.Fatalf("attempt %d: expected error to trigger a retry", i)
+		}
+	}
+}
+
+func TestNamespaceScope(t *testing.T) {
+	newIngress := func(name, namespace string) *ingress.Ingress {
+		return &ingress.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: ingress.IngressSpec{
+				Rules: []ingress.IngressRule{{
+					Host: name + ".example.com",
+					IngressRuleValue: ingress.IngressRuleValue{
+						HTTP: &ingress.HTTPIngressRuleValue{
+							Paths: []ingress.HTTPIngressPath{{
+								Path: "/",
+								Backend: ingress.IngressBackend{
+									ServiceName: "svc",
+									ServicePort: intstr.FromInt(80),
+								},
+							}},
+						},
+					},
+				}},
+			},
+		}
+	}
+	client := kubelib.NewFakeClient(newIngress("in", "scoped"), newIngress("out", "other"))
+	m := mesh.DefaultMeshConfig()
+	m.IngressControllerMode = meshconfig.MeshConfig_DEFAULT
+	c := NewController(client, mesh.NewFixedWatcher(&m), kubecontroller.Options{
+		DomainSuffix:      "cluster.local",
+		IngressNamespaces: []string{"scoped"},
+	}).(*controller)
+	stop := make(chan struct{})
+	t.Cleanup(func() {
+		close(stop)
+	})
+	client.RunAndWait(stop)
+	go c.Run(stop)
+	retry.UntilOrFail(t, c.HasSynced, retry.Timeout(time.Second*5))
+
+	// Only the scoped namespace is watched, so ingresses elsewhere are never cached.
+	var cached []string
+	for _, informer := range c.ingressInformers {
+		for _, obj := range informer.GetStore().List() {
+			cached = append(cached, obj.(*ingress.Ingress).Name)
+		}
+	}
+	if len(cached) != 1 || cached[0] != "in" {
+		t.Fatalf("expected only the in-scope ingress to be cached, got %v", cached)
+	}
+
+	gateways, err := c.List(gvk.Gateway, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gateways) != 1 || gateways[0].Name != "in-"+constants.IstioIngressGatewayName {
+		t.Fatalf("expected only the in-scope ingress to be converted, got %v", gateways)
+	}
+	for _, ing := range []*ingress.Ingress{newIngress("in", "scoped"), newIngress("out", "other")} {
+		process, err := c.shouldProcessIngress(&m, ing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := ing.Namespace == "scoped"; process != want {
+			t.Errorf("shouldProcessIngress(%s/%s) = %v, want %v", ing.Namespace, ing.Name, process, want)
+		}
+	}
+}
+
+func TestMeshChangeResync(t *testing.T) {
+	client := kubelib.NewFakeClient()
+	m := mesh.DefaultMeshConfig()
+	w := mesh.NewFixedWatcher(&m).(*mesh.InternalWatcher)
+	c := NewController(client, w, kubecontroller.Options{DomainSuffix: "cluster.local"}).(*controller)
+	var updates int32
+	c.RegisterEventHandler(gvk.VirtualService, func(_, _ config.Config, event model.Event) {
+		if event == model.EventUpdate {
+			atomic.AddInt32(&updates, 1)
+		}
+	})
+	stop := make(chan struct{})
+	t.Cleanup(func() {
+		close(stop)
+	})
+	client.RunAndWait(stop)
+	go c.Run(stop)
+
+	// Unrelated mesh changes do not re-sync ingresses.
+	unrelated := mesh.DefaultMeshConfig()
+	unrelated.EnableTracing = !m.EnableTracing
+	w.HandleMeshConfig(&unrelated)
+
+	changed := mesh.DefaultMeshConfig()
+	changed.IngressClass = "istio,istio-internal"
+	w.HandleMeshConfig(&changed)
+	retry.UntilOrFail(t, func() bool {
+		return atomic.LoadInt32(&updates) == 1
+	}, retry.Timeout(5*time.Second))
+
+	// Setting the same ingress settings again is a no-op.
+	w.HandleMeshConfig(&changed)
+	time.Sleep(100 * time.Millisecond)
+	if got := atomic.LoadInt32(&updates); got != 1 {
+		t.Fatalf("expected a single re-sync, got %d", got)
+	}
+}
+
+func TestListMergesIngressesByHost(t *testing.T) {
+	newIngress := func(name, path string, created time.Time) *ingress.Ingress {
+		pathType := ingress.PathTypePrefix
+		return &ingress.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", CreationTimestamp: metav1.NewTime(created)},
+			Spec: ingress.IngressSpec{
+				Rules: []ingress.IngressRule{{
+					Host: "example.com",
+					IngressRuleValue: ingress.IngressRuleValue{
+						HTTP: &ingress.HTTPIngressRuleValue{
+							Paths: []ingress.HTTPIngressPath{{
+								Path:     path,
+								PathType: &pathType,
+								Backend: ingress.IngressBackend{
+									ServiceName: name,
+									ServicePort: intstr.FromInt(80),
+								},
+							}},
+						},
+					},
+				}},
+			},
+		}
+	}
+	now := time.Now()
+	// The older ingress is named last, to check routes follow creation time rather than name.
+	client := kubelib.NewFakeClient(newIngress("a", "/a", now), newIngress("b", "/b", now.Add(-time.Hour)))
+	m := mesh.DefaultMeshConfig()
+	m.IngressControllerMode = meshconfig.MeshConfig_DEFAULT
+	c := NewController(client, mesh.NewFixedWatcher(&m), kubecontroller.Options{DomainSuffix: "cluster.local"}).(*controller)
+	stop := make(chan struct{})
+	t.Cleanup(func() {
+		close(stop)
+	})
+	client.RunAndWait(stop)
+
+	vss, err := c.List(gvk.VirtualService, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vss) != 1 {
+		t.Fatalf("expected a single virtual service for example.com, got %v", vss)
+	}
+	var paths []string
+	for _, route := range vss[0].Spec.(*networking.VirtualService).Http {
+		paths = append(paths, route.Match[0].GetUri().GetPrefix())
+	}
+	if want := []string{"/b/", "/a/"}; !reflect.DeepEqual(paths, want) {
+		t.Fatalf("got routes %v, want %v", paths, want)
+	}
+}
+
+func TestDefaultIngressClass(t *testing.T) {
+	ing := &ingress.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "default"},
+	}
+	istioClass := &ingress.IngressClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "istio"},
+		Spec:       ingress.IngressClassSpec{Controller: IstioIngressController},
+	}
+	client := kubelib.NewFakeClient(ing, istioClass)
+	client.Kube().Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &kubeversion.Info{GitVersion: "v1.18.0"}
+	m := mesh.DefaultMeshConfig()
+	m.IngressControllerMode = meshconfig.MeshConfig_STRICT
+	c := NewController(client, mesh.NewFixedWatcher(&m), kubecontroller.Options{DomainSuffix: "cluster.local"}).(*controller)
+	var updates int32
+	c.RegisterEventHandler(gvk.VirtualService, func(_, _ config.Config, event model.Event) {
+		atomic.AddInt32(&updates, 1)
+	})
+	stop := make(chan struct{})
+	t.Cleanup(func() {
+		close(stop)
+	})
+	client.RunAndWait(stop)
+	go c.Run(stop)
+
+	expectProcessed := func(want bool) {
+		t.Helper()
+		retry.UntilSuccessOrFail(t, func() error {
+			got, err := c.shouldProcessIngress(&m, ing)
+			if err != nil {
+				return err
+			}
+			if got != want {
+				return fmt.Errorf("got processed %v, want %v", got, want)
+			}
+			return nil
+		}, retry.Timeout(5*time.Second))
+	}
+	classes := client.Kube().NetworkingV1beta1().IngressClasses()
+
+	// Ingresses without class are not processed in STRICT mode, unless Istio's class is the default.
+	expectProcessed(false)
+
+	istioClass.Annotations = map[string]string{ingress.AnnotationIsDefaultIngressClass: "true"}
+	if _, err := classes.Update(context.TODO(), istioClass, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expectProcessed(true)
+	retry.UntilOrFail(t, func() bool {
+		return atomic.LoadInt32(&updates) > 0
+	}, retry.Timeout(5*time.Second))
+
+	// The default class is ignored while the ingress controller is off.
+	off := mesh.DefaultMeshConfig()
+	off.IngressControllerMode = meshconfig.MeshConfig_OFF
+	if process, err := c.shouldProcessIngress(&off, ing); err != nil || process {
+		t.Fatalf("expected ingress not to be processed with the ingress controller off, got %v, %v", process, err)
+	}
+
+	// Several default classes are ambiguous.
+	otherClass := &ingress.IngressClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "other",
+			Annotations: map[string]string{ingress.AnnotationIsDefaultIngressClass: "true"},
+		},
+		Spec: ingress.IngressClassSpec{Controller: "example.com/ingress-controller"},
+	}
+	if _, err := classes.Create(context.TODO(), otherClass, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expectProcessed(false)
+
+	// Another controller's default class does not select Istio.
+	if err := classes.Delete(context.TODO(), istioClass.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expectProcessed(false)
+}
+
+func TestDomainSuffixResolver(t *testing.T) {
+	newIngress := func(namespace string) *ingress.Ingress {
+		return &ingress.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: "ing", Namespace: namespace},
+			Spec: ingress.IngressSpec{
+				Rules: []ingress.IngressRule{{
+					Host: namespace + ".example.com",
+					IngressRuleValue: ingress.IngressRuleValue{
+						HTTP: &ingress.HTTPIngressRuleValue{
+							Paths: []ingress.HTTPIngressPath{{
+								Path: "/",
+								Backend: ingress.IngressBackend{
+									ServiceName: "svc",
+									ServicePort: intstr.FromInt(80),
+								},
+							}},
+						},
+					},
+				}},
+			},
+		}
+	}
+	client := kubelib.NewFakeClient(newIngress("tenant-a"), newIngress("tenant-b"), newIngress("other"))
+	m := mesh.DefaultMeshConfig()
+	m.IngressControllerMode = meshconfig.MeshConfig_DEFAULT
+	suffixes := map[string]string{"tenant-a": "a.local", "tenant-b": "b.local"}
+	c := NewController(client, mesh.NewFixedWatcher(&m), kubecontroller.Options{
+		DomainSuffix: "cluster.local",
+		IngressDomainSuffix: func(namespace string) string {
+			return suffixes[namespace]
+		},
+	}).(*controller)
+	stop := make(chan struct{})
+	t.Cleanup(func() {
+		close(stop)
+	})
+	client.RunAndWait(stop)
+
+	vss, err := c.List(gvk.VirtualService, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, vs := range vss {
+		got[vs.Namespace] = vs.Spec.(*networking.VirtualService).Http[0].Route[0].Destination.Host
+	}
+	want := map[string]string{
+		"tenant-a": "svc.tenant-a.svc.a.local",
+		"tenant-b": "svc.tenant-b.svc.b.local",
+		// Namespaces without resolved suffix use the global domain suffix.
+		"other": "svc.other.svc.cluster.local",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got destinations %v, want %v", got, want)
+	}
+}
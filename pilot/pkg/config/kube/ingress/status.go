@@ -27,10 +27,13 @@ import (
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	listerv1 "k8s.io/client-go/listers/core/v1"
 	listerv1beta1 "k8s.io/client-go/listers/networking/v1beta1"
 
+	kubecontroller "istio.io/istio/pilot/pkg/serviceregistry/kube/controller"
+	"istio.io/istio/pilot/pkg/util/sets"
 	"istio.io/istio/pkg/config/mesh"
 	kubelib "istio.io/istio/pkg/kube"
 	"istio.io/istio/pkg/queue"
@@ -47,21 +50,42 @@ type StatusSyncer struct {
 	client     kubernetes.Interface
 
 	queue              queue.Instance
-	ingressLister      listerv1beta1.IngressLister
 	podLister          listerv1.PodLister
 	serviceLister      listerv1.ServiceLister
 	nodeLister         listerv1.NodeLister
 	ingressClassLister listerv1beta1.IngressClassLister
+
+	// namespaces the syncer is scoped to, as the controller. If empty, all namespaces are processed.
+	namespaces sets.Set
+	// ingressListers list the ingresses: the shared lister of the client or, if the syncer is scoped,
+	// a lister per namespace.
+	ingressListers []listerv1beta1.IngressLister
+	// informerFactories create the namespace scoped informers, and are started with the syncer.
+	informerFactories []informers.SharedInformerFactory
 }
 
 // Run the syncer until stopCh is closed
 func (s *StatusSyncer) Run(stopCh <-chan struct{}) {
+	for _, f := range s.informerFactories {
+		f.Start(stopCh)
+	}
 	go s.queue.Run(stopCh)
 	go s.runUpdateStatus(stopCh)
 }
 
 // NewStatusSyncer creates a new instance
-func NewStatusSyncer(meshHolder mesh.Holder, client kubelib.Client) *StatusSyncer {
+func NewStatusSyncer(meshHolder mesh.Holder, client kubelib.Client, options kubecontroller.Options) *StatusSyncer {
+	var ingressListers []listerv1beta1.IngressLister
+	var informerFactories []informers.SharedInformerFactory
+	if len(options.IngressNamespaces) == 0 {
+		ingressListers = append(ingressListers, client.KubeInformer().Networking().V1beta1().Ingresses().Lister())
+	}
+	for _, ns := range sets.NewSet(options.IngressNamespaces...).SortedList() {
+		f := informers.NewSharedInformerFactoryWithOptions(client.Kube(), options.ResyncPeriod, informers.WithNamespace(ns))
+		ingressListers = append(ingressListers, f.Networking().V1beta1().Ingresses().Lister())
+		informerFactories = append(informerFactories, f)
+	}
+
 	// as in controller, ingressClassListener can be nil since not supported in k8s version <1.18
 	var ingressClassLister listerv1beta1.IngressClassLister
 	if NetworkingIngressAvailable(client) {
@@ -74,7 +98,9 @@ func NewStatusSyncer(meshHolder mesh.Holder, client kubelib.Client) *StatusSynce
 	return &StatusSyncer{
 		meshHolder:         meshHolder,
 		client:             client,
-		ingressLister:      client.KubeInformer().Networking().V1beta1().Ingresses().Lister(),
+		namespaces:         sets.NewSet(options.IngressNamespaces...),
+		ingressListers:     ingressListers,
+		informerFactories:  informerFactories,
 		podLister:          client.KubeInformer().Core().V1().Pods().Lister(),
 		serviceLister:      client.KubeInformer().Core().V1().Services().Lister(),
 		nodeLister:         client.KubeInformer().Core().V1().Nodes().Lister(),
@@ -120,9 +146,13 @@ func (s *StatusSyncer) runUpdateStatus(stop <-chan struct{}) {
 
 // updateStatus updates ingress status with the list of IP
 func (s *StatusSyncer) updateStatus(status []coreV1.LoadBalancerIngress) error {
-	l, err := s.ingressLister.List(labels.Everything())
-	if err != nil {
-		return err
+	var l []*v1beta1.Ingress
+	for _, lister := range s.ingressListers {
+		ingresses, err := lister.List(labels.Everything())
+		if err != nil {
+			return err
+		}
+		l = append(l, ingresses...)
 	}
 	for _, currIng := range l {
 		shouldTarget, err := s.shouldTargetIngress(currIng)
@@ -267,15 +297,12 @@ func ingressSliceEqual(lhs, rhs []coreV1.LoadBalancerIngress) bool {
 	return true
 }
 
-// shouldTargetIngress determines whether the status watcher should target a given ingress resource
+// shouldTargetIngress determines whether the status watcher should target a given ingress resource. As in the
+// controller, only ingresses in scope are targeted, and ingresses without class are targeted if the default
+// IngressClass is handled by Istio.
 func (s *StatusSyncer) shouldTargetIngress(ingress *v1beta1.Ingress) (bool, error) {
-	var ingressClass *v1beta1.IngressClass
-	if s.ingressClassLister != nil && ingress.Spec.IngressClassName != nil {
-		c, err := s.ingressClassLister.Get(*ingress.Spec.IngressClassName)
-		if err != nil && !kerrors.IsNotFound(err) {
-			return false, err
-		}
-		ingressClass = c
+	if len(s.namespaces) > 0 && !s.namespaces.Contains(ingress.Namespace) {
+		return false, nil
 	}
-	return shouldProcessIngressWithClass(s.meshHolder.Mesh(), ingress, ingressClass), nil
+	return shouldProcessIngressWithLister(s.meshHolder.Mesh(), ingress, s.ingressClassLister)
 }
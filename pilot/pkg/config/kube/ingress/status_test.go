@@ -16,14 +16,23 @@ package ingress
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 
 	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/api/networking/v1beta1"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeversion "k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
 
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	kubecontroller "istio.io/istio/pilot/pkg/serviceregistry/kube/controller"
 	"istio.io/istio/pkg/config/mesh"
 	kubelib "istio.io/istio/pkg/kube"
+	"istio.io/istio/pkg/test/util/retry"
 )
 
 var (
@@ -115,7 +124,7 @@ func makeStatusSyncer(t *testing.T) *StatusSyncer {
 
 	client := kubelib.NewFakeClient()
 	setupFake(t, client)
-	sync := NewStatusSyncer(fakeMeshHolder("istio-ingress"), client)
+	sync := NewStatusSyncer(fakeMeshHolder("istio-ingress"), client, kubecontroller.Options{})
 	stop := make(chan struct{})
 	client.RunAndWait(stop)
 	t.Cleanup(func() {
@@ -182,3 +191,89 @@ func TestRunningAddressesWithPod(t *testing.T) {
 		t.Errorf("Address is not correctly set to node ip %v %v", address, nodeIP)
 	}
 }
+
+func TestStatusDefaultIngressClass(t *testing.T) {
+	ing := &v1beta1.Ingress{
+		ObjectMeta: metaV1.ObjectMeta{Name: "foo", Namespace: testNamespace},
+	}
+	istioClass := &v1beta1.IngressClass{
+		ObjectMeta: metaV1.ObjectMeta{Name: "istio"},
+		Spec:       v1beta1.IngressClassSpec{Controller: IstioIngressController},
+	}
+	client := kubelib.NewFakeClient(ing, istioClass)
+	client.Kube().Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &kubeversion.Info{GitVersion: "v1.18.0"}
+	m := mesh.DefaultMeshConfig()
+	m.IngressControllerMode = meshconfig.MeshConfig_STRICT
+	syncer := NewStatusSyncer(mesh.NewFixedWatcher(&m), client, kubecontroller.Options{})
+	stop := make(chan struct{})
+	t.Cleanup(func() {
+		close(stop)
+	})
+	client.RunAndWait(stop)
+
+	status := sliceToStatus([]string{serviceIP})
+	ingresses := client.Kube().NetworkingV1beta1().Ingresses(testNamespace)
+	expectStatus := func(want []coreV1.LoadBalancerIngress) {
+		t.Helper()
+		retry.UntilSuccessOrFail(t, func() error {
+			if err := syncer.updateStatus(status); err != nil {
+				return err
+			}
+			got, err := ingresses.Get(context.TODO(), ing.Name, metaV1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(got.Status.LoadBalancer.Ingress, want) {
+				return fmt.Errorf("got status %v, want %v", got.Status.LoadBalancer.Ingress, want)
+			}
+			return nil
+		}, retry.Timeout(5*time.Second))
+	}
+
+	// Ingresses without class are not targeted in STRICT mode, unless Istio's class is the default.
+	expectStatus(nil)
+
+	istioClass.Annotations = map[string]string{v1beta1.AnnotationIsDefaultIngressClass: "true"}
+	if _, err := client.Kube().NetworkingV1beta1().IngressClasses().Update(context.TODO(), istioClass, metaV1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(status)
+}
+
+func TestStatusNamespaceScope(t *testing.T) {
+	newIngress := func(namespace string) *v1beta1.Ingress {
+		return &v1beta1.Ingress{
+			ObjectMeta: metaV1.ObjectMeta{Name: "foo", Namespace: namespace},
+		}
+	}
+	client := kubelib.NewFakeClient(newIngress("tenant-a"), newIngress("other"))
+	m := mesh.DefaultMeshConfig()
+	m.IngressControllerMode = meshconfig.MeshConfig_DEFAULT
+	syncer := NewStatusSyncer(mesh.NewFixedWatcher(&m), client, kubecontroller.Options{IngressNamespaces: []string{"tenant-a"}})
+	stop := make(chan struct{})
+	t.Cleanup(func() {
+		close(stop)
+	})
+	client.RunAndWait(stop)
+	for _, f := range syncer.informerFactories {
+		f.Start(stop)
+		f.WaitForCacheSync(stop)
+	}
+
+	status := sliceToStatus([]string{serviceIP})
+	if err := syncer.updateStatus(status); err != nil {
+		t.Fatal(err)
+	}
+	for namespace, want := range map[string][]coreV1.LoadBalancerIngress{"tenant-a": status, "other": nil} {
+		got, err := client.Kube().NetworkingV1beta1().Ingresses(namespace).Get(context.TODO(), "foo", metaV1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Status.LoadBalancer.Ingress, want) {
+			t.Errorf("ingress in namespace %s: got status %v, want %v", namespace, got.Status.LoadBalancer.Ingress, want)
+		}
+	}
+	if target, err := syncer.shouldTargetIngress(newIngress("other")); err != nil || target {
+		t.Fatalf("expected ingress out of scope not to be targeted, got %v, %v", target, err)
+	}
+}
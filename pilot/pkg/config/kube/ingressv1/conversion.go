@@ -42,6 +42,51 @@ const (
 
 var errNotFound = errors.New("item not found")
 
+// Reasons an ingress rule or path is reported as invalid.
+const (
+	invalidNoPaths         = "no_paths"
+	invalidNoBackend       = "no_backend"
+	invalidServiceNotFound = "service_not_found"
+)
+
+// reportInvalidIngress logs an invalid part of an ingress and records it in pilot_k8s_ingress_invalid.
+// Conversion is best effort, so the valid parts of the ingress are still converted.
+func reportInvalidIngress(namespace, name, reason, format string, args ...interface{}) {
+	log.Warnf("invalid ingress %s/%s: %s", namespace, name, fmt.Sprintf(format, args...))
+	ingressInvalid.With(reasonTag.Value(reason)).Increment()
+}
+
+// validateIngress reports the invalid rules and paths of the ingress. Ingresses are converted on every
+// List, so they are validated once per ingress event instead, and conversion only logs at debug level.
+func validateIngress(ingress *knetworking.Ingress, serviceLister listerv1.ServiceLister) {
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			reportInvalidIngress(ingress.Namespace, ingress.Name, invalidNoPaths, "no paths defined for host %q", rule.Host)
+			continue
+		}
+		for _, httpPath := range rule.HTTP.Paths {
+			backend := httpPath.Backend
+			if backend.Service == nil {
+				reportInvalidIngress(ingress.Namespace, ingress.Name, invalidNoBackend, "no backend defined for path %q of host %q",
+					httpPath.Path, rule.Host)
+				continue
+			}
+			// The service may be created later, so the path is still converted.
+			if _, err := serviceLister.Services(ingress.Namespace).Get(backend.Service.Name); err != nil {
+				reportInvalidIngress(ingress.Namespace, ingress.Name, invalidServiceNotFound, "service %q of path %q of host %q not found",
+					backend.Service.Name, httpPath.Path, rule.Host)
+			}
+			if backend.Service.Port.Number == 0 {
+				if _, err := resolveNamedPort(&backend, ingress.Namespace, serviceLister); err != nil {
+					log.Warnf("invalid ingress %s/%s: failed to resolve named port %s of path %q of host %q: %v",
+						ingress.Namespace, ingress.Name, backend.Service.Port.Name, httpPath.Path, rule.Host, err)
+					ingressUnresolvedNamedPorts.Increment()
+				}
+			}
+		}
+	}
+}
+
 // EncodeIngressRuleName encodes an ingress rule name for a given ingress resource name,
 // as well as the position of the rule and path specified within it, counting from 1.
 // ruleNum == pathNum == 0 indicates the default backend specified for an ingress.
@@ -135,7 +180,7 @@ func ConvertIngressVirtualService(ingress knetworking.Ingress, domainSuffix stri
 
 	for _, rule := range ingress.Spec.Rules {
 		if rule.HTTP == nil {
-			log.Infof("invalid ingress rule %s:%s for host %q, no paths defined", ingress.Namespace, ingress.Name, rule.Host)
+			log.Debugf("invalid ingress %s/%s: no paths defined for host %q", ingress.Namespace, ingress.Name, rule.Host)
 			continue
 		}
 
@@ -180,13 +225,15 @@ func ConvertIngressVirtualService(ingress knetworking.Ingress, domainSuffix stri
 
 			httpRoute := ingressBackendToHTTPRoute(&httpPath.Backend, ingress.Namespace, domainSuffix, serviceLister)
 			if httpRoute == nil {
-				log.Infof("invalid ingress rule %s:%s for host %q, no backend defined for path", ingress.Namespace, ingress.Name, rule.Host)
+				log.Debugf("invalid ingress %s/%s: no backend could be converted for path %q of host %q",
+					ingress.Namespace, ingress.Name, httpPath.Path, rule.Host)
 				continue
 			}
 			httpRoute.Match = []*networking.HTTPMatchRequest{httpMatch}
 			httpRoutes = append(httpRoutes, httpRoute)
 		}
 
+		sortHTTPRoutes(httpRoutes)
 		virtualService.Http = httpRoutes
 
 		virtualServiceConfig := config.Config{
@@ -203,17 +250,7 @@ func ConvertIngressVirtualService(ingress knetworking.Ingress, domainSuffix stri
 		if f {
 			vs := old.Spec.(*networking.VirtualService)
 			vs.Http = append(vs.Http, httpRoutes...)
-			sort.SliceStable(vs.Http, func(i, j int) bool {
-				r1 := vs.Http[i].Match[0].GetUri()
-				r2 := vs.Http[j].Match[0].GetUri()
-				_, r1Ex := r1.GetMatchType().(*networking.StringMatch_Exact)
-				_, r2Ex := r2.GetMatchType().(*networking.StringMatch_Exact)
-				// TODO: default at the end
-				if r1Ex && !r2Ex {
-					return true
-				}
-				return false
-			})
+			sortHTTPRoutes(vs.Http)
 		} else {
 			ingressByHost[host] = &virtualServiceConfig
 		}
@@ -227,6 +264,25 @@ func ConvertIngressVirtualService(ingress knetworking.Ingress, domainSuffix stri
 	}
 }
 
+// sortHTTPRoutes orders the routes so that more specific paths take precedence: exact matches first,
+// then prefix matches from the longest to the shortest prefix, so "/" does not shadow other paths.
+func sortHTTPRoutes(routes []*networking.HTTPRoute) {
+	sort.SliceStable(routes, func(i, j int) bool {
+		r1 := routes[i].Match[0].GetUri()
+		r2 := routes[j].Match[0].GetUri()
+		_, r1Ex := r1.GetMatchType().(*networking.StringMatch_Exact)
+		_, r2Ex := r2.GetMatchType().(*networking.StringMatch_Exact)
+		if r1Ex != r2Ex {
+			return r1Ex
+		}
+		if r1Ex {
+			return false
+		}
+		// Routes without path match are treated as an empty prefix, matching everything.
+		return len(r1.GetPrefix()) > len(r2.GetPrefix())
+	})
+}
+
 func ingressBackendToHTTPRoute(backend *knetworking.IngressBackend, namespace string, domainSuffix string,
 	serviceLister listerv1.ServiceLister) *networking.HTTPRoute {
 	if backend == nil {
@@ -244,10 +300,17 @@ func ingressBackendToHTTPRoute(backend *knetworking.IngressBackend, namespace st
 	} else {
 		resolvedPort, err := resolveNamedPort(backend, namespace, serviceLister)
 		if err != nil {
-			log.Infof("failed to resolve named port %s, error: %v", backend.Service.Port.Name, err)
-			return nil
+			// The service may not be updated yet. A single port service is still routed to without port.
+			// Otherwise the gateway port would be used, so the path is dropped instead.
+			if !singlePortService(backend.Service.Name, namespace, serviceLister) {
+				log.Debugf("failed to resolve named port %s, dropping path: %v", backend.Service.Port.Name, err)
+				return nil
+			}
+			log.Debugf("failed to resolve named port %s, routing to the single service port: %v", backend.Service.Port.Name, err)
+			port = nil
+		} else {
+			port.Number = uint32(resolvedPort)
 		}
-		port.Number = uint32(resolvedPort)
 	}
 
 	return &networking.HTTPRoute{
@@ -276,6 +339,12 @@ func resolveNamedPort(backend *knetworking.IngressBackend, namespace string, ser
 	return 0, errNotFound
 }
 
+// singlePortService returns true if the service exists and has exactly one port.
+func singlePortService(name, namespace string, serviceLister listerv1.ServiceLister) bool {
+	svc, err := serviceLister.Services(namespace).Get(name)
+	return err == nil && len(svc.Spec.Ports) == 1
+}
+
 // shouldProcessIngress determines whether the given knetworking resource should be processed
 // by the controller, based on its knetworking class annotation or, in more recent versions of
 // kubernetes (v1.18+), based on the Ingress's specified IngressClass
@@ -286,9 +355,9 @@ func shouldProcessIngressWithClass(mesh *meshconfig.MeshConfig, ingress *knetwor
 		case meshconfig.MeshConfig_OFF:
 			return false
 		case meshconfig.MeshConfig_STRICT:
-			return class == mesh.IngressClass
+			return acceptedIngressClass(mesh, class)
 		case meshconfig.MeshConfig_DEFAULT:
-			return class == mesh.IngressClass
+			return acceptedIngressClass(mesh, class)
 		default:
 			log.Warnf("invalid ingress synchronization mode: %v", mesh.IngressControllerMode)
 			return false
@@ -310,6 +379,17 @@ func shouldProcessIngressWithClass(mesh *meshconfig.MeshConfig, ingress *knetwor
 	}
 }
 
+// acceptedIngressClass returns true if the given ingress class is accepted by the mesh. mesh.IngressClass may
+// list multiple accepted classes, separated by commas.
+func acceptedIngressClass(mesh *meshconfig.MeshConfig, class string) bool {
+	for _, accepted := range strings.Split(mesh.IngressClass, ",") {
+		if strings.TrimSpace(accepted) == class && class != "" {
+			return true
+		}
+	}
+	return false
+}
+
 func createFallbackStringMatch(s string) *networking.StringMatch {
 	if s == "" {
 		return nil
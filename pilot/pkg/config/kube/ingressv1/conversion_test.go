@@ -18,12 +18,14 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"reflect"
 	"sort"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/ghodss/yaml"
+	"go.opencensus.io/stats/view"
 	coreV1 "k8s.io/api/core/v1"
 	knetworking "k8s.io/api/networking/v1"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -38,6 +40,7 @@ import (
 	meshconfig "istio.io/api/mesh/v1alpha1"
 	networking "istio.io/api/networking/v1alpha3"
 	"istio.io/istio/pilot/pkg/config/kube/crd"
+	"istio.io/istio/pilot/pkg/serviceregistry/kube"
 	"istio.io/istio/pilot/test/util"
 	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/mesh"
@@ -314,6 +317,7 @@ func TestIngressClass(t *testing.T) {
 		annotation    string
 		ingressClass  *knetworking.IngressClass
 		ingressMode   meshconfig.MeshConfig_IngressControllerMode
+		meshClass     string
 		shouldProcess bool
 	}{
 		// Annotation
@@ -339,6 +343,14 @@ func TestIngressClass(t *testing.T) {
 		{ingressMode: meshconfig.MeshConfig_STRICT, ingressClass: ingressClassIstio, annotation: "nginx", shouldProcess: false},
 		{ingressMode: meshconfig.MeshConfig_STRICT, ingressClass: ingressClassOther, annotation: istio, shouldProcess: true},
 		{ingressMode: -1, shouldProcess: false},
+
+		// Multiple accepted classes
+		{ingressMode: meshconfig.MeshConfig_STRICT, meshClass: "istio, istio-internal", annotation: "istio", shouldProcess: true},
+		{ingressMode: meshconfig.MeshConfig_STRICT, meshClass: "istio, istio-internal", annotation: "istio-internal", shouldProcess: true},
+		{ingressMode: meshconfig.MeshConfig_STRICT, meshClass: "istio, istio-internal", annotation: "nginx", shouldProcess: false},
+		{ingressMode: meshconfig.MeshConfig_STRICT, meshClass: "istio, istio-internal", annotation: "", shouldProcess: false},
+		{ingressMode: meshconfig.MeshConfig_DEFAULT, meshClass: "istio,istio-internal", annotation: "istio-internal", shouldProcess: true},
+		{ingressMode: meshconfig.MeshConfig_STRICT, meshClass: "istio,", annotation: "", shouldProcess: false},
 	}
 
 	for i, c := range cases {
@@ -365,6 +377,9 @@ func TestIngressClass(t *testing.T) {
 
 			mesh := mesh.DefaultMeshConfig()
 			mesh.IngressControllerMode = c.ingressMode
+			if c.meshClass != "" {
+				mesh.IngressClass = c.meshClass
+			}
 
 			if c.annotation != "" {
 				ing.Annotations["kubernetes.io/ingress.class"] = c.annotation
@@ -462,3 +477,264 @@ func createFakeLister(ctx context.Context, objects ...runtime.Object) listerv1.S
 	cache.WaitForCacheSync(ctx.Done(), svcInformer.HasSynced)
 	return informerFactory.Core().V1().Services().Lister()
 }
+
+func TestRouteOrdering(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newIngress := func(name string, paths map[string]knetworking.PathType) knetworking.Ingress {
+		var httpPaths []knetworking.HTTPIngressPath
+		for path, pathType := range paths {
+			pathType := pathType
+			httpPaths = append(httpPaths, knetworking.HTTPIngressPath{
+				Path:     path,
+				PathType: &pathType,
+				Backend: knetworking.IngressBackend{
+					Service: &knetworking.IngressServiceBackend{
+						Name: "foo",
+						Port: knetworking.ServiceBackendPort{Number: 8000},
+					},
+				},
+			})
+		}
+		return knetworking.Ingress{
+			ObjectMeta: metaV1.ObjectMeta{Name: name, Namespace: "mock"},
+			Spec: knetworking.IngressSpec{
+				Rules: []knetworking.IngressRule{{
+					Host: "my.host.com",
+					IngressRuleValue: knetworking.IngressRuleValue{
+						HTTP: &knetworking.HTTPIngressRuleValue{Paths: httpPaths},
+					},
+				}},
+			},
+		}
+	}
+	serviceLister := createFakeLister(ctx)
+	cfgs := map[string]*config.Config{}
+	ConvertIngressVirtualService(newIngress("first", map[string]knetworking.PathType{
+		"/":    knetworking.PathTypePrefix,
+		"/api": knetworking.PathTypePrefix,
+	}), "mydomain", cfgs, serviceLister)
+
+	// Routes of a single ingress are ordered too.
+	if got, want := routeMatches(cfgs["my.host.com"]), []string{"prefix:/api/", "prefix:/"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got routes %v, want %v", got, want)
+	}
+
+	ConvertIngressVirtualService(newIngress("second", map[string]knetworking.PathType{
+		"/api/v2": knetworking.PathTypePrefix,
+		"/health": knetworking.PathTypeExact,
+	}), "mydomain", cfgs, serviceLister)
+
+	want := []string{"exact:/health", "prefix:/api/v2/", "prefix:/api/", "prefix:/"}
+	if got := routeMatches(cfgs["my.host.com"]); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got routes %v, want %v", got, want)
+	}
+}
+
+func routeMatches(cfg *config.Config) []string {
+	var matches []string
+	for _, route := range cfg.Spec.(*networking.VirtualService).Http {
+		uri := route.Match[0].GetUri()
+		if uri.GetExact() != "" {
+			matches = append(matches, "exact:"+uri.GetExact())
+		} else {
+			matches = append(matches, "prefix:"+uri.GetPrefix())
+		}
+	}
+	return matches
+}
+
+func TestIngressBackendPort(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serviceLister := createFakeLister(ctx, &coreV1.Service{
+		ObjectMeta: metaV1.ObjectMeta{Name: "foo", Namespace: "mock"},
+		Spec: coreV1.ServiceSpec{
+			Ports: []coreV1.ServicePort{{Name: "http", Port: 8888}},
+		},
+	}, &coreV1.Service{
+		ObjectMeta: metaV1.ObjectMeta{Name: "multi", Namespace: "mock"},
+		Spec: coreV1.ServiceSpec{
+			Ports: []coreV1.ServicePort{{Name: "http", Port: 8888}, {Name: "admin", Port: 9999}},
+		},
+	})
+
+	cases := []struct {
+		name    string
+		service string
+		port    knetworking.ServiceBackendPort
+		want    *networking.PortSelector
+		dropped bool
+	}{
+		{name: "number", service: "foo", port: knetworking.ServiceBackendPort{Number: 9090}, want: &networking.PortSelector{Number: 9090}},
+		{name: "name", service: "foo", port: knetworking.ServiceBackendPort{Name: "http"}, want: &networking.PortSelector{Number: 8888}},
+		// The single port of the service is used.
+		{name: "missing port name", service: "foo", port: knetworking.ServiceBackendPort{Name: "grpc"}, want: nil},
+		// The path is dropped rather than routed to the gateway port.
+		{name: "missing port name of multi port service", service: "multi", port: knetworking.ServiceBackendPort{Name: "grpc"}, dropped: true},
+		{name: "missing service", service: "bar", port: knetworking.ServiceBackendPort{Name: "http"}, dropped: true},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			route := ingressBackendToHTTPRoute(&knetworking.IngressBackend{
+				Service: &knetworking.IngressServiceBackend{Name: tt.service, Port: tt.port},
+			}, "mock", "mydomain", serviceLister)
+			if tt.dropped {
+				if route != nil {
+					t.Fatalf("expected the backend to be dropped, got %v", route)
+				}
+				return
+			}
+			if route == nil {
+				t.Fatal("expected the backend to be converted")
+			}
+			dest := route.Route[0].Destination
+			if want := tt.service + ".mock.svc.mydomain"; dest.Host != want {
+				t.Errorf("got host %s, want %s", dest.Host, want)
+			}
+			if !reflect.DeepEqual(dest.Port, tt.want) {
+				t.Errorf("got port %v, want %v", dest.Port, tt.want)
+			}
+		})
+	}
+}
+
+// TestGRPCBackend checks that gRPC backends are routed to the gRPC service port. The upstream protocol is not
+// part of the generated VirtualService: clusters use the protocol of the Service port, detected from its name
+// or appProtocol, so such backends are reached over HTTP/2.
+func TestGRPCBackend(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	grpc := "grpc"
+	service := &coreV1.Service{
+		ObjectMeta: metaV1.ObjectMeta{Name: "foo", Namespace: "mock"},
+		Spec: coreV1.ServiceSpec{
+			ClusterIP: "10.0.0.1",
+			Ports: []coreV1.ServicePort{
+				{Name: "http", Port: 8080, Protocol: coreV1.ProtocolTCP},
+				{Name: "grpc", Port: 9090, Protocol: coreV1.ProtocolTCP},
+				{Name: "api", Port: 9091, Protocol: coreV1.ProtocolTCP, AppProtocol: &grpc},
+			},
+		},
+	}
+	serviceLister := createFakeLister(ctx, service)
+	svc := kube.ConvertService(*service, "mydomain", "")
+
+	for _, portName := range []string{"grpc", "api"} {
+		t.Run(portName, func(t *testing.T) {
+			route := ingressBackendToHTTPRoute(&knetworking.IngressBackend{
+				Service: &knetworking.IngressServiceBackend{
+					Name: "foo",
+					Port: knetworking.ServiceBackendPort{Name: portName},
+				},
+			}, "mock", "mydomain", serviceLister)
+			if route == nil {
+				t.Fatal("expected the backend to be converted")
+			}
+			port, f := svc.Ports.GetByPort(int(route.Route[0].Destination.Port.Number))
+			if !f {
+				t.Fatalf("route to unknown port %v", route.Route[0].Destination.Port)
+			}
+			if port.Name != portName || !port.Protocol.IsHTTP2() {
+				t.Fatalf("expected route to HTTP/2 port %s, got %v", portName, port)
+			}
+		})
+	}
+}
+
+func TestInvalidIngress(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serviceLister := createFakeLister(ctx, &coreV1.Service{
+		ObjectMeta: metaV1.ObjectMeta{Name: "foo", Namespace: "mock"},
+	})
+	rule := func(host, service string) knetworking.IngressRule {
+		return knetworking.IngressRule{
+			Host: host,
+			IngressRuleValue: knetworking.IngressRuleValue{
+				HTTP: &knetworking.HTTPIngressRuleValue{
+					Paths: []knetworking.HTTPIngressPath{{
+						Path: "/",
+						Backend: knetworking.IngressBackend{
+							Service: &knetworking.IngressServiceBackend{
+								Name: service,
+								Port: knetworking.ServiceBackendPort{Number: 80},
+							},
+						},
+					}},
+				},
+			},
+		}
+	}
+
+	noBackend := rule("nobackend.com", "")
+	noBackend.HTTP.Paths[0].Backend.Service = nil
+
+	cases := []struct {
+		name   string
+		rule   knetworking.IngressRule
+		reason string
+		routes int
+	}{
+		{name: "valid", rule: rule("valid.com", "foo"), routes: 1},
+		{name: "no paths", rule: knetworking.IngressRule{Host: "nopaths.com"}, reason: invalidNoPaths, routes: -1},
+		{name: "no backend", rule: noBackend, reason: invalidNoBackend, routes: 0},
+		{name: "service not found", rule: rule("missing.com", "missing"), reason: invalidServiceNotFound, routes: 1},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			before := invalidIngressCount(t)
+			cfgs := map[string]*config.Config{}
+			ingress := knetworking.Ingress{
+				ObjectMeta: metaV1.ObjectMeta{Name: "test", Namespace: "mock"},
+				Spec:       knetworking.IngressSpec{Rules: []knetworking.IngressRule{tt.rule}},
+			}
+			// Conversion happens on every List, so it does not record invalid ingresses.
+			ConvertIngressVirtualService(ingress, "mydomain", cfgs, serviceLister)
+			if after := invalidIngressCount(t); !reflect.DeepEqual(after, before) {
+				t.Fatalf("conversion recorded invalid ingresses: got %v, want %v", after, before)
+			}
+
+			validateIngress(&ingress, serviceLister)
+			after := invalidIngressCount(t)
+			for _, reason := range []string{invalidNoPaths, invalidNoBackend, invalidServiceNotFound} {
+				want := before[reason]
+				if reason == tt.reason {
+					want++
+				}
+				if after[reason] != want {
+					t.Errorf("reason %s: got %v invalid ingresses, want %v", reason, after[reason], want)
+				}
+			}
+			cfg := cfgs[tt.rule.Host]
+			if tt.routes < 0 {
+				if cfg != nil {
+					t.Fatalf("expected no virtual service, got %v", cfg)
+				}
+				return
+			}
+			if got := len(cfg.Spec.(*networking.VirtualService).Http); got != tt.routes {
+				t.Fatalf("got %d routes, want %d", got, tt.routes)
+			}
+		})
+	}
+}
+
+// invalidIngressCount returns the number of invalid ingresses recorded by reason.
+func invalidIngressCount(t *testing.T) map[string]float64 {
+	t.Helper()
+	rows, err := view.RetrieveData("pilot_k8s_ingress_invalid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]float64{}
+	for _, row := range rows {
+		for _, tag := range row.Tags {
+			if tag.Key.Name() == "reason" {
+				out[tag.Value] = row.Data.(*view.SumData).Value
+			}
+		}
+	}
+	return out
+}
@@ -91,6 +91,85 @@ var (
 			"for this time, we'll trigger a push.",
 	).Get()
 
+	XDSInitTimeout = env.RegisterDurationVar(
+		"PILOT_XDS_INIT_TIMEOUT",
+		0,
+		"The maximum amount of time to wait for a new XDS connection to be initialized before rejecting it. "+
+			"If set to 0, Pilot will wait indefinitely.",
+	).Get()
+
+	XDSVersionInfoReason = env.RegisterBoolVar(
+		"PILOT_XDS_VERSION_INFO_REASON",
+		false,
+		"If enabled, the reasons of a push are appended to the version info of XDS responses, "+
+			"e.g. 2021-01-01T00:00:00Z/1;config,endpoint. Proxies echo the version info back on ACK, "+
+			"which allows correlating ACKs with the push that triggered them in the logs.",
+	).Get()
+
+	XDSControlPlaneReason = env.RegisterBoolVar(
+		"PILOT_XDS_CONTROL_PLANE_REASON",
+		false,
+		"If enabled, the most frequent reason of a push is added as PushReason to the control plane identifier "+
+			"of XDS responses, which proxies log on config updates. Unlike PILOT_XDS_VERSION_INFO_REASON, it is "+
+			"not echoed back on ACK.",
+	).Get()
+
+	PushStaggerWindow = env.RegisterDurationVar(
+		"PILOT_PUSH_STAGGER_WINDOW",
+		0,
+		"If set, the enqueueing of a push to the connected proxies is spread over this window, in batches "+
+			"separated by a jittered delay, so that large fleets do not all request config at the same time. "+
+			"If set to 0, all proxies are enqueued at once.",
+	).Get()
+
+	PushStaggerBatchSize = env.RegisterIntVar(
+		"PILOT_PUSH_STAGGER_BATCH_SIZE",
+		100,
+		"The number of proxies enqueued together when PILOT_PUSH_STAGGER_WINDOW is set.",
+	).Get()
+
+	MaxWatchedTypes = env.RegisterIntVar(
+		"PILOT_MAX_WATCHED_TYPES",
+		64,
+		"The maximum number of distinct types a single XDS connection may watch. When exceeded, the watch "+
+			"of the least recently requested type is dropped. If set to 0, the number is not limited.",
+	).Get()
+
+	SkipUnchangedXDSPushes = env.RegisterBoolVar(
+		"PILOT_SKIP_UNCHANGED_XDS_PUSHES",
+		false,
+		"If enabled, Pilot hashes the resources sent for each watched type, and skips pushes whose resources are "+
+			"unchanged since the last response. Responses to requests of the proxy are always sent.",
+	).Get()
+
+	MergeTCPDestinationSubnets = env.RegisterBoolVar(
+		"PILOT_MERGE_TCP_DESTINATION_SUBNETS",
+		false,
+		"If enabled, overlapping and adjacent destination subnets matched by the TCP routes of a VirtualService "+
+			"are coalesced, reducing the number of CIDR ranges in the outbound filter chains.",
+	).Get()
+
+	XDSReplayMaxSize = env.RegisterIntVar(
+		"PILOT_XDS_REPLAY_MAX_SIZE",
+		1024*1024,
+		"The maximum size in bytes of a response that is kept per type on each XDS connection, so it can be "+
+			"replayed on demand. Larger responses are not kept. If set to 0, no responses are kept.",
+	).Get()
+
+	LogXDSPushPayloads = env.RegisterBoolVar(
+		"PILOT_LOG_XDS_PUSH_PAYLOADS",
+		false,
+		"If enabled, Pilot logs the resources pushed to proxies, in JSON, when the ads scope is at debug level. "+
+			"Secrets are redacted. This is verbose and only intended for debugging.",
+	).Get()
+
+	MaxConcurrentXDSStreams = env.RegisterIntVar(
+		"PILOT_MAX_CONCURRENT_XDS_STREAMS",
+		0,
+		"The maximum number of XDS streams Pilot serves at the same time. New streams over the limit are "+
+			"rejected with RESOURCE_EXHAUSTED. If set to 0, the number is not limited.",
+	).Get()
+
 	EnableEDSDebounce = env.RegisterBoolVar(
 		"PILOT_ENABLE_EDS_DEBOUNCE",
 		true,
@@ -506,6 +585,12 @@ var (
 	UseTargetPortForGatewayRoutes = env.RegisterBoolVar("PILOT_USE_TARGET_PORT_FOR_GATEWAY_ROUTES", true,
 		"If true, routes will use the target port of the gateway service in the route name, not the service port.").Get()
 
+	// TCPIdleTimeoutFromDestinationRule applies the HTTP connection pool idle timeout of DestinationRules to
+	// TCP proxy filters. The DestinationRule API has no TCP idle timeout yet, and the HTTP one is usually much
+	// shorter than the TCP proxy default, so this is opt-in to keep long-lived TCP connections open.
+	TCPIdleTimeoutFromDestinationRule = env.RegisterBoolVar("PILOT_TCP_IDLE_TIMEOUT_FROM_DESTINATION_RULE", false,
+		"If true, the HTTP connection pool idle timeout of DestinationRules is also applied to TCP proxy connections.").Get()
+
 	CertSignerDomain = env.RegisterStringVar("CERT_SIGNER_DOMAIN", "", "The cert signer domain info").Get()
 )
 
@@ -32,6 +32,7 @@ import (
 	"github.com/golang/protobuf/jsonpb"
 	"github.com/golang/protobuf/ptypes/any"
 	structpb "github.com/golang/protobuf/ptypes/struct"
+	"google.golang.org/grpc/codes"
 
 	meshconfig "istio.io/api/mesh/v1alpha1"
 	"istio.io/istio/pilot/pkg/trustbundle"
@@ -180,6 +181,9 @@ func AnyToUnnamedResources(r []*any.Any) Resources {
 	return a
 }
 
+// ResourcesToAny returns the marshaled resources of r. It does not marshal anything itself: resources are
+// marshaled by the generators, which may share a marshaled resource across connections through the XdsCache.
+// The returned values may therefore be aliased and must not be mutated.
 func ResourcesToAny(r Resources) []*any.Any {
 	a := make([]*any.Any, 0, len(r))
 	for _, rr := range r {
@@ -197,10 +201,19 @@ type XdsUpdates = map[ConfigKey]struct{}
 type XdsLogDetails struct {
 	Incremental    bool
 	AdditionalInfo string
+	// NotModified is set by generators intentionally returning no resources, as nothing changed for
+	// the proxy, so skipped pushes can be told apart from empty responses and errors. AdditionalInfo
+	// then holds the reason, which is used as a metric label and should be a fixed string.
+	NotModified bool
 }
 
 var DefaultXdsLogDetails XdsLogDetails = XdsLogDetails{}
 
+// NotModified returns the details of a push skipped by a generator for the given reason.
+func NotModified(reason string) XdsLogDetails {
+	return XdsLogDetails{NotModified: true, AdditionalInfo: reason}
+}
+
 // XdsResourceGenerator creates the response for a typeURL DiscoveryRequest. If no generator is associated
 // with a Proxy, the default (a networking.core.ConfigGenerator instance) will be used.
 // The server may associate a different generator based on client metadata. Different
@@ -291,6 +304,10 @@ type Proxy struct {
 
 	// XdsNode is the xDS node identifier
 	XdsNode *core.Node
+
+	// StreamMetadata holds the gRPC metadata sent by the client when the XDS stream was established.
+	// Generators may use it to tailor the generated configuration to the client capabilities.
+	StreamMetadata map[string][]string
 }
 
 // WatchedResource tracks an active DiscoveryRequest subscription.
@@ -324,6 +341,12 @@ type WatchedResource struct {
 	// NonceNacked is the last nacked message. This is reset following a successful ACK
 	NonceNacked string
 
+	// LastError is the error message of the last NACK. This is reset following a successful ACK.
+	LastError string
+
+	// LastErrorCode is the error code of the last NACK. This is reset following a successful ACK.
+	LastErrorCode codes.Code
+
 	// LastSent tracks the time of the generated push, to determine the time it takes the client to ack.
 	LastSent time.Time
 
@@ -333,6 +356,10 @@ type WatchedResource struct {
 	// LastSize tracks the size of the last update
 	LastSize int
 
+	// LastSentHash is the hash of the resources of the last sent response. It is only set when
+	// skipping unchanged pushes is enabled.
+	LastSentHash string
+
 	// Last request contains the last DiscoveryRequest received for
 	// this type. Generators are called immediately after each request,
 	// and may use the information in DiscoveryRequest.
@@ -510,6 +537,10 @@ type NodeMetadata struct {
 	// IstioVersion specifies the Istio version associated with the proxy
 	IstioVersion string `json:"ISTIO_VERSION,omitempty"`
 
+	// BootID identifies the proxy process. It is the same across reconnections of a process and changes
+	// when the proxy restarts, which allows Pilot to skip resending unchanged config on reconnection.
+	BootID string `json:"BOOT_ID,omitempty"`
+
 	// Labels specifies the set of workload instance (ex: k8s pod) labels associated with this node.
 	Labels map[string]string `json:"LABELS,omitempty"`
 
@@ -585,6 +616,10 @@ type NodeMetadata struct {
 	// This depends on DNSCapture.
 	DNSAutoAllocate StringBool `json:"DNS_AUTO_ALLOCATE,omitempty"`
 
+	// TCPProxyProtocol indicates that a PROXY protocol v2 header should be sent on the upstream
+	// connections of outbound TCP clusters, to preserve the original client address.
+	TCPProxyProtocol StringBool `json:"TCP_PROXY_PROTOCOL,omitempty"`
+
 	// AutoRegister will enable auto registration of the connected endpoint to the service registry using the given WorkloadGroup name
 	AutoRegisterGroup string `json:"AUTO_REGISTER_GROUP,omitempty"`
 
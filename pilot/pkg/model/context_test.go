@@ -622,3 +622,43 @@ func TestGlobalUnicastIP(t *testing.T) {
 		})
 	}
 }
+
+func TestNewTestProxy(t *testing.T) {
+	p := model.NewTestProxy()
+	if p.Type != model.SidecarProxy || p.ConfigNamespace != "default" || p.Metadata.Namespace != "default" {
+		t.Fatalf("unexpected default proxy %+v", p)
+	}
+	if !p.SupportsIPv4() || p.SupportsIPv6() {
+		t.Fatalf("expected IP versions to be discovered for %v", p.IPAddresses)
+	}
+
+	p = model.NewTestProxy(
+		model.WithProxyType(model.Router),
+		model.WithProxyIPs("::1"),
+		model.WithProxyNamespace("istio-system"),
+		model.WithProxyLabels(map[string]string{"app": "gateway"}),
+		model.WithProxyIstioVersion("1.10.0"),
+		model.WithProxyIdentity("istio-system", "gateway"),
+		model.WithProxyMetadata(func(m *model.NodeMetadata) { m.Generator = "grpc" }),
+		model.WithWatchedResource("type.googleapis.com/envoy.config.cluster.v3.Cluster"),
+	)
+	if p.Type != model.Router || p.ConfigNamespace != "istio-system" || p.Metadata.Namespace != "istio-system" ||
+		p.DNSDomain != "istio-system.svc.cluster.local" {
+		t.Fatalf("unexpected proxy %+v", p)
+	}
+	if !p.SupportsIPv6() || p.SupportsIPv4() {
+		t.Fatalf("expected IP versions to be discovered for %v", p.IPAddresses)
+	}
+	if p.Metadata.Labels["app"] != "gateway" || p.Metadata.Generator != "grpc" {
+		t.Fatalf("unexpected metadata %+v", p.Metadata)
+	}
+	if p.IstioVersion.Compare(&model.IstioVersion{Major: 1, Minor: 10, Patch: 0}) != 0 {
+		t.Fatalf("unexpected Istio version %v", p.IstioVersion)
+	}
+	if p.VerifiedIdentity.ServiceAccount != "gateway" {
+		t.Fatalf("unexpected identity %v", p.VerifiedIdentity)
+	}
+	if w := p.WatchedResources["type.googleapis.com/envoy.config.cluster.v3.Cluster"]; w == nil || len(w.ResourceNames) != 0 {
+		t.Fatalf("unexpected watched resources %v", p.WatchedResources)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"sync"
+	"time"
+)
+
+// maxRecentDuplicatedDomains bounds the number of duplicate domain rejections kept for debugging.
+const maxRecentDuplicatedDomains = 100
+
+// DuplicateDomainInfo describes a virtual host that was rejected, fully or partially, because its
+// domains were already used by another virtual host.
+type DuplicateDomainInfo struct {
+	// Host is the name of the rejected virtual host.
+	Host string `json:"host"`
+	// VirtualService and Namespace identify the virtual service the virtual host was built from.
+	// They are empty for virtual hosts built from a service without virtual service.
+	VirtualService string `json:"virtualService,omitempty"`
+	Namespace      string `json:"namespace,omitempty"`
+	// Proxy is the last proxy for which the rejection happened.
+	Proxy string `json:"proxy"`
+	// Time is when the rejection last happened.
+	Time time.Time `json:"time"`
+}
+
+var recentDuplicatedDomains = struct {
+	sync.Mutex
+	entries []DuplicateDomainInfo
+}{}
+
+// RecordDuplicatedDomain tracks a duplicate domain rejection, complementing the DuplicatedDomains metric.
+// Repeated rejections of the same virtual host only refresh its entry, so the same collision seen by many
+// proxies does not evict the others. At most maxRecentDuplicatedDomains entries are kept.
+func RecordDuplicatedDomain(info DuplicateDomainInfo) {
+	if info.Time.IsZero() {
+		info.Time = time.Now()
+	}
+	recentDuplicatedDomains.Lock()
+	defer recentDuplicatedDomains.Unlock()
+	entries := recentDuplicatedDomains.entries
+	for i, e := range entries {
+		if e.Host == info.Host && e.VirtualService == info.VirtualService && e.Namespace == info.Namespace {
+			entries = append(entries[:i], entries[i+1:]...)
+			break
+		}
+	}
+	entries = append(entries, info)
+	if len(entries) > maxRecentDuplicatedDomains {
+		entries = entries[len(entries)-maxRecentDuplicatedDomains:]
+	}
+	recentDuplicatedDomains.entries = entries
+}
+
+// RecentDuplicatedDomains returns the most recent duplicate domain rejections, oldest first.
+func RecentDuplicatedDomains() []DuplicateDomainInfo {
+	recentDuplicatedDomains.Lock()
+	defer recentDuplicatedDomains.Unlock()
+	return append([]DuplicateDomainInfo(nil), recentDuplicatedDomains.entries...)
+}
@@ -0,0 +1,52 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRecentDuplicatedDomains(t *testing.T) {
+	recentDuplicatedDomains.entries = nil
+	defer func() { recentDuplicatedDomains.entries = nil }()
+
+	RecordDuplicatedDomain(DuplicateDomainInfo{Host: "a.com:80", VirtualService: "vs-a", Namespace: "ns", Proxy: "proxy-1"})
+	RecordDuplicatedDomain(DuplicateDomainInfo{Host: "b.com:80", Proxy: "proxy-1"})
+	// The same collision seen by another proxy refreshes the existing entry.
+	RecordDuplicatedDomain(DuplicateDomainInfo{Host: "a.com:80", VirtualService: "vs-a", Namespace: "ns", Proxy: "proxy-2"})
+
+	got := RecentDuplicatedDomains()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 entries, got %v", got)
+	}
+	if got[0].Host != "b.com:80" || got[1].Host != "a.com:80" || got[1].Proxy != "proxy-2" {
+		t.Fatalf("unexpected entries %v", got)
+	}
+	if got[1].Time.IsZero() {
+		t.Fatalf("expected the time to be set")
+	}
+
+	for i := 0; i < 2*maxRecentDuplicatedDomains; i++ {
+		RecordDuplicatedDomain(DuplicateDomainInfo{Host: fmt.Sprintf("host-%d:80", i)})
+	}
+	got = RecentDuplicatedDomains()
+	if len(got) != maxRecentDuplicatedDomains {
+		t.Fatalf("expected %d entries, got %d", maxRecentDuplicatedDomains, len(got))
+	}
+	if last := got[len(got)-1].Host; last != fmt.Sprintf("host-%d:80", 2*maxRecentDuplicatedDomains-1) {
+		t.Fatalf("expected the most recent entry last, got %s", last)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+
+	"istio.io/istio/pkg/cluster"
+	"istio.io/istio/pkg/spiffe"
+)
+
+// TestProxyOption customizes a proxy built by NewTestProxy.
+type TestProxyOption func(*Proxy)
+
+// NewTestProxy builds a proxy for generator and XDS tests, without parsing a node. By default it is a
+// sidecar with ID "test.default" and IP 1.1.1.1 in the default namespace, with empty metadata and no
+// watched resources. The IP versions are discovered once the options are applied.
+func NewTestProxy(opts ...TestProxyOption) *Proxy {
+	p := &Proxy{
+		Type:             SidecarProxy,
+		ID:               "test.default",
+		IPAddresses:      []string{"1.1.1.1"},
+		DNSDomain:        "default.svc.cluster.local",
+		ConfigNamespace:  "default",
+		Metadata:         &NodeMetadata{Namespace: "default"},
+		WatchedResources: map[string]*WatchedResource{},
+	}
+	for _, o := range opts {
+		o(p)
+	}
+	p.DiscoverIPVersions()
+	return p
+}
+
+// WithProxyType sets the type of the proxy, e.g. Router for a gateway.
+func WithProxyType(t NodeType) TestProxyOption {
+	return func(p *Proxy) {
+		p.Type = t
+	}
+}
+
+// WithProxyID sets the ID of the proxy.
+func WithProxyID(id string) TestProxyOption {
+	return func(p *Proxy) {
+		p.ID = id
+	}
+}
+
+// WithProxyIPs sets the IP addresses of the proxy.
+func WithProxyIPs(ips ...string) TestProxyOption {
+	return func(p *Proxy) {
+		p.IPAddresses = ips
+	}
+}
+
+// WithProxyNamespace sets the config namespace of the proxy, the namespace in its metadata and its DNS domain.
+func WithProxyNamespace(ns string) TestProxyOption {
+	return func(p *Proxy) {
+		p.ConfigNamespace = ns
+		p.Metadata.Namespace = ns
+		p.DNSDomain = ns + ".svc.cluster.local"
+	}
+}
+
+// WithProxyLabels sets the workload labels in the metadata of the proxy.
+func WithProxyLabels(labels map[string]string) TestProxyOption {
+	return func(p *Proxy) {
+		p.Metadata.Labels = labels
+	}
+}
+
+// WithProxyClusterID sets the cluster of the proxy in its metadata.
+func WithProxyClusterID(id cluster.ID) TestProxyOption {
+	return func(p *Proxy) {
+		p.Metadata.ClusterID = id
+	}
+}
+
+// WithProxyIstioVersion sets the Istio version of the proxy, both in its metadata and parsed.
+func WithProxyIstioVersion(version string) TestProxyOption {
+	return func(p *Proxy) {
+		p.Metadata.IstioVersion = version
+		p.IstioVersion = ParseIstioVersion(version)
+	}
+}
+
+// WithProxyLocality sets the locality of the proxy.
+func WithProxyLocality(locality *core.Locality) TestProxyOption {
+	return func(p *Proxy) {
+		p.Locality = locality
+	}
+}
+
+// WithProxyIdentity sets the verified identity of the proxy, as if it authenticated with the given
+// service account.
+func WithProxyIdentity(namespace, serviceAccount string) TestProxyOption {
+	return func(p *Proxy) {
+		p.VerifiedIdentity = &spiffe.Identity{Namespace: namespace, ServiceAccount: serviceAccount}
+	}
+}
+
+// WithProxyMetadata changes the metadata of the proxy, for the keys without a dedicated option.
+func WithProxyMetadata(f func(*NodeMetadata)) TestProxyOption {
+	return func(p *Proxy) {
+		f(p.Metadata)
+	}
+}
+
+// WithWatchedResource adds a watch of the type for the resource names.
+func WithWatchedResource(typeURL string, names ...string) TestProxyOption {
+	return func(p *Proxy) {
+		p.WatchedResources[typeURL] = &WatchedResource{TypeUrl: typeURL, ResourceNames: names}
+	}
+}
@@ -91,7 +91,7 @@ var (
 )
 
 func init() {
-	monitoring.MustRegister(totalRejectedConfigs)
+	registerMetrics(totalRejectedConfigs)
 }
 
 func RecordRejectedConfig(gatewayName string) {
@@ -145,7 +145,7 @@ type JwksResolver struct {
 }
 
 func init() {
-	monitoring.MustRegister(networkFetchSuccessCounter, networkFetchFailCounter)
+	registerMetrics(networkFetchSuccessCounter, networkFetchFailCounter)
 }
 
 // NewJwksResolver creates new instance of JwksResolver.
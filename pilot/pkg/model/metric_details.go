@@ -0,0 +1,129 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"sync"
+	"time"
+
+	"istio.io/pkg/monitoring"
+)
+
+// maxRecentMetricDetails bounds the number of details kept per metric for debugging.
+const maxRecentMetricDetails = 100
+
+// MetricDetailRecorder is optionally implemented by Metrics, to keep the full details of a metric case
+// in addition to the gauge, for example the conflicting listeners of a proxy.
+type MetricDetailRecorder interface {
+	// RecordDetail records a detail for the given metric and node.
+	RecordDetail(metric monitoring.Metric, proxyID, detail string)
+}
+
+// recentMetricDetails keeps the details of the metrics added to push contexts, see PushContext.AddMetric.
+var recentMetricDetails = NewMetricDetails(maxRecentMetricDetails)
+
+// RecentMetricDetails returns the most recent details of the metrics added to push contexts, keyed by
+// metric name, oldest first.
+func RecentMetricDetails() map[string][]MetricDetail {
+	return recentMetricDetails.AllDetails()
+}
+
+// MetricDetail is a single detail recorded for a metric.
+type MetricDetail struct {
+	Proxy  string    `json:"proxy"`
+	Detail string    `json:"detail"`
+	Time   time.Time `json:"time"`
+}
+
+// MetricDetails is a Metrics implementation keeping the last details recorded for each metric,
+// in a fixed size ring buffer per metric.
+type MetricDetails struct {
+	size int
+
+	mutex   sync.Mutex
+	details map[string]*metricDetailRing
+}
+
+type metricDetailRing struct {
+	entries []MetricDetail
+	// next is the index the next detail is written to, once entries is full.
+	next int
+}
+
+var (
+	_ Metrics              = &MetricDetails{}
+	_ MetricDetailRecorder = &MetricDetails{}
+	_ MetricDetailRecorder = &PushContext{}
+)
+
+// NewMetricDetails returns a MetricDetails keeping at most size details per metric.
+func NewMetricDetails(size int) *MetricDetails {
+	if size < 1 {
+		size = 1
+	}
+	return &MetricDetails{
+		size:    size,
+		details: map[string]*metricDetailRing{},
+	}
+}
+
+// AddMetric is a no-op, MetricDetails only keeps the details passed to RecordDetail.
+func (m *MetricDetails) AddMetric(monitoring.Metric, string, string, string) {}
+
+// RecordDetail records the detail, evicting the oldest detail of the metric if the buffer is full.
+func (m *MetricDetails) RecordDetail(metric monitoring.Metric, proxyID, detail string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ring, f := m.details[metric.Name()]
+	if !f {
+		ring = &metricDetailRing{}
+		m.details[metric.Name()] = ring
+	}
+	d := MetricDetail{Proxy: proxyID, Detail: detail, Time: time.Now()}
+	if len(ring.entries) < m.size {
+		ring.entries = append(ring.entries, d)
+		return
+	}
+	ring.entries[ring.next] = d
+	ring.next = (ring.next + 1) % m.size
+}
+
+// Details returns the details recorded for the metric with the given name, oldest first.
+func (m *MetricDetails) Details(metric string) []MetricDetail {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ring, f := m.details[metric]
+	if !f {
+		return nil
+	}
+	return ring.ordered()
+}
+
+// AllDetails returns the details recorded for each metric, keyed by metric name, oldest first.
+func (m *MetricDetails) AllDetails() map[string][]MetricDetail {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	out := make(map[string][]MetricDetail, len(m.details))
+	for metric, ring := range m.details {
+		out[metric] = ring.ordered()
+	}
+	return out
+}
+
+func (r *metricDetailRing) ordered() []MetricDetail {
+	out := make([]MetricDetail, 0, len(r.entries))
+	out = append(out, r.entries[r.next:]...)
+	return append(out, r.entries[:r.next]...)
+}
@@ -0,0 +1,64 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestMetricDetails(t *testing.T) {
+	m := NewMetricDetails(3)
+	for i := 0; i < 5; i++ {
+		m.RecordDetail(ProxyStatusConflictOutboundListenerTCPOverHTTP, "proxy", fmt.Sprintf("detail-%d", i))
+	}
+	m.RecordDetail(ProxyStatusNoService, "other", "no-service")
+
+	details := func(metric string) []string {
+		var out []string
+		for _, d := range m.Details(metric) {
+			out = append(out, d.Detail)
+		}
+		return out
+	}
+	if got, want := details(ProxyStatusConflictOutboundListenerTCPOverHTTP.Name()),
+		[]string{"detail-2", "detail-3", "detail-4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := details(ProxyStatusNoService.Name()), []string{"no-service"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := m.Details(DuplicatedDomains.Name()); got != nil {
+		t.Errorf("expected no details, got %v", got)
+	}
+	if got := m.AllDetails(); len(got) != 2 || len(got[ProxyStatusNoService.Name()]) != 1 {
+		t.Errorf("expected details of 2 metrics, got %v", got)
+	}
+}
+
+func TestPushContextRecordsMetricDetails(t *testing.T) {
+	ps := NewPushContext()
+	ps.AddMetric(ProxyStatusConflictOutboundListenerTCPOverHTTP, "listener", "metric-details-proxy", "conflict")
+	var found bool
+	for _, d := range RecentMetricDetails()[ProxyStatusConflictOutboundListenerTCPOverHTTP.Name()] {
+		if d.Proxy == "metric-details-proxy" && d.Detail == "conflict" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected AddMetric to record the detail")
+	}
+}
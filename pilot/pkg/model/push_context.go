@@ -143,6 +143,11 @@ func newGatewayIndex() gatewayIndex {
 // values are zero, and when push completes the status is reset.
 // The struct is exposed in a debug endpoint - fields public to allow
 // easy serialization as json.
+//
+// Once InitContext has completed, the config indexes of a PushContext are never
+// modified: a config change creates a new PushContext which replaces the global one.
+// A push may therefore keep using the context it started with while the global
+// context is swapped; only the push status metrics are updated, under proxyStatusMutex.
 type PushContext struct {
 	proxyStatusMutex sync.RWMutex
 	// ProxyStatus is keyed by the error code, and holds a map keyed
@@ -249,9 +254,19 @@ type XDSUpdater interface {
 	// Note: the difference with `EDSUpdate` is that it only update the cache rather than requesting a push
 	EDSCacheUpdate(shard, hostname string, namespace string, entry []*IstioEndpoint)
 
+	// FlushEDS requests a single push for all the services of the shard updated with EDSCacheUpdate
+	// since the last flush. This allows a registry to batch many cache updates, e.g. during a resync.
+	// The push is incremental unless one of the updates requires a full push.
+	FlushEDS(shard string)
+
 	// SvcUpdate is called when a service definition is updated/deleted.
 	SvcUpdate(shard, hostname string, namespace string, event Event)
 
+	// SvcUpdateBatch is called when several service definitions of a shard are updated/deleted at once,
+	// e.g. during a resync. It is equivalent to calling SvcUpdate for each update; implementations
+	// without a more efficient approach can use SvcUpdateEach.
+	SvcUpdateBatch(shard string, updates []ServiceChange)
+
 	// ConfigUpdate is called to notify the XDS server of config updates and request a push.
 	// The requests may be collapsed and throttled.
 	ConfigUpdate(req *PushRequest)
@@ -261,6 +276,42 @@ type XDSUpdater interface {
 	ProxyUpdate(clusterID cluster.ID, ip string)
 }
 
+// ServiceChange describes the update of a single service passed to XDSUpdater.SvcUpdateBatch.
+type ServiceChange struct {
+	Hostname  string
+	Namespace string
+	Event     Event
+}
+
+// ConfigKey returns the key identifying the updated service in PushRequest.ConfigsUpdated.
+func (u ServiceChange) ConfigKey() ConfigKey {
+	return ConfigKey{Kind: gvk.ServiceEntry, Name: u.Hostname, Namespace: u.Namespace}
+}
+
+// ServiceChangesConfigKeys returns the keys of all the updated services, so a single PushRequest can be
+// computed for a batch of service updates.
+func ServiceChangesConfigKeys(updates []ServiceChange) map[ConfigKey]struct{} {
+	out := make(map[ConfigKey]struct{}, len(updates))
+	for _, u := range updates {
+		out[u.ConfigKey()] = struct{}{}
+	}
+	return out
+}
+
+// SvcUpdateEach implements XDSUpdater.SvcUpdateBatch by calling SvcUpdate for each update.
+func SvcUpdateEach(updater XDSUpdater, shard string, updates []ServiceChange) {
+	for _, u := range updates {
+		updater.SvcUpdate(shard, u.Hostname, u.Namespace, u.Event)
+	}
+}
+
+// NoopEDSFlusher can be embedded by XDSUpdater implementations that do not batch endpoint
+// cache updates, to implement FlushEDS as a no-op.
+type NoopEDSFlusher struct{}
+
+// FlushEDS implements XDSUpdater.
+func (NoopEDSFlusher) FlushEDS(string) {}
+
 // PushRequest defines a request to push to proxies
 // It is used to send updates to the config update debouncer and pass to the PushQueue.
 type PushRequest struct {
@@ -293,6 +344,26 @@ type PushRequest struct {
 	// There should only be multiple reasons if the push request is the result of two distinct triggers, rather than
 	// classifying a single trigger as having multiple reasons.
 	Reason []TriggerReason
+
+	// Targets are the proxies to push to, keyed by ProxyTargetKey. If this is empty, all proxies get the push.
+	Targets sets.Set
+
+	// Delta holds the resources a client subscribed to or unsubscribed from, for a push responding
+	// to a change of the requested resource names. If this is empty, all watched resources are pushed.
+	Delta ResourceDelta
+}
+
+// ResourceDelta records the changes of the resource names requested by a client.
+type ResourceDelta struct {
+	// Subscribed are the resource names added to the request.
+	Subscribed sets.Set
+	// Unsubscribed are the resource names removed from the request.
+	Unsubscribed sets.Set
+}
+
+// IsEmpty returns true if no resource name was added or removed.
+func (rd ResourceDelta) IsEmpty() bool {
+	return len(rd.Subscribed) == 0 && len(rd.Unsubscribed) == 0
 }
 
 type TriggerReason string
@@ -320,6 +391,35 @@ const (
 	ProxyRequest TriggerReason = "proxyrequest"
 )
 
+// knownTriggerReasons are the TriggerReason values above. Push triggers are recorded per reason, so accepting
+// only these bounds the metric cardinality.
+var knownTriggerReasons = map[TriggerReason]struct{}{
+	EndpointUpdate:  {},
+	ConfigUpdate:    {},
+	ServiceUpdate:   {},
+	ProxyUpdate:     {},
+	GlobalUpdate:    {},
+	UnknownTrigger:  {},
+	DebugTrigger:    {},
+	SecretTrigger:   {},
+	NetworksTrigger: {},
+	ProxyRequest:    {},
+}
+
+// knownReason returns the reason if it is known, or UnknownTrigger otherwise.
+func knownReason(r TriggerReason) TriggerReason {
+	if _, f := knownTriggerReasons[r]; f {
+		return r
+	}
+	return UnknownTrigger
+}
+
+// ProxyTargetKey returns the key of a proxy in PushRequest.Targets. Proxy IDs, e.g. pod.namespace, are only
+// unique within a cluster, so the key includes the cluster of the proxy.
+func ProxyTargetKey(clusterID cluster.ID, proxyID string) string {
+	return string(clusterID) + "/" + proxyID
+}
+
 // Merge two update requests together
 func (pr *PushRequest) Merge(other *PushRequest) *PushRequest {
 	if pr == nil {
@@ -330,8 +430,12 @@ func (pr *PushRequest) Merge(other *PushRequest) *PushRequest {
 	}
 
 	reason := make([]TriggerReason, 0, len(pr.Reason)+len(other.Reason))
-	reason = append(reason, pr.Reason...)
-	reason = append(reason, other.Reason...)
+	for _, r := range pr.Reason {
+		reason = append(reason, knownReason(r))
+	}
+	for _, r := range other.Reason {
+		reason = append(reason, knownReason(r))
+	}
 	merged := &PushRequest{
 		// Keep the first (older) start time
 		Start: pr.Start,
@@ -344,6 +448,8 @@ func (pr *PushRequest) Merge(other *PushRequest) *PushRequest {
 
 		// Merge the two reasons. Note that we shouldn't deduplicate here, or we would under count
 		Reason: reason,
+
+		// Delta is not merged: a merged request pushes all watched resources.
 	}
 
 	// Do not merge when any one is empty
@@ -357,6 +463,11 @@ func (pr *PushRequest) Merge(other *PushRequest) *PushRequest {
 		}
 	}
 
+	// Do not merge when any one is empty, as it targets all proxies
+	if len(pr.Targets) > 0 && len(other.Targets) > 0 {
+		merged.Targets = sets.NewSet(pr.Targets.UnsortedList()...).Insert(other.Targets.UnsortedList()...)
+	}
+
 	return merged
 }
 
@@ -390,6 +501,12 @@ func (ps *PushContext) AddMetric(metric monitoring.Metric, key string, proxyID,
 	}
 	ev := ProxyPushStatus{Message: msg, Proxy: proxyID}
 	metricMap[key] = ev
+	ps.RecordDetail(metric, proxyID, msg)
+}
+
+// RecordDetail keeps the detail in the recent metric details, which outlive the push context, for debugging.
+func (ps *PushContext) RecordDetail(metric monitoring.Metric, proxyID, detail string) {
+	recentMetricDetails.RecordDetail(metric, proxyID, detail)
 }
 
 var (
@@ -454,6 +571,13 @@ var (
 		"Duplicate envoy clusters caused by service entries with same hostname",
 	)
 
+	// ServiceNoPorts tracks services without ports, for which outbound TCP/TLS filter chains fall back
+	// to a cluster built from the listener port. Such traffic will most likely blackhole.
+	ServiceNoPorts = monitoring.NewGauge(
+		"pilot_service_no_ports",
+		"Services without ports, routed to a cluster built from the listener port.",
+	)
+
 	// DNSNoEndpointClusters tracks dns clusters without endpoints
 	DNSNoEndpointClusters = monitoring.NewGauge(
 		"pilot_dns_cluster_without_endpoints",
@@ -473,6 +597,13 @@ var (
 		"Virtual services with dup domains.",
 	)
 
+	// InvalidTCPRoutes tracks TCP and TLS routes of virtual services whose destinations were dropped because they
+	// reference undefined subsets, or whose weights were normalized.
+	InvalidTCPRoutes = monitoring.NewGauge(
+		"pilot_vservice_invalid_tcp_routes",
+		"Virtual services with invalid TCP or TLS route destinations.",
+	)
+
 	// DuplicatedSubsets tracks duplicate subsets that we rejected while merging multiple destination rules for same host
 	DuplicatedSubsets = monitoring.NewGauge(
 		"pilot_destrule_subsets",
@@ -485,6 +616,24 @@ var (
 		"Total virtual services known to pilot.",
 	)
 
+	// totalGateways tracks the total number of gateways
+	totalGateways = monitoring.NewGauge(
+		"pilot_gateways",
+		"Total gateways known to pilot.",
+	)
+
+	// totalDestinationRules tracks the total number of destination rules
+	totalDestinationRules = monitoring.NewGauge(
+		"pilot_destrules",
+		"Total destination rules known to pilot.",
+	)
+
+	// totalServiceEntries tracks the total number of service entries
+	totalServiceEntries = monitoring.NewGauge(
+		"pilot_serviceentries",
+		"Total service entries known to pilot.",
+	)
+
 	// LastPushStatus preserves the metrics and data collected during lasts global push.
 	// It can be used by debugging tools to inspect the push event. It will be reset after each push with the
 	// new version.
@@ -492,7 +641,7 @@ var (
 	// LastPushMutex will protect the LastPushStatus
 	LastPushMutex sync.Mutex
 
-	// All metrics we registered.
+	// metrics are the push status metrics, recorded from PushContext.ProxyStatus by UpdateMetrics.
 	metrics = []monitoring.Metric{
 		EndpointNoPod,
 		ProxyStatusNoService,
@@ -502,17 +651,33 @@ var (
 		ProxyStatusConflictOutboundListenerHTTPOverTCP,
 		ProxyStatusConflictInboundListener,
 		DuplicatedClusters,
+		ServiceNoPorts,
+		DNSNoEndpointClusters,
 		ProxyStatusClusterNoInstances,
 		DuplicatedDomains,
+		InvalidTCPRoutes,
 		DuplicatedSubsets,
 	}
+
+	// registeredMetrics holds the names of the metrics registered with registerMetrics.
+	registeredMetrics = map[string]struct{}{}
 )
 
 func init() {
-	for _, m := range metrics {
+	registerMetrics(metrics...)
+	registerMetrics(totalVirtualServices, totalGateways, totalDestinationRules, totalServiceEntries)
+}
+
+// registerMetrics registers the given metrics of this package. It must only be called from init functions.
+// Registering a metric twice is a programming error, so it panics.
+func registerMetrics(ms ...monitoring.Metric) {
+	for _, m := range ms {
+		if _, f := registeredMetrics[m.Name()]; f {
+			panic(fmt.Sprintf("metric %s is registered more than once", m.Name()))
+		}
+		registeredMetrics[m.Name()] = struct{}{}
 		monitoring.MustRegister(m)
 	}
-	monitoring.MustRegister(totalVirtualServices)
 }
 
 // NewPushContext creates a new PushContext structure to track push status.
@@ -1148,6 +1313,12 @@ func (ps *PushContext) initServiceRegistry(env *Environment) error {
 	if err != nil {
 		return err
 	}
+	// Services are aggregated from all registries, so count the service entries separately.
+	if env.IstioConfigStore != nil {
+		if serviceEntries, err := env.List(gvk.ServiceEntry, NamespaceAll); err == nil {
+			totalServiceEntries.Record(float64(len(serviceEntries)))
+		}
+	}
 	// Sort the services in order of creation.
 	allServices := sortServicesByCreationTime(services)
 	for _, s := range allServices {
@@ -1470,6 +1641,8 @@ func (ps *PushContext) initDestinationRules(env *Environment) error {
 		destRules[i] = configs[i].DeepCopy()
 	}
 
+	totalDestinationRules.Record(float64(len(configs)))
+
 	ps.SetDestinationRules(destRules)
 	return nil
 }
@@ -1706,6 +1879,7 @@ func (ps *PushContext) initGateways(env *Environment) error {
 	}
 
 	sortConfigByCreationTime(gatewayConfigs)
+	totalGateways.Record(float64(len(gatewayConfigs)))
 
 	ps.gatewayIndex.all = gatewayConfigs
 	ps.gatewayIndex.namespace = make(map[string][]config.Config)
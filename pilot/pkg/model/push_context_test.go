@@ -16,9 +16,15 @@ package model
 
 import (
 	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
 	"reflect"
 	"regexp"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -27,6 +33,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	. "github.com/onsi/gomega"
+	"go.opencensus.io/stats/view"
 	"go.uber.org/atomic"
 
 	meshconfig "istio.io/api/mesh/v1alpha1"
@@ -34,6 +41,7 @@ import (
 	securityBeta "istio.io/api/security/v1beta1"
 	selectorpb "istio.io/api/type/v1beta1"
 	"istio.io/istio/pilot/pkg/features"
+	"istio.io/istio/pilot/pkg/util/sets"
 	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/constants"
 	"istio.io/istio/pkg/config/host"
@@ -109,6 +117,24 @@ func TestMergeUpdateRequest(t *testing.T) {
 			}: {}}},
 			PushRequest{Full: true, ConfigsUpdated: nil, Reason: []TriggerReason{}},
 		},
+		{
+			"unknown reasons",
+			&PushRequest{Reason: []TriggerReason{ConfigUpdate, "custom"}},
+			&PushRequest{Reason: []TriggerReason{"other", EndpointUpdate}},
+			PushRequest{Reason: []TriggerReason{ConfigUpdate, UnknownTrigger, UnknownTrigger, EndpointUpdate}},
+		},
+		{
+			"merge targets",
+			&PushRequest{Full: true, Targets: sets.NewSet("a", "b")},
+			&PushRequest{Full: true, Targets: sets.NewSet("b", "c")},
+			PushRequest{Full: true, Targets: sets.NewSet("a", "b", "c"), Reason: []TriggerReason{}},
+		},
+		{
+			"skip targets merge: one empty",
+			&PushRequest{Full: true, Targets: nil},
+			&PushRequest{Full: true, Targets: sets.NewSet("a")},
+			PushRequest{Full: true, Targets: nil, Reason: []TriggerReason{}},
+		},
 	}
 
 	for _, tt := range cases {
@@ -737,6 +763,98 @@ func TestInitPushContext(t *testing.T) {
 	}
 }
 
+func TestConfigCountMetrics(t *testing.T) {
+	configStore := NewFakeStore()
+	for i, kind := range []config.GroupVersionKind{
+		gvk.VirtualService, gvk.Gateway, gvk.Gateway, gvk.DestinationRule,
+		gvk.ServiceEntry, gvk.ServiceEntry, gvk.ServiceEntry,
+	} {
+		cfg := config.Config{Meta: config.Meta{Name: fmt.Sprintf("config-%d", i), Namespace: "test1", GroupVersionKind: kind}}
+		switch kind {
+		case gvk.VirtualService:
+			cfg.Spec = &networking.VirtualService{}
+		case gvk.Gateway:
+			cfg.Spec = &networking.Gateway{}
+		case gvk.DestinationRule:
+			cfg.Spec = &networking.DestinationRule{}
+		case gvk.ServiceEntry:
+			cfg.Spec = &networking.ServiceEntry{}
+		}
+		if _, err := configStore.Create(cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := mesh.DefaultMeshConfig()
+	env := &Environment{
+		IstioConfigStore: &istioConfigStore{ConfigStore: configStore},
+		ServiceDiscovery: &localServiceDiscovery{},
+		Watcher:          mesh.NewFixedWatcher(&m),
+	}
+	env.Init()
+	if err := NewPushContext().InitContext(env, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for metric, expected := range map[string]float64{
+		"pilot_virt_services":  1,
+		"pilot_gateways":       2,
+		"pilot_destrules":      1,
+		"pilot_serviceentries": 3,
+	} {
+		data, err := view.RetrieveData(metric)
+		if err != nil {
+			t.Fatalf("failed to get value for %s: %v", metric, err)
+		}
+		if len(data) != 1 {
+			t.Fatalf("expected a single row for %s, got %v", metric, data)
+		}
+		if got := data[0].Data.(*view.LastValueData).Value; got != expected {
+			t.Errorf("expected %s to be %v, got %v", metric, expected, got)
+		}
+	}
+}
+
+func TestMetricsRegistered(t *testing.T) {
+	// Find all metrics created by the package, and make sure each of them is registered.
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := 0
+	for _, pkg := range pkgs {
+		ast.Inspect(pkg, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			if pkgName, ok := sel.X.(*ast.Ident); !ok || pkgName.Name != "monitoring" || !strings.HasPrefix(sel.Sel.Name, "New") {
+				return true
+			}
+			lit, ok := call.Args[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				t.Errorf("%v: metric name is not a literal", fset.Position(call.Pos()))
+				return true
+			}
+			name, _ := strconv.Unquote(lit.Value)
+			created++
+			if _, f := registeredMetrics[name]; !f {
+				t.Errorf("%v: metric %s is not registered", fset.Position(call.Pos()), name)
+			}
+			return true
+		})
+	}
+	if created != len(registeredMetrics) {
+		t.Errorf("created %d metrics, but registered %d", created, len(registeredMetrics))
+	}
+}
+
 func TestSidecarScope(t *testing.T) {
 	ps := NewPushContext()
 	env := &Environment{Watcher: mesh.NewFixedWatcher(&meshconfig.MeshConfig{RootNamespace: "istio-system"})}
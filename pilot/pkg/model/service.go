@@ -24,6 +24,7 @@ package model
 
 import (
 	"fmt"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
@@ -728,6 +729,23 @@ func (s *Service) DeepCopy() *Service {
 	}
 }
 
+// Equals compares two services, ignoring the embedded mutex.
+func (s *Service) Equals(other *Service) bool {
+	if s == nil || other == nil {
+		return s == other
+	}
+	return s.Hostname == other.Hostname &&
+		s.Address == other.Address &&
+		s.AutoAllocatedAddress == other.AutoAllocatedAddress &&
+		s.Resolution == other.Resolution &&
+		s.MeshExternal == other.MeshExternal &&
+		s.CreationTime.Equal(other.CreationTime) &&
+		reflect.DeepEqual(s.Ports, other.Ports) &&
+		reflect.DeepEqual(s.ServiceAccounts, other.ServiceAccounts) &&
+		reflect.DeepEqual(s.ClusterVIPs, other.ClusterVIPs) &&
+		reflect.DeepEqual(s.Attributes, other.Attributes)
+}
+
 // DeepCopy creates a clone of IstioEndpoint.
 func (ep *IstioEndpoint) DeepCopy() *IstioEndpoint {
 	return copyInternal(ep).(*IstioEndpoint)
@@ -19,6 +19,7 @@ import (
 
 	"istio.io/istio/pkg/config/host"
 	"istio.io/istio/pkg/config/labels"
+	"istio.io/istio/pkg/config/protocol"
 )
 
 func TestGetByPort(t *testing.T) {
@@ -272,6 +273,45 @@ func TestWorkloadInstanceEqual(t *testing.T) {
 	}
 }
 
+func TestServiceEquals(t *testing.T) {
+	svc := &Service{
+		Hostname: "foo.default.svc.cluster.local",
+		Address:  "10.0.0.1",
+		Ports:    PortList{{Name: "http", Port: 80, Protocol: protocol.HTTP}},
+		Attributes: ServiceAttributes{
+			Name:      "foo",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "foo"},
+		},
+	}
+	differingPort := svc.DeepCopy()
+	differingPort.Ports[0].Port = 81
+	differingLabels := svc.DeepCopy()
+	differingLabels.Attributes.Labels = map[string]string{"app": "bar"}
+
+	cases := []struct {
+		name  string
+		a, b  *Service
+		equal bool
+	}{
+		{"both nil", nil, nil, true},
+		{"one nil", svc, nil, false},
+		{"copy", svc, svc.DeepCopy(), true},
+		{"different ports", svc, differingPort, false},
+		{"different labels", svc, differingLabels, false},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.Equals(tt.b); got != tt.equal {
+				t.Errorf("Equals() = %v, want %v", got, tt.equal)
+			}
+			if got := tt.b.Equals(tt.a); got != tt.equal {
+				t.Errorf("reversed Equals() = %v, want %v", got, tt.equal)
+			}
+		})
+	}
+}
+
 func BenchmarkBuildSubsetKey(b *testing.B) {
 	for n := 0; n < b.N; n++ {
 		_ = BuildSubsetKey(TrafficDirectionInbound, "v1", "someHost", 80)
@@ -31,9 +31,7 @@ import (
 )
 
 func init() {
-	monitoring.MustRegister(xdsCacheReads)
-	monitoring.MustRegister(xdsCacheEvictions)
-	monitoring.MustRegister(xdsCacheSize)
+	registerMetrics(xdsCacheReads, xdsCacheEvictions, xdsCacheSize)
 }
 
 var (
@@ -146,6 +146,7 @@ func buildClusterKey(service *model.Service, port *model.Port, cb *ClusterBuilde
 		proxySidecar:    cb.proxy.Type == model.SidecarProxy,
 		http2:           port.Protocol.IsHTTP2(),
 		downstreamAuto:  cb.proxy.Type == model.SidecarProxy && util.IsProtocolSniffingEnabledForOutboundPort(port),
+		proxyProtocol:   upstreamProxyProtocolEnabled(cb.proxy, port),
 	}
 	return clusterKey
 }
@@ -191,6 +192,12 @@ func (configgen *ConfigGeneratorImpl) buildOutboundClusters(cb *ClusterBuilder,
 			}
 
 			subsetClusters := cb.applyDestinationRule(defaultCluster, DefaultClusterMode, service, port, clusterKey.networkView, clusterKey.destinationRule)
+			if clusterKey.proxyProtocol {
+				applyUpstreamProxyProtocol(defaultCluster.cluster)
+				for _, ss := range subsetClusters {
+					applyUpstreamProxyProtocol(ss)
+				}
+			}
 
 			if patched := cp.applyResource(nil, defaultCluster.build()); patched != nil {
 				resources = append(resources, patched)
@@ -214,6 +221,13 @@ func (configgen *ConfigGeneratorImpl) buildOutboundClusters(cb *ClusterBuilder,
 	return resources, cacheStats{hits: hit, miss: miss}
 }
 
+// upstreamProxyProtocolEnabled returns true if the sidecar opted in to send a PROXY protocol header on the
+// upstream connections of the TCP clusters, whose filter chains are built by
+// buildSidecarOutboundTCPFilterChainOpts.
+func upstreamProxyProtocolEnabled(proxy *model.Proxy, port *model.Port) bool {
+	return proxy.Type == model.SidecarProxy && bool(proxy.Metadata.TCPProxyProtocol) && port.Protocol.IsTCP()
+}
+
 type clusterPatcher struct {
 	efw  *model.EnvoyFilterWrapper
 	pctx networking.EnvoyFilter_PatchContext
@@ -24,6 +24,7 @@ import (
 	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	proxyprotocol "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/proxy_protocol/v3"
 	auth "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
 	http "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
 	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
@@ -329,6 +330,8 @@ type clusterCache struct {
 	// http2 identifies if thi cluster is for an http2 service
 	http2          bool
 	downstreamAuto bool
+	// proxyProtocol identifies if a PROXY protocol header is sent upstream
+	proxyProtocol bool
 	// Push version is a very broad key. Any config key will invalidate it. Its still valuable to cache,
 	// as that means we can generate a cluster once and send it to all proxies, rather than N times for N proxies.
 	// Hypothetically we could get smarter and determine the exact set of all configs we use and their versions,
@@ -342,6 +345,7 @@ func (t *clusterCache) Key() string {
 	params := []string{
 		t.clusterName, t.pushVersion,
 		strconv.FormatBool(t.proxySidecar), strconv.FormatBool(t.http2), strconv.FormatBool(t.downstreamAuto),
+		strconv.FormatBool(t.proxyProtocol),
 		util.LocalityToString(t.locality), t.proxyClusterID,
 	}
 	if t.service != nil {
@@ -802,6 +806,39 @@ func (cb *ClusterBuilder) applyUpstreamTLSSettings(opts *buildClusterOpts, tls *
 	}
 }
 
+// applyUpstreamProxyProtocol wraps the transport sockets of the cluster, so a PROXY protocol v2 header
+// carrying the downstream addresses is sent on upstream connections, e.g. to preserve the client IP
+// through a TLS passthrough chain.
+func applyUpstreamProxyProtocol(c *cluster.Cluster) {
+	if len(c.TransportSocketMatches) == 0 {
+		c.TransportSocket = upstreamProxyProtocolSocket(c.TransportSocket)
+		return
+	}
+	// The matches may be shared, e.g. defaultTransportSocketMatch, so they are copied.
+	matches := make([]*cluster.Cluster_TransportSocketMatch, 0, len(c.TransportSocketMatches))
+	for _, m := range c.TransportSocketMatches {
+		matches = append(matches, &cluster.Cluster_TransportSocketMatch{
+			Name:            m.Name,
+			Match:           m.Match,
+			TransportSocket: upstreamProxyProtocolSocket(m.TransportSocket),
+		})
+	}
+	c.TransportSocketMatches = matches
+}
+
+func upstreamProxyProtocolSocket(inner *core.TransportSocket) *core.TransportSocket {
+	if inner == nil {
+		inner = &core.TransportSocket{Name: util.EnvoyRawBufferSocketName}
+	}
+	return &core.TransportSocket{
+		Name: util.EnvoyUpstreamProxyProtocolSocketName,
+		ConfigType: &core.TransportSocket_TypedConfig{TypedConfig: util.MessageToAny(&proxyprotocol.ProxyProtocolUpstreamTransport{
+			Config:          &core.ProxyProtocolConfig{Version: core.ProxyProtocolConfig_V2},
+			TransportSocket: inner,
+		})},
+	}
+}
+
 func (cb *ClusterBuilder) buildUpstreamClusterTLSContext(opts *buildClusterOpts, tls *networking.ClientTLSSettings) (*auth.UpstreamTlsContext, error) {
 	c := opts.mutable
 	proxy := opts.proxy
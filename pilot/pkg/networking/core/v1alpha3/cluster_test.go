@@ -25,6 +25,7 @@ import (
 
 	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	proxyprotocol "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/proxy_protocol/v3"
 	http "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
 	"github.com/gogo/protobuf/proto"
 	"github.com/gogo/protobuf/types"
@@ -1735,6 +1736,67 @@ func TestRedisProtocolWithPassThroughResolutionAtGateway(t *testing.T) {
 	}
 }
 
+func TestUpstreamProxyProtocol(t *testing.T) {
+	service := &model.Service{
+		Hostname:    host.Name("test.com"),
+		Address:     "1.1.1.1",
+		ClusterVIPs: make(map[cluster2.ID]string),
+		Ports: model.PortList{
+			&model.Port{Name: "tcp", Port: 9000, Protocol: protocol.TCP},
+			&model.Port{Name: "http", Port: 8080, Protocol: protocol.HTTP},
+		},
+		Resolution: model.ClientSideLB,
+	}
+	// transportSockets returns the transport sockets of the cluster, either set directly or through matches.
+	transportSockets := func(c *cluster.Cluster) []*core.TransportSocket {
+		if len(c.TransportSocketMatches) == 0 {
+			return []*core.TransportSocket{c.TransportSocket}
+		}
+		var out []*core.TransportSocket
+		for _, m := range c.TransportSocketMatches {
+			out = append(out, m.TransportSocket)
+		}
+		return out
+	}
+
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprint(enabled), func(t *testing.T) {
+			cg := NewConfigGenTest(t, TestOptions{Services: []*model.Service{service}})
+			proxy := cg.SetupProxy(&model.Proxy{Metadata: &model.NodeMetadata{TCPProxyProtocol: model.StringBool(enabled)}})
+			clusters := cg.Clusters(proxy)
+			xdstest.ValidateClusters(t, clusters)
+
+			for _, ts := range transportSockets(xdstest.ExtractCluster("outbound|9000||test.com", clusters)) {
+				if !enabled {
+					if ts.GetName() == util.EnvoyUpstreamProxyProtocolSocketName {
+						t.Fatalf("unexpected upstream proxy protocol transport socket")
+					}
+					continue
+				}
+				if ts.GetName() != util.EnvoyUpstreamProxyProtocolSocketName {
+					t.Fatalf("expected upstream proxy protocol transport socket, got %v", ts.GetName())
+				}
+				pp := &proxyprotocol.ProxyProtocolUpstreamTransport{}
+				if err := ts.GetTypedConfig().UnmarshalTo(pp); err != nil {
+					t.Fatal(err)
+				}
+				if pp.Config.GetVersion() != core.ProxyProtocolConfig_V2 {
+					t.Fatalf("expected PROXY protocol v2, got %v", pp.Config.GetVersion())
+				}
+				if pp.TransportSocket == nil {
+					t.Fatalf("expected a wrapped transport socket")
+				}
+			}
+			// HTTP clusters are not affected.
+			for _, ts := range transportSockets(xdstest.ExtractCluster("outbound|8080||test.com", clusters)) {
+				if ts.GetName() == util.EnvoyUpstreamProxyProtocolSocketName {
+					t.Fatalf("unexpected upstream proxy protocol transport socket on HTTP cluster")
+				}
+			}
+		})
+	}
+}
+
 func TestAutoMTLSClusterSubsets(t *testing.T) {
 	g := NewWithT(t)
 
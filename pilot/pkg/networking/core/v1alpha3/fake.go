@@ -307,7 +307,9 @@ func getConfigs(t test.Failer, opts TestOptions) []config.Config {
 	return cfgs
 }
 
-type FakeXdsUpdater struct{}
+type FakeXdsUpdater struct {
+	model.NoopEDSFlusher
+}
 
 func (f *FakeXdsUpdater) ConfigUpdate(*model.PushRequest) {}
 
@@ -317,4 +319,6 @@ func (f *FakeXdsUpdater) EDSCacheUpdate(_, _, _ string, _ []*model.IstioEndpoint
 
 func (f *FakeXdsUpdater) SvcUpdate(_, _, _ string, _ model.Event) {}
 
+func (f *FakeXdsUpdater) SvcUpdateBatch(_ string, _ []model.ServiceChange) {}
+
 func (f *FakeXdsUpdater) ProxyUpdate(_ cluster2.ID, _ string) {}
@@ -741,9 +741,18 @@ func buildGatewayNetworkFiltersFromTCPRoutes(node *model.Proxy, push *model.Push
 		// ensure we satisfy the rule's l4 match conditions, if any exist
 		// For the moment, there can be only one match that succeeds
 		// based on the match port/server port and the gateway name
+		configMeta := v.Meta
+		if server.Tls != nil {
+			if _, f := configMeta.Annotations[TCPTunnelAnnotation]; f {
+				// TLS is terminated at the gateway, tunneling is only supported for passthrough traffic.
+				log.Warnf("ignoring %s annotation on %s/%s for TLS terminating gateway server %s",
+					TCPTunnelAnnotation, configMeta.Namespace, configMeta.Name, gateway)
+				configMeta = withoutTCPTunnel(configMeta)
+			}
+		}
 		for _, tcp := range vsvc.Tcp {
 			if l4MultiMatch(tcp.Match, server, gateway) {
-				return buildOutboundNetworkFilters(node, tcp.Route, push, port, v.Meta)
+				return buildOutboundNetworkFilters(node, tcp.Route, push, port, configMeta)
 			}
 		}
 	}
@@ -858,6 +867,8 @@ func builtAutoPassthroughFilterChains(push *model.PushContext, proxy *model.Prox
 			if len(push.Mesh.OutboundClusterStatName) != 0 {
 				statPrefix = util.BuildStatPrefix(push.Mesh.OutboundClusterStatName, string(service.Hostname), "", port, service.Attributes)
 			}
+			destRule := push.DestinationRule(proxy, service)
+			destinationRule := CastDestinationRule(destRule)
 			// First, we build the standard cluster. We match on the SNI matching the cluster name
 			// (per the spec of AUTO_PASSTHROUGH), as well as all possible Istio mTLS ALPNs. This,
 			// along with filtering out plaintext destinations in EDS, ensures that our requests will
@@ -868,11 +879,9 @@ func builtAutoPassthroughFilterChains(push *model.PushContext, proxy *model.Prox
 				sniHosts:       []string{clusterName},
 				match:          &listener.FilterChainMatch{ApplicationProtocols: allIstioMtlsALPNs},
 				tlsContext:     nil, // NO TLS context because this is passthrough
-				networkFilters: buildOutboundNetworkFiltersWithSingleDestination(push, proxy, statPrefix, clusterName, port),
+				networkFilters: buildOutboundNetworkFiltersWithSingleDestination(push, proxy, statPrefix, clusterName, "", port, destinationRule, nil),
 			})
 
-			destRule := push.DestinationRule(proxy, service)
-			destinationRule := CastDestinationRule(destRule)
 			// Do the same, but for each subset
 			for _, subset := range destinationRule.GetSubsets() {
 				subsetClusterName := model.BuildDNSSrvSubsetKey(model.TrafficDirectionOutbound, subset.Name, service.Hostname, port.Port)
@@ -882,10 +891,11 @@ func builtAutoPassthroughFilterChains(push *model.PushContext, proxy *model.Prox
 					subsetStatPrefix = util.BuildStatPrefix(push.Mesh.OutboundClusterStatName, string(service.Hostname), subset.Name, port, service.Attributes)
 				}
 				filterChains = append(filterChains, &filterChainOpts{
-					sniHosts:       []string{subsetClusterName},
-					match:          &listener.FilterChainMatch{ApplicationProtocols: allIstioMtlsALPNs},
-					tlsContext:     nil, // NO TLS context because this is passthrough
-					networkFilters: buildOutboundNetworkFiltersWithSingleDestination(push, proxy, subsetStatPrefix, subsetClusterName, port),
+					sniHosts:   []string{subsetClusterName},
+					match:      &listener.FilterChainMatch{ApplicationProtocols: allIstioMtlsALPNs},
+					tlsContext: nil, // NO TLS context because this is passthrough
+					networkFilters: buildOutboundNetworkFiltersWithSingleDestination(push, proxy, subsetStatPrefix, subsetClusterName,
+						subset.Name, port, destinationRule, nil),
 				})
 			}
 		}
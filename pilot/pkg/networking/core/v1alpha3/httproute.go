@@ -276,6 +276,7 @@ func BuildSidecarOutboundVirtualHosts(node *model.Proxy, push *model.PushContext
 			if duplicate {
 				// This means this virtual host has caused duplicate virtual host name/domain.
 				push.AddMetric(model.DuplicatedDomains, name, node.ID, fmt.Sprintf("duplicate domain from virtual service: %s", name))
+				recordDuplicatedDomain(node, name, virtualHostWrapper.VirtualService)
 			}
 		}
 
@@ -302,6 +303,7 @@ func BuildSidecarOutboundVirtualHosts(node *model.Proxy, push *model.PushContext
 			if duplicate {
 				// This means we have hit a duplicate virtual host name/ domain name.
 				push.AddMetric(model.DuplicatedDomains, name, node.ID, fmt.Sprintf("duplicate domain from service: %s", name))
+				recordDuplicatedDomain(node, name, virtualHostWrapper.VirtualService)
 			}
 		}
 
@@ -318,6 +320,17 @@ func BuildSidecarOutboundVirtualHosts(node *model.Proxy, push *model.PushContext
 	return tmpVirtualHosts
 }
 
+// recordDuplicatedDomain tracks a rejected virtual host, so the debug server can show which virtual
+// services collided.
+func recordDuplicatedDomain(node *model.Proxy, name string, virtualService *config.Config) {
+	info := model.DuplicateDomainInfo{Host: name, Proxy: node.ID}
+	if virtualService != nil {
+		info.VirtualService = virtualService.Name
+		info.Namespace = virtualService.Namespace
+	}
+	model.RecordDuplicatedDomain(info)
+}
+
 // duplicateVirtualHost checks whether the virtual host with the same name exists in the route.
 func duplicateVirtualHost(vhost string, vhosts sets.Set) bool {
 	if vhosts.Contains(vhost) {
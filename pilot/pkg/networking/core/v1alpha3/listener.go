@@ -409,17 +409,15 @@ func (c outboundListenerConflict) addMetric(metrics model.Metrics) {
 		currentHostnames[i] = string(s.Hostname)
 	}
 	concatHostnames := strings.Join(currentHostnames, ",")
-	metrics.AddMetric(c.metric,
+	msg := fmt.Sprintf("Listener=%s Accepted%s=%s Rejected%s=%s %sServices=%d",
 		c.listenerName,
-		c.node.ID,
-		fmt.Sprintf("Listener=%s Accepted%s=%s Rejected%s=%s %sServices=%d",
-			c.listenerName,
-			protocolName(c.currentProtocol),
-			concatHostnames,
-			protocolName(c.newProtocol),
-			c.newHostname,
-			protocolName(c.currentProtocol),
-			len(c.currentServices)))
+		protocolName(c.currentProtocol),
+		concatHostnames,
+		protocolName(c.newProtocol),
+		c.newHostname,
+		protocolName(c.currentProtocol),
+		len(c.currentServices))
+	metrics.AddMetric(c.metric, c.listenerName, c.node.ID, msg)
 }
 
 // buildSidecarOutboundListeners generates http and tcp listeners for
@@ -1184,16 +1182,17 @@ type httpListenerOpts struct {
 
 // filterChainOpts describes a filter chain: a set of filters with the same TLS context
 type filterChainOpts struct {
-	filterChainName  string
-	sniHosts         []string
-	destinationCIDRs []string
-	metadata         *core.Metadata
-	tlsContext       *auth.DownstreamTlsContext
-	httpOpts         *httpListenerOpts
-	match            *listener.FilterChainMatch
-	listenerFilters  []*listener.ListenerFilter
-	networkFilters   []*listener.Filter
-	filterChain      istionetworking.FilterChain
+	filterChainName      string
+	sniHosts             []string
+	destinationCIDRs     []string
+	applicationProtocols []string
+	metadata             *core.Metadata
+	tlsContext           *auth.DownstreamTlsContext
+	httpOpts             *httpListenerOpts
+	match                *listener.FilterChainMatch
+	listenerFilters      []*listener.ListenerFilter
+	networkFilters       []*listener.Filter
+	filterChain          istionetworking.FilterChain
 }
 
 // ListenerClass defines the class of the listener
@@ -1325,7 +1324,7 @@ func buildListener(opts buildListenerOpts, trafficDirection core.TrafficDirectio
 	needTLSInspector := false
 	for _, chain := range opts.filterChainOpts {
 		needsALPN := chain.tlsContext != nil && chain.tlsContext.CommonTlsContext != nil && len(chain.tlsContext.CommonTlsContext.AlpnProtocols) > 0
-		if len(chain.sniHosts) > 0 || needsALPN {
+		if len(chain.sniHosts) > 0 || len(chain.applicationProtocols) > 0 || needsALPN {
 			needTLSInspector = true
 			break
 		}
@@ -1383,6 +1382,9 @@ func buildListener(opts buildListenerOpts, trafficDirection core.TrafficDirectio
 				match.ServerNames = chain.sniHosts
 			}
 		}
+		if len(chain.applicationProtocols) > 0 {
+			match.ApplicationProtocols = append(match.ApplicationProtocols, chain.applicationProtocols...)
+		}
 		if len(chain.destinationCIDRs) > 0 {
 			chain.destinationCIDRs = append([]string{}, chain.destinationCIDRs...)
 			sort.Stable(sort.StringSlice(chain.destinationCIDRs))
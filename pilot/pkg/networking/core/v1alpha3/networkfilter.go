@@ -15,6 +15,8 @@
 package v1alpha3
 
 import (
+	"fmt"
+	"net"
 	"time"
 
 	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
@@ -33,11 +35,55 @@ import (
 	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/host"
 	"istio.io/istio/pkg/config/protocol"
+	"istio.io/istio/pkg/util/gogo"
+	"istio.io/pkg/log"
 )
 
 // redisOpTimeout is the default operation timeout for the Redis proxy filter.
 var redisOpTimeout = 5 * time.Second
 
+// TCPTunnelAnnotation can be set on a VirtualService to tunnel the connections of its TCP and TLS routes
+// over HTTP CONNECT to the route destination, which must be an HTTP/2 CONNECT proxy. The value is the
+// host:port sent in the CONNECT request. Tunneling only applies to passthrough traffic.
+const TCPTunnelAnnotation = "networking.istio.io/tcpTunnel"
+
+// NetworkFilterPosition controls where filters returned by an OutboundNetworkFilterFunc are
+// inserted in the outbound TCP/TLS network filter stack.
+type NetworkFilterPosition int
+
+const (
+	// NetworkFilterFirst inserts filters at the front of the stack, before any protocol
+	// specific filters (e.g. Mongo, MySQL).
+	NetworkFilterFirst NetworkFilterPosition = iota
+	// NetworkFilterBeforeTerminal inserts filters after any protocol specific filters, immediately
+	// before the terminal filter (TCP proxy or Redis proxy), which must always remain last.
+	NetworkFilterBeforeTerminal
+)
+
+// OutboundNetworkFilterFunc returns additional network filters for an outbound TCP or TLS filter
+// chain routing to the given destinations. Returning nil leaves the filter chain unchanged.
+type OutboundNetworkFilterFunc func(node *model.Proxy, push *model.PushContext,
+	routes []*networking.RouteDestination, port *model.Port) []*listener.Filter
+
+type outboundNetworkFilterProvider struct {
+	position NetworkFilterPosition
+	build    OutboundNetworkFilterFunc
+}
+
+// outboundNetworkFilterProviders is consulted, in registration order, by buildOutboundNetworkFilters.
+var outboundNetworkFilterProviders []outboundNetworkFilterProvider
+
+// RegisterOutboundNetworkFilterProvider registers fn to contribute network filters to every outbound
+// TCP and TLS filter chain. Filters from providers registered at the same position keep their
+// registration order. It is not safe for concurrent use and must be called before config generation
+// starts, typically from an init function.
+func RegisterOutboundNetworkFilterProvider(position NetworkFilterPosition, fn OutboundNetworkFilterFunc) {
+	outboundNetworkFilterProviders = append(outboundNetworkFilterProviders, outboundNetworkFilterProvider{
+		position: position,
+		build:    fn,
+	})
+}
+
 // buildInboundNetworkFilters generates a TCP proxy network filter on the inbound path
 func buildInboundNetworkFilters(push *model.PushContext, instance *model.ServiceInstance, clusterName string) []*listener.Filter {
 	statPrefix := clusterName
@@ -68,17 +114,21 @@ func setAccessLogAndBuildTCPFilter(push *model.PushContext, config *tcp.TcpProxy
 // buildOutboundNetworkFiltersWithSingleDestination takes a single cluster name
 // and builds a stack of network filters.
 func buildOutboundNetworkFiltersWithSingleDestination(push *model.PushContext, node *model.Proxy,
-	statPrefix, clusterName string, port *model.Port) []*listener.Filter {
+	statPrefix, clusterName, subsetName string, port *model.Port, destinationRule *networking.DestinationRule,
+	tunnelingConfig *tcp.TcpProxy_TunnelingConfig) []*listener.Filter {
 	tcpProxy := &tcp.TcpProxy{
 		StatPrefix:       statPrefix,
 		ClusterSpecifier: &tcp.TcpProxy_Cluster{Cluster: clusterName},
-		// TODO: Need to set other fields such as Idle timeouts
+		TunnelingConfig:  tunnelingConfig,
 	}
 
 	idleTimeout, err := time.ParseDuration(node.Metadata.IdleTimeout)
 	if err == nil {
 		tcpProxy.IdleTimeout = durationpb.New(idleTimeout)
 	}
+	if drIdleTimeout := destinationIdleTimeout(destinationRule, subsetName, port); drIdleTimeout != nil {
+		tcpProxy.IdleTimeout = drIdleTimeout
+	}
 
 	tcpFilter := setAccessLogAndBuildTCPFilter(push, tcpProxy)
 	return buildNetworkFiltersStack(port, tcpFilter, statPrefix, clusterName)
@@ -87,7 +137,7 @@ func buildOutboundNetworkFiltersWithSingleDestination(push *model.PushContext, n
 // buildOutboundNetworkFiltersWithWeightedClusters takes a set of weighted
 // destination routes and builds a stack of network filters.
 func buildOutboundNetworkFiltersWithWeightedClusters(node *model.Proxy, routes []*networking.RouteDestination,
-	push *model.PushContext, port *model.Port, configMeta config.Meta) []*listener.Filter {
+	push *model.PushContext, port *model.Port, configMeta config.Meta, tunnelingConfig *tcp.TcpProxy_TunnelingConfig) []*listener.Filter {
 	statPrefix := configMeta.Name + "." + configMeta.Namespace
 	clusterSpecifier := &tcp.TcpProxy_WeightedClusters{
 		WeightedClusters: &tcp.TcpProxy_WeightedCluster{},
@@ -96,7 +146,7 @@ func buildOutboundNetworkFiltersWithWeightedClusters(node *model.Proxy, routes [
 	proxyConfig := &tcp.TcpProxy{
 		StatPrefix:       statPrefix,
 		ClusterSpecifier: clusterSpecifier,
-		// TODO: Need to set other fields such as Idle timeouts
+		TunnelingConfig:  tunnelingConfig,
 	}
 
 	idleTimeout, err := time.ParseDuration(node.Metadata.IdleTimeout)
@@ -104,6 +154,9 @@ func buildOutboundNetworkFiltersWithWeightedClusters(node *model.Proxy, routes [
 		proxyConfig.IdleTimeout = durationpb.New(idleTimeout)
 	}
 
+	// The TCP proxy has a single idle timeout for all clusters, so use the longest one configured by
+	// the destinations to avoid closing connections to any of them prematurely.
+	var drIdleTimeout *durationpb.Duration
 	for _, route := range routes {
 		service := push.ServiceForHostname(node, host.Name(route.Destination.Host))
 		if route.Weight > 0 {
@@ -112,8 +165,16 @@ func buildOutboundNetworkFiltersWithWeightedClusters(node *model.Proxy, routes [
 				Name:   clusterName,
 				Weight: uint32(route.Weight),
 			})
+			destinationRule := CastDestinationRule(push.DestinationRule(node, service))
+			t := destinationIdleTimeout(destinationRule, route.Destination.Subset, port)
+			if t != nil && (drIdleTimeout == nil || t.AsDuration() > drIdleTimeout.AsDuration()) {
+				drIdleTimeout = t
+			}
 		}
 	}
+	if drIdleTimeout != nil {
+		proxyConfig.IdleTimeout = drIdleTimeout
+	}
 
 	// TODO: Need to handle multiple cluster names for Redis
 	clusterName := clusterSpecifier.WeightedClusters.Clusters[0].Name
@@ -153,10 +214,116 @@ func buildNetworkFiltersStack(port *model.Port, tcpFilter *listener.Filter, stat
 
 // buildOutboundNetworkFilters generates a TCP proxy network filter for outbound
 // connections. In addition, it generates protocol specific filters (e.g., Mongo
-// filter).
+// filter) and any filters from registered outbound network filter providers.
 func buildOutboundNetworkFilters(node *model.Proxy,
 	routes []*networking.RouteDestination, push *model.PushContext,
 	port *model.Port, configMeta config.Meta) []*listener.Filter {
+	routes = normalizeTCPRoutes(node, push, routes, configMeta)
+	filterstack := buildOutboundNetworkFiltersStack(node, routes, push, port, configMeta)
+	if len(outboundNetworkFilterProviders) == 0 {
+		return filterstack
+	}
+
+	var first, beforeTerminal []*listener.Filter
+	for _, p := range outboundNetworkFilterProviders {
+		filters := p.build(node, push, routes, port)
+		switch p.position {
+		case NetworkFilterFirst:
+			first = append(first, filters...)
+		case NetworkFilterBeforeTerminal:
+			beforeTerminal = append(beforeTerminal, filters...)
+		}
+	}
+	if len(first) == 0 && len(beforeTerminal) == 0 {
+		return filterstack
+	}
+
+	// The generated stack always ends with the terminal filter.
+	terminal := len(filterstack) - 1
+	out := make([]*listener.Filter, 0, len(first)+len(filterstack)+len(beforeTerminal))
+	out = append(out, first...)
+	out = append(out, filterstack[:terminal]...)
+	out = append(out, beforeTerminal...)
+	out = append(out, filterstack[terminal])
+	return out
+}
+
+// normalizeTCPRoutes validates the destinations of a TCP or TLS route of a VirtualService, so that a bad
+// route does not silently produce a broken filter. Destinations referencing a subset that is not defined
+// by the destination rule of their host are dropped, unless no destination would be left. The weights of
+// multiple destinations are rescaled to sum up to 100. Invalid routes are reported with the
+// InvalidTCPRoutes metric.
+func normalizeTCPRoutes(node *model.Proxy, push *model.PushContext, routes []*networking.RouteDestination,
+	configMeta config.Meta) []*networking.RouteDestination {
+	key := configMeta.Namespace + "/" + configMeta.Name
+	valid := make([]*networking.RouteDestination, 0, len(routes))
+	for _, route := range routes {
+		if !subsetDefined(node, push, route.Destination) {
+			push.AddMetric(model.InvalidTCPRoutes, key, node.ID, fmt.Sprintf("virtual service %s routes to undefined subset %s of %s",
+				key, route.Destination.Subset, route.Destination.Host))
+			continue
+		}
+		valid = append(valid, route)
+	}
+	if len(valid) == 0 {
+		// Dropping all destinations would leave no filter at all; keep the route as configured.
+		valid = routes
+	}
+	if len(valid) <= 1 {
+		return valid
+	}
+
+	total := 0
+	for _, route := range valid {
+		total += int(route.Weight)
+	}
+	if total == 100 {
+		return valid
+	}
+	if len(valid) == len(routes) {
+		push.AddMetric(model.InvalidTCPRoutes, key, node.ID, fmt.Sprintf("virtual service %s route weights sum up to %d", key, total))
+	}
+	out := make([]*networking.RouteDestination, 0, len(valid))
+	remaining := int32(100)
+	for _, route := range valid {
+		// Without any weight, split the traffic evenly.
+		weight := int32(100 / len(valid))
+		if total > 0 {
+			weight = int32(int(route.Weight) * 100 / total)
+		}
+		remaining -= weight
+		out = append(out, &networking.RouteDestination{Destination: route.Destination, Weight: weight})
+	}
+	// Assign the rounding remainder to the first destination.
+	out[0].Weight += remaining
+	return out
+}
+
+// subsetDefined returns false if the destination references a subset that is not defined by the destination
+// rule of its host. Destinations without a subset, or whose host is not a known service, are not checked.
+func subsetDefined(node *model.Proxy, push *model.PushContext, destination *networking.Destination) bool {
+	if destination == nil || destination.Subset == "" {
+		return true
+	}
+	service := push.ServiceForHostname(node, host.Name(destination.Host))
+	if service == nil {
+		return true
+	}
+	destinationRule := CastDestinationRule(push.DestinationRule(node, service))
+	for _, subset := range destinationRule.GetSubsets() {
+		if subset.Name == destination.Subset {
+			return true
+		}
+	}
+	return false
+}
+
+// buildOutboundNetworkFiltersStack builds the protocol specific filters and the terminal
+// filter for outbound connections to the given routes.
+func buildOutboundNetworkFiltersStack(node *model.Proxy,
+	routes []*networking.RouteDestination, push *model.PushContext,
+	port *model.Port, configMeta config.Meta) []*listener.Filter {
+	tunnelingConfig := buildTCPTunnelingConfig(configMeta)
 	if len(routes) == 1 {
 		service := push.ServiceForHostname(node, host.Name(routes[0].Destination.Host))
 		clusterName := istio_route.GetDestinationCluster(routes[0].Destination, service, port.Port)
@@ -166,9 +333,59 @@ func buildOutboundNetworkFilters(node *model.Proxy,
 			statPrefix = util.BuildStatPrefix(push.Mesh.OutboundClusterStatName, routes[0].Destination.Host,
 				routes[0].Destination.Subset, port, service.Attributes)
 		}
-		return buildOutboundNetworkFiltersWithSingleDestination(push, node, statPrefix, clusterName, port)
+		destinationRule := CastDestinationRule(push.DestinationRule(node, service))
+		return buildOutboundNetworkFiltersWithSingleDestination(push, node, statPrefix, clusterName,
+			routes[0].Destination.Subset, port, destinationRule, tunnelingConfig)
+	}
+	return buildOutboundNetworkFiltersWithWeightedClusters(node, routes, push, port, configMeta, tunnelingConfig)
+}
+
+// buildTCPTunnelingConfig returns the TCP proxy tunneling config requested by the TCPTunnelAnnotation of
+// the given VirtualService, or nil if tunneling is not requested or the annotation value is invalid.
+func buildTCPTunnelingConfig(configMeta config.Meta) *tcp.TcpProxy_TunnelingConfig {
+	hostname, f := configMeta.Annotations[TCPTunnelAnnotation]
+	if !f {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(hostname); err != nil {
+		log.Warnf("ignoring invalid %s annotation %q on %s/%s: %v", TCPTunnelAnnotation, hostname,
+			configMeta.Namespace, configMeta.Name, err)
+		return nil
+	}
+	return &tcp.TcpProxy_TunnelingConfig{Hostname: hostname}
+}
+
+// withoutTCPTunnel returns a copy of configMeta that does not request tunneling.
+func withoutTCPTunnel(configMeta config.Meta) config.Meta {
+	if _, f := configMeta.Annotations[TCPTunnelAnnotation]; !f {
+		return configMeta
+	}
+	annotations := make(map[string]string, len(configMeta.Annotations))
+	for k, v := range configMeta.Annotations {
+		if k != TCPTunnelAnnotation {
+			annotations[k] = v
+		}
+	}
+	configMeta.Annotations = annotations
+	return configMeta
+}
+
+// destinationIdleTimeout returns the connection pool idle timeout the destination rule configures for
+// the given subset and port, or nil if none is set. The DestinationRule API only exposes an idle timeout
+// in the HTTP connection pool settings, which is only applied to TCP proxy connections if
+// features.TCPIdleTimeoutFromDestinationRule is enabled.
+func destinationIdleTimeout(destinationRule *networking.DestinationRule, subsetName string, port *model.Port) *durationpb.Duration {
+	if destinationRule == nil || !features.TCPIdleTimeoutFromDestinationRule {
+		return nil
+	}
+	policy := MergeTrafficPolicy(nil, destinationRule.TrafficPolicy, port)
+	for _, subset := range destinationRule.Subsets {
+		if subset.Name == subsetName {
+			policy = MergeTrafficPolicy(policy, subset.TrafficPolicy, port)
+			break
+		}
 	}
-	return buildOutboundNetworkFiltersWithWeightedClusters(node, routes, push, port, configMeta)
+	return gogo.DurationToProtoDuration(policy.GetConnectionPool().GetHttp().GetIdleTimeout())
 }
 
 // buildMongoFilter builds an outbound Envoy MongoProxy filter.
@@ -194,7 +411,7 @@ func buildMongoFilter(statPrefix string) *listener.Filter {
 func buildOutboundAutoPassthroughFilterStack(push *model.PushContext, node *model.Proxy, port *model.Port) []*listener.Filter {
 	// First build tcp with access logs
 	// then add sni_cluster to the front
-	tcpProxy := buildOutboundNetworkFiltersWithSingleDestination(push, node, util.BlackHoleCluster, util.BlackHoleCluster, port)
+	tcpProxy := buildOutboundNetworkFiltersWithSingleDestination(push, node, util.BlackHoleCluster, util.BlackHoleCluster, "", port, nil, nil)
 	filterstack := make([]*listener.Filter, 0)
 	filterstack = append(filterstack, &listener.Filter{
 		Name: util.SniClusterFilter,
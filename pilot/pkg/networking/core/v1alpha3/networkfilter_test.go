@@ -15,17 +15,22 @@
 package v1alpha3
 
 import (
+	"reflect"
 	"testing"
+	"time"
 
 	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	redis "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/redis_proxy/v3"
 	tcp "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
 	wellknown "github.com/envoyproxy/go-control-plane/pkg/wellknown"
+	"github.com/gogo/protobuf/types"
 
 	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/features"
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/protocol"
+	"istio.io/istio/pkg/config/schema/gvk"
 )
 
 func TestBuildRedisFilter(t *testing.T) {
@@ -221,3 +226,251 @@ func TestOutboundNetworkFilterStatPrefix(t *testing.T) {
 		})
 	}
 }
+
+func TestOutboundNetworkFilterProviders(t *testing.T) {
+	defer func() { outboundNetworkFilterProviders = nil }()
+	provider := func(names ...string) OutboundNetworkFilterFunc {
+		return func(*model.Proxy, *model.PushContext, []*networking.RouteDestination, *model.Port) []*listener.Filter {
+			filters := make([]*listener.Filter, 0, len(names))
+			for _, n := range names {
+				filters = append(filters, &listener.Filter{Name: n})
+			}
+			return filters
+		}
+	}
+	RegisterOutboundNetworkFilterProvider(NetworkFilterBeforeTerminal, provider("before-terminal-1"))
+	RegisterOutboundNetworkFilterProvider(NetworkFilterFirst, provider("first-1", "first-2"))
+	RegisterOutboundNetworkFilterProvider(NetworkFilterFirst, provider())
+	RegisterOutboundNetworkFilterProvider(NetworkFilterBeforeTerminal, provider("before-terminal-2"))
+
+	services := []*model.Service{
+		buildService("test.com", "10.10.0.0/24", protocol.Mongo, tnow),
+	}
+	env := buildListenerEnv(services)
+	env.PushContext.InitContext(env, nil, nil)
+	proxy := getProxy()
+	proxy.SidecarScope = model.DefaultSidecarScopeForNamespace(env.PushContext, "not-default")
+
+	routes := []*networking.RouteDestination{{Destination: &networking.Destination{Host: "test.com"}}}
+	filters := buildOutboundNetworkFilters(proxy, routes, env.PushContext,
+		&model.Port{Port: 9999, Protocol: protocol.Mongo}, config.Meta{Name: "test.com", Namespace: "ns"})
+	got := make([]string, 0, len(filters))
+	for _, f := range filters {
+		got = append(got, f.Name)
+	}
+	want := []string{"first-1", "first-2", wellknown.MongoProxy, "before-terminal-1", "before-terminal-2", wellknown.TCPProxy}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected filters, got %v, want %v", got, want)
+	}
+}
+
+func TestOutboundNetworkFilterIdleTimeout(t *testing.T) {
+	idleTimeoutPolicy := func(d time.Duration) *networking.TrafficPolicy {
+		return &networking.TrafficPolicy{
+			ConnectionPool: &networking.ConnectionPoolSettings{
+				Http: &networking.ConnectionPoolSettings_HTTPSettings{IdleTimeout: types.DurationProto(d)},
+			},
+		}
+	}
+	cases := []struct {
+		name            string
+		subset          string
+		destinationRule *networking.DestinationRule
+		metadataTimeout string
+		optIn           bool
+		expected        time.Duration
+	}{
+		{
+			name:     "no idle timeout",
+			expected: 0,
+		},
+		{
+			name:            "metadata idle timeout",
+			metadataTimeout: "1h",
+			expected:        time.Hour,
+		},
+		{
+			name:            "http idle timeout ignored by default",
+			destinationRule: &networking.DestinationRule{TrafficPolicy: idleTimeoutPolicy(30 * time.Second)},
+			expected:        0,
+		},
+		{
+			name:            "http idle timeout ignored by default with metadata",
+			metadataTimeout: "1h",
+			destinationRule: &networking.DestinationRule{TrafficPolicy: idleTimeoutPolicy(30 * time.Second)},
+			expected:        time.Hour,
+		},
+		{
+			name:            "destination rule overrides metadata",
+			metadataTimeout: "1h",
+			destinationRule: &networking.DestinationRule{TrafficPolicy: idleTimeoutPolicy(10 * time.Hour)},
+			optIn:           true,
+			expected:        10 * time.Hour,
+		},
+		{
+			name:   "subset overrides destination rule",
+			subset: "v1",
+			destinationRule: &networking.DestinationRule{
+				TrafficPolicy: idleTimeoutPolicy(10 * time.Hour),
+				Subsets:       []*networking.Subset{{Name: "v1", TrafficPolicy: idleTimeoutPolicy(time.Minute)}},
+			},
+			optIn:    true,
+			expected: time.Minute,
+		},
+	}
+
+	env := buildListenerEnv(nil)
+	env.PushContext.InitContext(env, nil, nil)
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultValue := features.TCPIdleTimeoutFromDestinationRule
+			features.TCPIdleTimeoutFromDestinationRule = tt.optIn
+			defer func() { features.TCPIdleTimeoutFromDestinationRule = defaultValue }()
+			proxy := getProxy()
+			proxy.Metadata.IdleTimeout = tt.metadataTimeout
+			filters := buildOutboundNetworkFiltersWithSingleDestination(env.PushContext, proxy, "stat", "cluster", tt.subset,
+				&model.Port{Port: 9999, Protocol: protocol.TCP}, tt.destinationRule, nil)
+			tcpProxy := &tcp.TcpProxy{}
+			if err := filters[len(filters)-1].GetTypedConfig().UnmarshalTo(tcpProxy); err != nil {
+				t.Fatal(err)
+			}
+			if got := tcpProxy.GetIdleTimeout().AsDuration(); got != tt.expected {
+				t.Fatalf("unexpected idle timeout, got %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestOutboundNetworkFilterTCPTunnel(t *testing.T) {
+	services := []*model.Service{
+		buildService("test.com", "10.10.0.0/24", protocol.TCP, tnow),
+	}
+	env := buildListenerEnv(services)
+	env.PushContext.InitContext(env, nil, nil)
+	proxy := getProxy()
+	proxy.SidecarScope = model.DefaultSidecarScopeForNamespace(env.PushContext, "not-default")
+
+	single := []*networking.RouteDestination{{Destination: &networking.Destination{Host: "test.com"}}}
+	weighted := []*networking.RouteDestination{
+		{Destination: &networking.Destination{Host: "test.com", Subset: "v1"}, Weight: 50},
+		{Destination: &networking.Destination{Host: "test.com", Subset: "v2"}, Weight: 50},
+	}
+	cases := []struct {
+		name        string
+		routes      []*networking.RouteDestination
+		annotations map[string]string
+		expected    string
+	}{
+		{
+			name:   "no annotation",
+			routes: single,
+		},
+		{
+			name:        "single destination",
+			routes:      single,
+			annotations: map[string]string{TCPTunnelAnnotation: "example.com:443"},
+			expected:    "example.com:443",
+		},
+		{
+			name:        "weighted destinations",
+			routes:      weighted,
+			annotations: map[string]string{TCPTunnelAnnotation: "example.com:443"},
+			expected:    "example.com:443",
+		},
+		{
+			name:        "invalid hostname",
+			routes:      single,
+			annotations: map[string]string{TCPTunnelAnnotation: "example.com"},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			filters := buildOutboundNetworkFilters(proxy, tt.routes, env.PushContext, &model.Port{Port: 9999, Protocol: protocol.TCP},
+				config.Meta{Name: "test.com", Namespace: "ns", Annotations: tt.annotations})
+			tcpProxy := &tcp.TcpProxy{}
+			if err := filters[len(filters)-1].GetTypedConfig().UnmarshalTo(tcpProxy); err != nil {
+				t.Fatal(err)
+			}
+			if got := tcpProxy.GetTunnelingConfig().GetHostname(); got != tt.expected {
+				t.Fatalf("unexpected tunneling hostname, got %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNormalizeTCPRoutes(t *testing.T) {
+	service := buildService("test.com", "10.10.0.1", protocol.TCP, tnow)
+	cg := NewConfigGenTest(t, TestOptions{
+		Services: []*model.Service{service},
+		Configs: []config.Config{{
+			Meta: config.Meta{GroupVersionKind: gvk.DestinationRule, Name: "dr", Namespace: "default"},
+			Spec: &networking.DestinationRule{Host: "test.com", Subsets: []*networking.Subset{{Name: "v1"}, {Name: "v2"}}},
+		}},
+	})
+	proxy := cg.SetupProxy(getProxy())
+	destination := func(host, subset string, weight int32) *networking.RouteDestination {
+		return &networking.RouteDestination{Destination: &networking.Destination{Host: host, Subset: subset}, Weight: weight}
+	}
+
+	cases := []struct {
+		name     string
+		routes   []*networking.RouteDestination
+		expected []*networking.RouteDestination
+		invalid  bool
+	}{
+		{
+			name:     "single destination",
+			routes:   []*networking.RouteDestination{destination("test.com", "", 0)},
+			expected: []*networking.RouteDestination{destination("test.com", "", 0)},
+		},
+		{
+			name:     "valid weights",
+			routes:   []*networking.RouteDestination{destination("test.com", "v1", 30), destination("test.com", "v2", 70)},
+			expected: []*networking.RouteDestination{destination("test.com", "v1", 30), destination("test.com", "v2", 70)},
+		},
+		{
+			name:     "weights not summing up to 100",
+			routes:   []*networking.RouteDestination{destination("test.com", "v1", 1), destination("test.com", "v2", 2)},
+			expected: []*networking.RouteDestination{destination("test.com", "v1", 34), destination("test.com", "v2", 66)},
+			invalid:  true,
+		},
+		{
+			name:     "no weights",
+			routes:   []*networking.RouteDestination{destination("test.com", "v1", 0), destination("test.com", "v2", 0)},
+			expected: []*networking.RouteDestination{destination("test.com", "v1", 50), destination("test.com", "v2", 50)},
+			invalid:  true,
+		},
+		{
+			name: "missing subset",
+			routes: []*networking.RouteDestination{
+				destination("test.com", "v1", 40), destination("test.com", "v3", 20), destination("test.com", "v2", 40),
+			},
+			expected: []*networking.RouteDestination{destination("test.com", "v1", 50), destination("test.com", "v2", 50)},
+			invalid:  true,
+		},
+		{
+			name:     "only missing subsets",
+			routes:   []*networking.RouteDestination{destination("test.com", "v3", 0)},
+			expected: []*networking.RouteDestination{destination("test.com", "v3", 0)},
+			invalid:  true,
+		},
+		{
+			name:     "unknown host is not checked",
+			routes:   []*networking.RouteDestination{destination("unknown.com", "v3", 0)},
+			expected: []*networking.RouteDestination{destination("unknown.com", "v3", 0)},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			push := cg.PushContext()
+			push.ProxyStatus = map[string]map[string]model.ProxyPushStatus{}
+			got := normalizeTCPRoutes(proxy, push, tt.routes, config.Meta{Name: "vs", Namespace: "default"})
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Fatalf("unexpected routes: got %v, want %v", got, tt.expected)
+			}
+			if _, invalid := push.ProxyStatus[model.InvalidTCPRoutes.Name()]["default/vs"]; invalid != tt.invalid {
+				t.Fatalf("expected invalid to be %v, got %v", tt.invalid, invalid)
+			}
+		})
+	}
+}
@@ -15,7 +15,9 @@
 package route
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"sort"
 	"strconv"
 	"strings"
@@ -27,9 +29,11 @@ import (
 	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
 	xdstype "github.com/envoyproxy/go-control-plane/envoy/type/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+	"github.com/gogo/protobuf/jsonpb"
 	"github.com/golang/protobuf/ptypes/any"
 	"github.com/golang/protobuf/ptypes/duration"
 	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/durationpb"
 
 	networking "istio.io/api/networking/v1alpha3"
@@ -55,6 +59,16 @@ const (
 // DefaultRouteName is the name assigned to a route generated by default in absence of a virtual service.
 const DefaultRouteName = "default"
 
+// MirrorConditionAnnotation can be set on a VirtualService to only mirror requests matching the given headers.
+// The value is a JSON object mapping header names to string matches, e.g. {"x-shadow":{"exact":"true"}}.
+// It applies to all HTTP routes of the VirtualService that mirror requests.
+const MirrorConditionAnnotation = "networking.istio.io/mirrorCondition"
+
+// CORSFilterEnabledAnnotation can be set on a VirtualService to enable the CORS filter for a percentage of the
+// requests only, e.g. "0.5", so CORS policies can be rolled out gradually. It applies to all HTTP routes of the
+// VirtualService with a CORS policy. The CORS filter is enabled for all requests if it is not set.
+const CORSFilterEnabledAnnotation = "networking.istio.io/corsFilterEnabled"
+
 var regexEngine = &matcher.RegexMatcher_GoogleRe2{GoogleRe2: &matcher.RegexMatcher_GoogleRE2{}}
 
 // VirtualHostWrapper is a context-dependent virtual host entry with guarded routes.
@@ -78,6 +92,9 @@ type VirtualHostWrapper struct {
 
 	// Routes in the virtual host
 	Routes []*route.Route
+
+	// VirtualService is the virtual service the virtual host is built from, if any.
+	VirtualService *config.Config
 }
 
 // BuildSidecarVirtualHostWrapper creates virtual hosts from
@@ -196,6 +213,7 @@ func buildSidecarVirtualHostsForVirtualService(
 			Services:            service,
 			VirtualServiceHosts: hosts,
 			Routes:              routes,
+			VirtualService:      &virtualService,
 		})
 	}
 
@@ -270,17 +288,19 @@ func BuildHTTPRoutesForVirtualService(
 
 	out := make([]*route.Route, 0, len(vs.Http))
 
+	mirrorCondition := mirrorConditionForVirtualService(virtualService)
+	corsEnabled := corsFilterEnabledForVirtualService(virtualService)
 	catchall := false
 	for _, http := range vs.Http {
 		if len(http.Match) == 0 {
-			if r := translateRoute(push, node, http, nil, listenPort, virtualService, serviceRegistry, gatewayNames); r != nil {
-				out = append(out, r)
+			if r := translateRoute(push, node, http, nil, listenPort, virtualService, corsEnabled, serviceRegistry, gatewayNames); r != nil {
+				out = append(out, withMirrorCondition(r, mirrorCondition)...)
 			}
 			catchall = true
 		} else {
 			for _, match := range http.Match {
-				if r := translateRoute(push, node, http, match, listenPort, virtualService, serviceRegistry, gatewayNames); r != nil {
-					out = append(out, r)
+				if r := translateRoute(push, node, http, match, listenPort, virtualService, corsEnabled, serviceRegistry, gatewayNames); r != nil {
+					out = append(out, withMirrorCondition(r, mirrorCondition)...)
 					// This is a catch all path. Routes are matched in order, so we will never go beyond this match
 					// As an optimization, we can just top sending any more routes here.
 					if isCatchAllMatch(match) {
@@ -301,6 +321,69 @@ func BuildHTTPRoutesForVirtualService(
 	return out, nil
 }
 
+// mirrorConditionForVirtualService returns the header matches requests must satisfy to be mirrored, as set by
+// the MirrorConditionAnnotation of the virtual service. Invalid conditions are ignored.
+func mirrorConditionForVirtualService(virtualService config.Config) map[string]*networking.StringMatch {
+	value, f := virtualService.Annotations[MirrorConditionAnnotation]
+	if !f {
+		return nil
+	}
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		log.Warnf("ignoring invalid %s annotation on %s/%s: %v", MirrorConditionAnnotation,
+			virtualService.Namespace, virtualService.Name, err)
+		return nil
+	}
+	condition := make(map[string]*networking.StringMatch, len(raw))
+	for name, m := range raw {
+		match := &networking.StringMatch{}
+		if err := jsonpb.UnmarshalString(string(m), match); err != nil || match.MatchType == nil {
+			log.Warnf("ignoring invalid %s annotation on %s/%s: invalid match for header %s", MirrorConditionAnnotation,
+				virtualService.Namespace, virtualService.Name, name)
+			return nil
+		}
+		condition[name] = match
+	}
+	return condition
+}
+
+// corsFilterEnabledForVirtualService returns the percentage of requests the CORS filter is enabled for, as set by
+// the CORSFilterEnabledAnnotation of the virtual service. Invalid percentages are ignored.
+func corsFilterEnabledForVirtualService(virtualService config.Config) *networking.Percent {
+	value, f := virtualService.Annotations[CORSFilterEnabledAnnotation]
+	if !f {
+		return nil
+	}
+	percent, err := strconv.ParseFloat(value, 64)
+	if err != nil || percent < 0 || percent > 100 {
+		log.Warnf("ignoring invalid %s annotation on %s/%s: %q is not a percentage", CORSFilterEnabledAnnotation,
+			virtualService.Namespace, virtualService.Name, value)
+		return nil
+	}
+	return &networking.Percent{Value: percent}
+}
+
+// withMirrorCondition restricts request mirroring of the route to requests matching the condition headers. This
+// is done by splitting the route in a route that additionally matches the headers and mirrors requests, followed
+// by the original route without mirroring.
+func withMirrorCondition(r *route.Route, condition map[string]*networking.StringMatch) []*route.Route {
+	action := r.GetRoute()
+	if len(condition) == 0 || len(action.GetRequestMirrorPolicies()) == 0 {
+		return []*route.Route{r}
+	}
+	mirrored := proto.Clone(r).(*route.Route)
+	names := make([]string, 0, len(condition))
+	for name := range condition {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		mirrored.Match.Headers = append(mirrored.Match.Headers, translateHeaderMatch(name, condition[name]))
+	}
+	action.RequestMirrorPolicies = nil
+	return []*route.Route{mirrored, r}
+}
+
 // sourceMatchHttp checks if the sourceLabels or the gateways in a match condition match with the
 // labels for the proxy or the gateway name for which we are generating a route
 func sourceMatchHTTP(match *networking.HTTPMatchRequest, proxyLabels labels.Collection, gatewayNames map[string]bool, proxyNamespace string) bool {
@@ -326,6 +409,7 @@ func sourceMatchHTTP(match *networking.HTTPMatchRequest, proxyLabels labels.Coll
 func translateRoute(push *model.PushContext, node *model.Proxy, in *networking.HTTPRoute,
 	match *networking.HTTPMatchRequest, port int,
 	virtualService config.Config,
+	corsEnabled *networking.Percent,
 	serviceRegistry map[host.Name]*model.Service,
 	gatewayNames map[string]bool) *route.Route {
 	// When building routes, its okay if the target cluster cannot be
@@ -391,7 +475,7 @@ func translateRoute(push *model.PushContext, node *model.Proxy, in *networking.H
 		out.Action = action
 	} else {
 		action := &route.RouteAction{
-			Cors:        translateCORSPolicy(in.CorsPolicy),
+			Cors:        translateCORSPolicy(in.CorsPolicy, corsEnabled),
 			RetryPolicy: retry.ConvertPolicy(in.Retries),
 		}
 
@@ -738,6 +822,11 @@ func translateRouteMatch(in *networking.HTTPMatchRequest) *route.RouteMatch {
 		out.QueryParameters = append(out.QueryParameters, matcher)
 	}
 
+	// guarantee ordering of query parameters
+	sort.Slice(out.QueryParameters, func(i, j int) bool {
+		return out.QueryParameters[i].Name < out.QueryParameters[j].Name
+	})
+
 	return out
 }
 
@@ -747,22 +836,8 @@ func translateQueryParamMatch(name string, in *networking.StringMatch) *route.Qu
 		Name: name,
 	}
 
-	switch m := in.MatchType.(type) {
-	case *networking.StringMatch_Exact:
-		out.QueryParameterMatchSpecifier = &route.QueryParameterMatcher_StringMatch{
-			StringMatch: &matcher.StringMatcher{MatchPattern: &matcher.StringMatcher_Exact{Exact: m.Exact}},
-		}
-	case *networking.StringMatch_Regex:
-		out.QueryParameterMatchSpecifier = &route.QueryParameterMatcher_StringMatch{
-			StringMatch: &matcher.StringMatcher{
-				MatchPattern: &matcher.StringMatcher_SafeRegex{
-					SafeRegex: &matcher.RegexMatcher{
-						EngineType: regexEngine,
-						Regex:      m.Regex,
-					},
-				},
-			},
-		}
+	if stringMatch := convertToEnvoyStringMatch(in); stringMatch != nil {
+		out.QueryParameterMatchSpecifier = &route.QueryParameterMatcher_StringMatch{StringMatch: stringMatch}
 	}
 
 	return out
@@ -829,28 +904,38 @@ func convertToEnvoyMatch(in []*networking.StringMatch) []*matcher.StringMatcher
 	res := make([]*matcher.StringMatcher, 0, len(in))
 
 	for _, istioMatcher := range in {
-		switch m := istioMatcher.MatchType.(type) {
-		case *networking.StringMatch_Exact:
-			res = append(res, &matcher.StringMatcher{MatchPattern: &matcher.StringMatcher_Exact{Exact: m.Exact}})
-		case *networking.StringMatch_Prefix:
-			res = append(res, &matcher.StringMatcher{MatchPattern: &matcher.StringMatcher_Prefix{Prefix: m.Prefix}})
-		case *networking.StringMatch_Regex:
-			res = append(res, &matcher.StringMatcher{
-				MatchPattern: &matcher.StringMatcher_SafeRegex{
-					SafeRegex: &matcher.RegexMatcher{
-						EngineType: regexEngine,
-						Regex:      m.Regex,
-					},
-				},
-			})
+		if m := convertToEnvoyStringMatch(istioMatcher); m != nil {
+			res = append(res, m)
 		}
 	}
 
 	return res
 }
 
-// translateCORSPolicy translates CORS policy
-func translateCORSPolicy(in *networking.CorsPolicy) *route.CorsPolicy {
+// convertToEnvoyStringMatch converts a StringMatch to an envoy StringMatcher.
+// It returns nil if no match type is set.
+func convertToEnvoyStringMatch(in *networking.StringMatch) *matcher.StringMatcher {
+	switch m := in.GetMatchType().(type) {
+	case *networking.StringMatch_Exact:
+		return &matcher.StringMatcher{MatchPattern: &matcher.StringMatcher_Exact{Exact: m.Exact}}
+	case *networking.StringMatch_Prefix:
+		return &matcher.StringMatcher{MatchPattern: &matcher.StringMatcher_Prefix{Prefix: m.Prefix}}
+	case *networking.StringMatch_Regex:
+		return &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_SafeRegex{
+				SafeRegex: &matcher.RegexMatcher{
+					EngineType: regexEngine,
+					Regex:      m.Regex,
+				},
+			},
+		}
+	}
+	return nil
+}
+
+// translateCORSPolicy translates CORS policy. The CORS filter is enabled for the
+// enabled percentage of requests, or all requests if it is not set.
+func translateCORSPolicy(in *networking.CorsPolicy, enabled *networking.Percent) *route.CorsPolicy {
 	if in == nil {
 		return nil
 	}
@@ -864,12 +949,7 @@ func translateCORSPolicy(in *networking.CorsPolicy) *route.CorsPolicy {
 	}
 
 	out.EnabledSpecifier = &route.CorsPolicy_FilterEnabled{
-		FilterEnabled: &core.RuntimeFractionalPercent{
-			DefaultValue: &xdstype.FractionalPercent{
-				Numerator:   100,
-				Denominator: xdstype.FractionalPercent_HUNDRED,
-			},
-		},
+		FilterEnabled: corsFilterEnabled(enabled),
 	}
 
 	out.AllowCredentials = gogo.BoolToProtoBool(in.AllowCredentials)
@@ -882,6 +962,20 @@ func translateCORSPolicy(in *networking.CorsPolicy) *route.CorsPolicy {
 	return &out
 }
 
+// corsFilterEnabled computes the fraction of requests the CORS filter is enabled for.
+// Like mirrorPercent, a percentage uses the MILLION denominator for sub-1% precision.
+func corsFilterEnabled(enabled *networking.Percent) *core.RuntimeFractionalPercent {
+	if enabled == nil {
+		// Default to 100 percent if percent is not given.
+		return &core.RuntimeFractionalPercent{
+			DefaultValue: translateIntegerToFractionalPercent(100),
+		}
+	}
+	return &core.RuntimeFractionalPercent{
+		DefaultValue: translatePercentToFractionalPercent(enabled),
+	}
+}
+
 // getRouteOperation returns readable route description for trace.
 func getRouteOperation(in *route.Route, vsName string, port int) string {
 	path := "/*"
@@ -947,10 +1041,17 @@ func BuildDefaultHTTPOutboundRoute(node *model.Proxy, clusterName string, operat
 }
 
 // translatePercentToFractionalPercent translates an v1alpha3 Percent instance
-// to an envoy.type.FractionalPercent instance.
+// to an envoy.type.FractionalPercent instance. The value is rounded to the
+// nearest millionth and clamped to [0, 100] percent.
 func translatePercentToFractionalPercent(p *networking.Percent) *xdstype.FractionalPercent {
+	numerator := math.Round(p.Value * 10000)
+	if numerator < 0 {
+		numerator = 0
+	} else if numerator > 1000000 {
+		numerator = 1000000
+	}
 	return &xdstype.FractionalPercent{
-		Numerator:   uint32(p.Value * 10000),
+		Numerator:   uint32(numerator),
 		Denominator: xdstype.FractionalPercent_MILLION,
 	}
 }
@@ -1197,9 +1298,10 @@ func isCatchAllRoute(r *route.Route) bool {
 	case *route.RouteMatch_SafeRegex:
 		catchall = ir.SafeRegex.GetRegex() == "*"
 	}
-	// A Match is catch all if and only if it has no header/query param match
+	// A Match is catch all if and only if it has no header/query param/grpc/tls/runtime fraction match
 	// and URI has a prefix / or regex *.
-	return catchall && len(r.Match.Headers) == 0 && len(r.Match.QueryParameters) == 0
+	return catchall && len(r.Match.Headers) == 0 && len(r.Match.QueryParameters) == 0 &&
+		r.Match.Grpc == nil && r.Match.TlsContext == nil && r.Match.RuntimeFraction == nil
 }
 
 func traceOperation(host string, port int) string {
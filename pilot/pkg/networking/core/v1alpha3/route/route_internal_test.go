@@ -206,6 +206,51 @@ func TestIsCatchAllRoute(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			name: "prefix with query param string match",
+			route: &route.Route{
+				Name: "non-catch-all",
+				Match: &route.RouteMatch{
+					PathSpecifier: &route.RouteMatch_Prefix{
+						Prefix: "/",
+					},
+					QueryParameters: []*route.QueryParameterMatcher{
+						translateQueryParamMatch("Authentication", &networking.StringMatch{
+							MatchType: &networking.StringMatch_Exact{Exact: "token"},
+						}),
+					},
+				},
+			},
+			want: false,
+		},
+		{
+			name: "prefix with runtime fraction",
+			route: &route.Route{
+				Name: "non-catch-all",
+				Match: &route.RouteMatch{
+					PathSpecifier: &route.RouteMatch_Prefix{
+						Prefix: "/",
+					},
+					RuntimeFraction: &core.RuntimeFractionalPercent{
+						DefaultValue: translateIntegerToFractionalPercent(50),
+					},
+				},
+			},
+			want: false,
+		},
+		{
+			name: "prefix with grpc match",
+			route: &route.Route{
+				Name: "non-catch-all",
+				Match: &route.RouteMatch{
+					PathSpecifier: &route.RouteMatch_Prefix{
+						Prefix: "/",
+					},
+					Grpc: &route.RouteMatch_GrpcRouteMatchOptions{},
+				},
+			},
+			want: false,
+		},
 	}
 
 	for _, tt := range cases {
@@ -287,30 +332,53 @@ func TestTranslateCORSPolicy(t *testing.T) {
 			{MatchType: &networking.StringMatch_Regex{Regex: "regex"}},
 		},
 	}
-	expectedCorsPolicy := &route.CorsPolicy{
-		AllowOriginStringMatch: []*matcher.StringMatcher{
-			{MatchPattern: &matcher.StringMatcher_Exact{Exact: "exact"}},
-			{MatchPattern: &matcher.StringMatcher_Prefix{Prefix: "prefix"}},
-			{
-				MatchPattern: &matcher.StringMatcher_SafeRegex{
-					SafeRegex: &matcher.RegexMatcher{
-						EngineType: regexEngine,
-						Regex:      "regex",
-					},
+	allowOrigins := []*matcher.StringMatcher{
+		{MatchPattern: &matcher.StringMatcher_Exact{Exact: "exact"}},
+		{MatchPattern: &matcher.StringMatcher_Prefix{Prefix: "prefix"}},
+		{
+			MatchPattern: &matcher.StringMatcher_SafeRegex{
+				SafeRegex: &matcher.RegexMatcher{
+					EngineType: regexEngine,
+					Regex:      "regex",
 				},
 			},
 		},
-		EnabledSpecifier: &route.CorsPolicy_FilterEnabled{
-			FilterEnabled: &core.RuntimeFractionalPercent{
-				DefaultValue: &xdstype.FractionalPercent{
-					Numerator:   100,
-					Denominator: xdstype.FractionalPercent_HUNDRED,
-				},
+	}
+	cases := []struct {
+		name    string
+		enabled *networking.Percent
+		want    *xdstype.FractionalPercent
+	}{
+		{
+			name: "enabled by default",
+			want: &xdstype.FractionalPercent{
+				Numerator:   100,
+				Denominator: xdstype.FractionalPercent_HUNDRED,
+			},
+		},
+		{
+			name:    "fractional enabled",
+			enabled: &networking.Percent{Value: 0.5},
+			want: &xdstype.FractionalPercent{
+				Numerator:   5000,
+				Denominator: xdstype.FractionalPercent_MILLION,
 			},
 		},
 	}
-	if got := translateCORSPolicy(corsPolicy); !reflect.DeepEqual(got, expectedCorsPolicy) {
-		t.Errorf("translateCORSPolicy() = \n%v, want \n%v", got, expectedCorsPolicy)
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			expectedCorsPolicy := &route.CorsPolicy{
+				AllowOriginStringMatch: allowOrigins,
+				EnabledSpecifier: &route.CorsPolicy_FilterEnabled{
+					FilterEnabled: &core.RuntimeFractionalPercent{
+						DefaultValue: tt.want,
+					},
+				},
+			}
+			if got := translateCORSPolicy(corsPolicy, tt.enabled); !reflect.DeepEqual(got, expectedCorsPolicy) {
+				t.Errorf("translateCORSPolicy() = \n%v, want \n%v", got, expectedCorsPolicy)
+			}
+		})
 	}
 }
 
@@ -388,6 +456,45 @@ func TestMirrorPercent(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "mirrorpercentage with smallest representable percent",
+			route: &networking.HTTPRoute{
+				Mirror:           &networking.Destination{},
+				MirrorPercentage: &networking.Percent{Value: 0.0001},
+			},
+			want: &core.RuntimeFractionalPercent{
+				DefaultValue: &xdstype.FractionalPercent{
+					Numerator:   1,
+					Denominator: xdstype.FractionalPercent_MILLION,
+				},
+			},
+		},
+		{
+			name: "mirrorpercentage with fractional percent",
+			route: &networking.HTTPRoute{
+				Mirror:           &networking.Destination{},
+				MirrorPercentage: &networking.Percent{Value: 99.9999},
+			},
+			want: &core.RuntimeFractionalPercent{
+				DefaultValue: &xdstype.FractionalPercent{
+					Numerator:   999999,
+					Denominator: xdstype.FractionalPercent_MILLION,
+				},
+			},
+		},
+		{
+			name: "mirrorpercentage above 100 percent",
+			route: &networking.HTTPRoute{
+				Mirror:           &networking.Destination{},
+				MirrorPercentage: &networking.Percent{Value: 150},
+			},
+			want: &core.RuntimeFractionalPercent{
+				DefaultValue: &xdstype.FractionalPercent{
+					Numerator:   1000000,
+					Denominator: xdstype.FractionalPercent_MILLION,
+				},
+			},
+		},
 	}
 
 	for _, tt := range cases {
@@ -459,3 +566,45 @@ func TestSourceMatchHTTP(t *testing.T) {
 		})
 	}
 }
+
+func TestTranslateQueryParamMatch(t *testing.T) {
+	queryParams := map[string]*networking.StringMatch{
+		"exact":  {MatchType: &networking.StringMatch_Exact{Exact: "exact"}},
+		"prefix": {MatchType: &networking.StringMatch_Prefix{Prefix: "prefix"}},
+		"regex":  {MatchType: &networking.StringMatch_Regex{Regex: "regex"}},
+	}
+	expected := []*route.QueryParameterMatcher{
+		{
+			Name: "exact",
+			QueryParameterMatchSpecifier: &route.QueryParameterMatcher_StringMatch{
+				StringMatch: &matcher.StringMatcher{MatchPattern: &matcher.StringMatcher_Exact{Exact: "exact"}},
+			},
+		},
+		{
+			Name: "prefix",
+			QueryParameterMatchSpecifier: &route.QueryParameterMatcher_StringMatch{
+				StringMatch: &matcher.StringMatcher{MatchPattern: &matcher.StringMatcher_Prefix{Prefix: "prefix"}},
+			},
+		},
+		{
+			Name: "regex",
+			QueryParameterMatchSpecifier: &route.QueryParameterMatcher_StringMatch{
+				StringMatch: &matcher.StringMatcher{
+					MatchPattern: &matcher.StringMatcher_SafeRegex{
+						SafeRegex: &matcher.RegexMatcher{
+							EngineType: regexEngine,
+							Regex:      "regex",
+						},
+					},
+				},
+			},
+		},
+	}
+	got := translateRouteMatch(&networking.HTTPMatchRequest{QueryParams: queryParams})
+	if !reflect.DeepEqual(got.QueryParameters, expected) {
+		t.Errorf("translateRouteMatch() query parameters = \n%v, want \n%v", got.QueryParameters, expected)
+	}
+	if isCatchAllRoute(&route.Route{Match: got}) {
+		t.Errorf("route with query parameter matches must not be a catch all route")
+	}
+}
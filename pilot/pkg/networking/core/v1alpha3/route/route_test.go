@@ -23,6 +23,7 @@ import (
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	envoyroute "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	xdstype "github.com/envoyproxy/go-control-plane/envoy/type/v3"
 	"github.com/gogo/protobuf/types"
 	"github.com/golang/protobuf/ptypes/wrappers"
 	"github.com/onsi/gomega"
@@ -631,6 +632,72 @@ func TestBuildHTTPRoutes(t *testing.T) {
 		g.Expect(routes[0].ResponseHeadersToAdd[0].Header.Value).To(gomega.Equal("max-age=31536000; includeSubDomains; preload"))
 	})
 
+	t.Run("for virtual service with mirror condition", func(t *testing.T) {
+		g := gomega.NewWithT(t)
+
+		routes, err := route.BuildHTTPRoutesForVirtualService(node, nil, virtualServiceWithMirrorCondition(`{"x-shadow":{"exact":"true"}}`),
+			serviceRegistry, 8080, gatewayNames)
+		xdstest.ValidateRoutes(t, routes)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		g.Expect(len(routes)).To(gomega.Equal(2))
+
+		// The first route only matches requests with the condition headers and mirrors them.
+		headers := routes[0].GetMatch().GetHeaders()
+		g.Expect(len(headers)).To(gomega.Equal(2))
+		g.Expect(headers[0].GetName()).To(gomega.Equal("x-user"))
+		g.Expect(headers[1].GetName()).To(gomega.Equal("x-shadow"))
+		g.Expect(headers[1].GetExactMatch()).To(gomega.Equal("true"))
+		mirrors := routes[0].GetRoute().GetRequestMirrorPolicies()
+		g.Expect(len(mirrors)).To(gomega.Equal(1))
+		g.Expect(mirrors[0].GetCluster()).To(gomega.Equal("outbound|8080||mirror.example.org"))
+		g.Expect(mirrors[0].GetRuntimeFraction().GetDefaultValue().GetNumerator()).To(gomega.Equal(uint32(500000)))
+
+		// The second route matches the remaining requests without mirroring them.
+		headers = routes[1].GetMatch().GetHeaders()
+		g.Expect(len(headers)).To(gomega.Equal(1))
+		g.Expect(headers[0].GetName()).To(gomega.Equal("x-user"))
+		g.Expect(routes[1].GetRoute().GetRequestMirrorPolicies()).To(gomega.BeEmpty())
+		g.Expect(routes[1].GetRoute().GetCluster()).To(gomega.Equal(routes[0].GetRoute().GetCluster()))
+	})
+
+	t.Run("for virtual service with invalid mirror condition", func(t *testing.T) {
+		g := gomega.NewWithT(t)
+
+		routes, err := route.BuildHTTPRoutesForVirtualService(node, nil, virtualServiceWithMirrorCondition(`{"x-shadow":"true"}`),
+			serviceRegistry, 8080, gatewayNames)
+		xdstest.ValidateRoutes(t, routes)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		g.Expect(len(routes)).To(gomega.Equal(1))
+		g.Expect(len(routes[0].GetRoute().GetRequestMirrorPolicies())).To(gomega.Equal(1))
+	})
+
+	t.Run("for virtual service with cors filter enabled percentage", func(t *testing.T) {
+		g := gomega.NewWithT(t)
+
+		routes, err := route.BuildHTTPRoutesForVirtualService(node, nil, virtualServiceWithCORSFilterEnabled("0.5"),
+			serviceRegistry, 8080, gatewayNames)
+		xdstest.ValidateRoutes(t, routes)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		g.Expect(len(routes)).To(gomega.Equal(1))
+		enabled := routes[0].GetRoute().GetCors().GetFilterEnabled().GetDefaultValue()
+		g.Expect(enabled.GetNumerator()).To(gomega.Equal(uint32(5000)))
+		g.Expect(enabled.GetDenominator()).To(gomega.Equal(xdstype.FractionalPercent_MILLION))
+	})
+
+	t.Run("for virtual service with invalid cors filter enabled percentage", func(t *testing.T) {
+		g := gomega.NewWithT(t)
+
+		routes, err := route.BuildHTTPRoutesForVirtualService(node, nil, virtualServiceWithCORSFilterEnabled("150"),
+			serviceRegistry, 8080, gatewayNames)
+		xdstest.ValidateRoutes(t, routes)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		g.Expect(len(routes)).To(gomega.Equal(1))
+		// Invalid percentages are ignored, so the CORS filter is enabled for all requests.
+		enabled := routes[0].GetRoute().GetCors().GetFilterEnabled().GetDefaultValue()
+		g.Expect(enabled.GetNumerator()).To(gomega.Equal(uint32(100)))
+		g.Expect(enabled.GetDenominator()).To(gomega.Equal(xdstype.FractionalPercent_HUNDRED))
+	})
+
 	t.Run("for no virtualservice but has destinationrule with consistentHash loadbalancer", func(t *testing.T) {
 		g := gomega.NewWithT(t)
 		meshConfig := mesh.DefaultMeshConfig()
@@ -758,6 +825,78 @@ var virtualServicePlain = config.Config{
 	},
 }
 
+func virtualServiceWithMirrorCondition(condition string) config.Config {
+	return config.Config{
+		Meta: config.Meta{
+			GroupVersionKind: collections.IstioNetworkingV1Alpha3Virtualservices.Resource().GroupVersionKind(),
+			Name:             "acme",
+			Annotations:      map[string]string{route.MirrorConditionAnnotation: condition},
+		},
+		Spec: &networking.VirtualService{
+			Hosts:    []string{},
+			Gateways: []string{"some-gateway"},
+			Http: []*networking.HTTPRoute{
+				{
+					Match: []*networking.HTTPMatchRequest{
+						{
+							Headers: map[string]*networking.StringMatch{
+								"x-user": {MatchType: &networking.StringMatch_Exact{Exact: "test"}},
+							},
+						},
+					},
+					Route: []*networking.HTTPRouteDestination{
+						{
+							Destination: &networking.Destination{
+								Host: "*.example.org",
+								Port: &networking.PortSelector{
+									Number: 8484,
+								},
+							},
+						},
+					},
+					Mirror: &networking.Destination{
+						Host: "mirror.example.org",
+					},
+					MirrorPercentage: &networking.Percent{Value: 50},
+				},
+			},
+		},
+	}
+}
+
+func virtualServiceWithCORSFilterEnabled(percent string) config.Config {
+	return config.Config{
+		Meta: config.Meta{
+			GroupVersionKind: collections.IstioNetworkingV1Alpha3Virtualservices.Resource().GroupVersionKind(),
+			Name:             "acme",
+			Annotations:      map[string]string{route.CORSFilterEnabledAnnotation: percent},
+		},
+		Spec: &networking.VirtualService{
+			Hosts:    []string{},
+			Gateways: []string{"some-gateway"},
+			Http: []*networking.HTTPRoute{
+				{
+					Route: []*networking.HTTPRouteDestination{
+						{
+							Destination: &networking.Destination{
+								Host: "*.example.org",
+								Port: &networking.PortSelector{
+									Number: 8484,
+								},
+							},
+						},
+					},
+					CorsPolicy: &networking.CorsPolicy{
+						AllowOrigins: []*networking.StringMatch{
+							{MatchType: &networking.StringMatch_Exact{Exact: "https://example.org"}},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
 var virtualServiceWithTimeout = config.Config{
 	Meta: config.Meta{
 		GroupVersionKind: collections.IstioNetworkingV1Alpha3Virtualservices.Resource().GroupVersionKind(),
@@ -15,19 +15,38 @@
 package v1alpha3
 
 import (
+	"fmt"
 	"reflect"
 	"sort"
 	"strings"
 
 	"istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/features"
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pilot/pkg/networking/util"
+	"istio.io/istio/pilot/pkg/util/sets"
 	"istio.io/istio/pkg/config"
+	"istio.io/istio/pkg/config/constants"
 	"istio.io/istio/pkg/config/host"
 	"istio.io/istio/pkg/config/labels"
 	"istio.io/pkg/log"
 )
 
+// TLSMatchALPNAnnotation can be set on a VirtualService to additionally match its TLS routes on the ALPN
+// protocols offered by the client. The value is a comma separated list of protocols, e.g. "h2,http/1.1".
+// Clients offering any of the protocols match. Distinct protocol sets produce distinct filter chains.
+const TLSMatchALPNAnnotation = "networking.istio.io/tlsMatchALPN"
+
+// DefaultSubsetAnnotation can be set on a DestinationRule to name the subset that TCP and TLS traffic to the
+// host is routed to when no VirtualService route applies. Unknown subsets are ignored.
+const DefaultSubsetAnnotation = "networking.istio.io/defaultSubset"
+
+// DisableAutoSniAnnotation can be set to "true" on a DestinationRule to prevent the default TLS filter chain of
+// the host from matching on an SNI derived from the service, i.e. its hostname or the SNI of the TLS settings.
+// The filter chain then only matches on the destination CIDR. This is meant for services fronted with SNIs
+// the mesh does not know about.
+const DisableAutoSniAnnotation = "networking.istio.io/disableAutoSni"
+
 // Match by source labels, the listener port where traffic comes in, the gateway on which the rule is being
 // bound, etc. All these can be checked statically, since we are generating the configuration for a proxy
 // with predefined labels, on a specific port.
@@ -36,10 +55,7 @@ func matchTLS(match *v1alpha3.TLSMatchAttributes, proxyLabels labels.Collection,
 		return true
 	}
 
-	gatewayMatch := len(match.Gateways) == 0
-	for _, gateway := range match.Gateways {
-		gatewayMatch = gatewayMatch || gateways[gateway]
-	}
+	gatewayMatch := matchGateways(match.Gateways, gateways)
 
 	labelMatch := proxyLabels.IsSupersetOf(match.SourceLabels)
 
@@ -58,10 +74,7 @@ func matchTCP(match *v1alpha3.L4MatchAttributes, proxyLabels labels.Collection,
 		return true
 	}
 
-	gatewayMatch := len(match.Gateways) == 0
-	for _, gateway := range match.Gateways {
-		gatewayMatch = gatewayMatch || gateways[gateway]
-	}
+	gatewayMatch := matchGateways(match.Gateways, gateways)
 
 	labelMatch := proxyLabels.IsSupersetOf(match.SourceLabels)
 
@@ -72,24 +85,166 @@ func matchTCP(match *v1alpha3.L4MatchAttributes, proxyLabels labels.Collection,
 	return gatewayMatch && labelMatch && portMatch && nsMatch
 }
 
-// Select the config pertaining to the service being processed.
+// matchGateways returns true if the match applies to a proxy bound to the given gateways. A match without
+// gateways applies to all proxies the VirtualService is bound to. As for HTTP routes, the reserved mesh
+// gateway (constants.IstioMeshGateway) selects sidecars only, and named gateways select gateway proxies
+// only, so that matches scoped to gateways do not leak into sidecar filter chains and vice versa.
+func matchGateways(matchGateways []string, gateways map[string]bool) bool {
+	if len(matchGateways) == 0 {
+		return true
+	}
+	sidecar := gateways[constants.IstioMeshGateway]
+	for _, gateway := range matchGateways {
+		if gateway == constants.IstioMeshGateway {
+			if sidecar {
+				return true
+			}
+			continue
+		}
+		if !sidecar && gateways[gateway] {
+			return true
+		}
+	}
+	return false
+}
+
+// Select the config pertaining to the service being processed. Configs are ordered by the specificity of
+// their most specific host matching the service, so that e.g. a VirtualService for a.foo.com takes
+// precedence over one for *.foo.com, which in turn takes precedence over one for *.
 func getConfigsForHost(hostname host.Name, configs []config.Config) []config.Config {
 	svcConfigs := make([]config.Config, 0)
+	matchedHosts := make(host.Names, 0)
 	for index := range configs {
 		virtualService := configs[index].Spec.(*v1alpha3.VirtualService)
+		var matchedHost host.Name
 		for _, vsHost := range virtualService.Hosts {
-			if host.Name(vsHost).Matches(hostname) {
-				svcConfigs = append(svcConfigs, configs[index])
-				break
+			h := host.Name(vsHost)
+			if h.Matches(hostname) && (matchedHost == "" || host.MoreSpecific(h, matchedHost)) {
+				matchedHost = h
 			}
 		}
+		if matchedHost != "" {
+			svcConfigs = append(svcConfigs, configs[index])
+			matchedHosts = append(matchedHosts, matchedHost)
+		}
 	}
+	sort.Stable(configsByHost{configs: svcConfigs, hosts: matchedHosts})
 	return svcConfigs
 }
 
-// hashRuntimeTLSMatchPredicates hashes runtime predicates of a TLS match
-func hashRuntimeTLSMatchPredicates(match *v1alpha3.TLSMatchAttributes) string {
-	return strings.Join(match.SniHosts, ",") + "|" + strings.Join(match.DestinationSubnets, ",")
+// configsByHost sorts configs by their matched host, most specific first.
+type configsByHost struct {
+	configs []config.Config
+	hosts   host.Names
+}
+
+func (c configsByHost) Len() int {
+	return len(c.configs)
+}
+
+func (c configsByHost) Less(i, j int) bool {
+	return host.MoreSpecific(c.hosts[i], c.hosts[j])
+}
+
+func (c configsByHost) Swap(i, j int) {
+	c.configs[i], c.configs[j] = c.configs[j], c.configs[i]
+	c.hosts.Swap(i, j)
+}
+
+// tlsMatchSniHosts returns the sorted, deduplicated SNI hosts of a TLS match, so equivalent matches produce
+// identical filter chains.
+func tlsMatchSniHosts(match *v1alpha3.TLSMatchAttributes) []string {
+	return sets.NewSet(match.SniHosts...).SortedList()
+}
+
+// hashRuntimeTLSMatchPredicates hashes runtime predicates of a TLS match. The hash does not depend on the
+// order of the SNI hosts, destination subnets or ALPN protocols.
+func hashRuntimeTLSMatchPredicates(match *v1alpha3.TLSMatchAttributes, alpns []string) string {
+	return strings.Join(tlsMatchSniHosts(match), ",") + "|" +
+		strings.Join(sets.NewSet(match.DestinationSubnets...).SortedList(), ",") + "|" +
+		strings.Join(sets.NewSet(alpns...).SortedList(), ",")
+}
+
+// tlsMatchALPNs returns the sorted ALPN protocols requested by the TLSMatchALPNAnnotation of a VirtualService.
+func tlsMatchALPNs(configMeta config.Meta) []string {
+	value, f := configMeta.Annotations[TLSMatchALPNAnnotation]
+	if !f {
+		return nil
+	}
+	alpns := sets.NewSet()
+	for _, p := range strings.Split(value, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			alpns.Insert(p)
+		}
+	}
+	if alpns.Empty() {
+		return nil
+	}
+	return alpns.SortedList()
+}
+
+// tlsSniOverride returns the SNI configured in the TLS settings of the destination rule for the given port,
+// or an empty string if there is none. Port level TLS settings take precedence over the top level ones.
+func tlsSniOverride(destinationRule *v1alpha3.DestinationRule, port *model.Port) string {
+	if destinationRule == nil {
+		return ""
+	}
+	return MergeTrafficPolicy(nil, destinationRule.TrafficPolicy, port).GetTls().GetSni()
+}
+
+// outboundServicePort returns the service port of the cluster that the default TCP/TLS filter chain for the
+// service routes to, and whether it is a port of the service.
+//
+// In case of a sidecar config with user defined port, if the user specified port is not the same as the
+// service's port, then pick the service port if and only if the service has only one port. If service
+// has multiple ports, then route to a cluster with the listener port (i.e. sidecar defined port) - the
+// traffic will most likely blackhole unless the listener port is a port of the service. The same applies
+// to a malformed service without ports, which is additionally reported with the ServiceNoPorts metric.
+func outboundServicePort(node *model.Proxy, push *model.PushContext, service *model.Service, listenPort *model.Port) (int, bool) {
+	switch len(service.Ports) {
+	case 0:
+		push.AddMetric(model.ServiceNoPorts, string(service.Hostname), node.ID,
+			fmt.Sprintf("service %s/%s has no ports, using listener port %d", service.Attributes.Namespace, service.Hostname, listenPort.Port))
+		return listenPort.Port, false
+	case 1:
+		return service.Ports[0].Port, true
+	}
+	_, found := service.Ports.GetByPort(listenPort.Port)
+	return listenPort.Port, found
+}
+
+// outboundFallbackCluster returns the cluster that TCP traffic is routed to by default when the service has
+// no cluster for the listener port: the PassthroughCluster if the outbound traffic policy of the proxy is
+// ALLOW_ANY, otherwise the BlackHoleCluster, so connections fail fast.
+func outboundFallbackCluster(node *model.Proxy) string {
+	if util.IsAllowAnyOutbound(node) {
+		return util.PassthroughCluster
+	}
+	return util.BlackHoleCluster
+}
+
+// defaultSubset returns the subset named by the DefaultSubsetAnnotation of the destination rule, if the
+// destination rule defines it. Otherwise it returns an empty string, i.e. the whole service.
+func defaultSubset(destinationRule *config.Config) string {
+	if destinationRule == nil {
+		return ""
+	}
+	name := destinationRule.Annotations[DefaultSubsetAnnotation]
+	if name == "" {
+		return ""
+	}
+	for _, subset := range destinationRule.Spec.(*v1alpha3.DestinationRule).Subsets {
+		if subset.Name == name {
+			return name
+		}
+	}
+	log.Debugf("default subset %s not found in destination rule %s/%s", name, destinationRule.Namespace, destinationRule.Name)
+	return ""
+}
+
+// autoSniDisabled returns true if the DisableAutoSniAnnotation is set on the destination rule.
+func autoSniDisabled(destinationRule *config.Config) bool {
+	return destinationRule != nil && destinationRule.Annotations[DisableAutoSniAnnotation] == "true"
 }
 
 func buildSidecarOutboundTLSFilterChainOpts(node *model.Proxy, push *model.PushContext, destinationCIDR string,
@@ -126,6 +281,7 @@ func buildSidecarOutboundTLSFilterChainOpts(node *model.Proxy, push *model.PushC
 	out := make([]*filterChainOpts, 0)
 	for _, cfg := range configs {
 		virtualService := cfg.Spec.(*v1alpha3.VirtualService)
+		alpns := tlsMatchALPNs(cfg.Meta)
 		for _, tls := range virtualService.Tls {
 			for _, match := range tls.Match {
 				if matchTLS(match, labels.Collection{node.Metadata.Labels}, gateways, listenPort.Port, node.Metadata.Namespace) {
@@ -140,13 +296,14 @@ func buildSidecarOutboundTLSFilterChainOpts(node *model.Proxy, push *model.PushC
 					if len(match.DestinationSubnets) > 0 && listenPort.Port > 0 {
 						destinationCIDRs = match.DestinationSubnets
 					}
-					matchHash := hashRuntimeTLSMatchPredicates(match)
+					matchHash := hashRuntimeTLSMatchPredicates(match, alpns)
 					if !matchHasBeenHandled[matchHash] {
 						out = append(out, &filterChainOpts{
-							metadata:         util.BuildConfigInfoMetadata(cfg.Meta),
-							sniHosts:         match.SniHosts,
-							destinationCIDRs: destinationCIDRs,
-							networkFilters:   buildOutboundNetworkFilters(node, tls.Route, push, listenPort, cfg.Meta),
+							metadata:             util.BuildConfigInfoMetadata(cfg.Meta),
+							sniHosts:             tlsMatchSniHosts(match),
+							destinationCIDRs:     destinationCIDRs,
+							applicationProtocols: alpns,
+							networkFilters:       buildOutboundNetworkFilters(node, tls.Route, push, listenPort, cfg.Meta),
 						})
 						hasTLSMatch = true
 					}
@@ -156,24 +313,24 @@ func buildSidecarOutboundTLSFilterChainOpts(node *model.Proxy, push *model.PushC
 		}
 	}
 
-	// HTTPS or TLS ports without associated virtual service
-	if !hasTLSMatch {
+	// HTTPS or TLS ports without associated virtual service. Egress listeners with a port defined in the
+	// sidecar config have no service, so there is no cluster to route to by default.
+	// The filter chain only passes the TLS stream through, it neither terminates nor originates TLS. Peer
+	// verification, including the subjectAltNames of the destination rule, is configured on the upstream
+	// TLS context of the cluster, see buildUpstreamClusterTLSContext.
+	if !hasTLSMatch && service != nil {
 		var sniHosts []string
 
-		// In case of a sidecar config with user defined port, if the user specified port is not the same as the
-		// service's port, then pick the service port if and only if the service has only one port. If service
-		// has multiple ports, then route to a cluster with the listener port (i.e. sidecar defined port) - the
-		// traffic will most likely blackhole.
-		port := listenPort.Port
-		if len(service.Ports) == 1 {
-			port = service.Ports[0].Port
-		}
+		port, _ := outboundServicePort(node, push, service, listenPort)
+		destinationRuleConfig := push.DestinationRule(node, service)
+		destinationRule := CastDestinationRule(destinationRuleConfig)
+		subset := defaultSubset(destinationRuleConfig)
 
-		clusterName := model.BuildSubsetKey(model.TrafficDirectionOutbound, "", service.Hostname, port)
+		clusterName := model.BuildSubsetKey(model.TrafficDirectionOutbound, subset, service.Hostname, port)
 		statPrefix := clusterName
 		// If stat name is configured, use it to build the stat prefix.
 		if len(push.Mesh.OutboundClusterStatName) != 0 {
-			statPrefix = util.BuildStatPrefix(push.Mesh.OutboundClusterStatName, string(service.Hostname), "", &model.Port{Port: port}, service.Attributes)
+			statPrefix = util.BuildStatPrefix(push.Mesh.OutboundClusterStatName, string(service.Hostname), subset, &model.Port{Port: port}, service.Attributes)
 		}
 		// Use the hostname as the SNI value if and only:
 		// 1) if the destination is a CIDR;
@@ -192,14 +349,24 @@ func buildSidecarOutboundTLSFilterChainOpts(node *model.Proxy, push *model.PushC
 			svcListenAddress = ""
 		}
 
-		if len(destinationCIDR) > 0 || len(svcListenAddress) == 0 || (svcListenAddress == actualWildcard && bind == actualWildcard) {
-			sniHosts = []string{string(service.Hostname)}
+		if autoSniDisabled(destinationRuleConfig) {
+			log.Debugf("automatic SNI match disabled for %s by destination rule %s/%s", service.Hostname,
+				destinationRuleConfig.Namespace, destinationRuleConfig.Name)
+		} else if len(destinationCIDR) > 0 || len(svcListenAddress) == 0 || (svcListenAddress == actualWildcard && bind == actualWildcard) {
+			// The SNI of the destination rule TLS settings, if set, takes precedence over the service hostname.
+			// This allows fronting a service with a different SNI, e.g. the name of a shared certificate.
+			// It is only used where SNI matching is needed at all, so listeners bound to a VIP are unaffected.
+			if sni := tlsSniOverride(destinationRule, &model.Port{Port: port}); sni != "" {
+				sniHosts = []string{sni}
+			} else {
+				sniHosts = []string{string(service.Hostname)}
+			}
 		}
 
 		out = append(out, &filterChainOpts{
 			sniHosts:         sniHosts,
 			destinationCIDRs: []string{destinationCIDR},
-			networkFilters:   buildOutboundNetworkFiltersWithSingleDestination(push, node, statPrefix, clusterName, listenPort),
+			networkFilters:   buildOutboundNetworkFiltersWithSingleDestination(push, node, statPrefix, clusterName, subset, listenPort, destinationRule, nil),
 		})
 	}
 
@@ -264,6 +431,10 @@ TcpLoop:
 			}
 
 			if len(virtualServiceDestinationSubnets) > 0 {
+				if features.MergeTCPDestinationSubnets {
+					virtualServiceDestinationSubnets = util.MergeCidrs(virtualServiceDestinationSubnets)
+					destinationCIDRs = util.MergeCidrs(destinationCIDRs)
+				}
 				out = append(out, &filterChainOpts{
 					destinationCIDRs: virtualServiceDestinationSubnets,
 					networkFilters:   buildOutboundNetworkFilters(node, tcp.Route, push, listenPort, cfg.Meta),
@@ -283,26 +454,32 @@ TcpLoop:
 		}
 	}
 
-	if !defaultRouteAdded {
-		// In case of a sidecar config with user defined port, if the user specified port is not the same as the
-		// service's port, then pick the service port if and only if the service has only one port. If service
-		// has multiple ports, then route to a cluster with the listener port (i.e. sidecar defined port) - the
-		// traffic will most likely blackhole.
-		port := listenPort.Port
-		if len(service.Ports) == 1 {
-			port = service.Ports[0].Port
-		}
-
-		clusterName := model.BuildSubsetKey(model.TrafficDirectionOutbound, "", service.Hostname, port)
-		statPrefix := clusterName
-		// If stat name is configured, use it to build the stat prefix.
-		if len(push.Mesh.OutboundClusterStatName) != 0 {
-			statPrefix = util.BuildStatPrefix(push.Mesh.OutboundClusterStatName, string(service.Hostname), "", &model.Port{Port: port}, service.Attributes)
+	// Egress listeners with a port defined in the sidecar config have no service, so there is no cluster to
+	// route to by default.
+	if !defaultRouteAdded && service != nil {
+		port, found := outboundServicePort(node, push, service, listenPort)
+		if found {
+			destinationRuleConfig := push.DestinationRule(node, service)
+			destinationRule := CastDestinationRule(destinationRuleConfig)
+			subset := defaultSubset(destinationRuleConfig)
+			clusterName := model.BuildSubsetKey(model.TrafficDirectionOutbound, subset, service.Hostname, port)
+			statPrefix := clusterName
+			// If stat name is configured, use it to build the stat prefix.
+			if len(push.Mesh.OutboundClusterStatName) != 0 {
+				statPrefix = util.BuildStatPrefix(push.Mesh.OutboundClusterStatName, string(service.Hostname), subset, &model.Port{Port: port}, service.Attributes)
+			}
+			out = append(out, &filterChainOpts{
+				destinationCIDRs: []string{destinationCIDR},
+				networkFilters:   buildOutboundNetworkFiltersWithSingleDestination(push, node, statPrefix, clusterName, subset, listenPort, destinationRule, nil),
+			})
+		} else {
+			// The service has no cluster for the listener port, route explicitly as per the outbound traffic policy.
+			clusterName := outboundFallbackCluster(node)
+			out = append(out, &filterChainOpts{
+				destinationCIDRs: []string{destinationCIDR},
+				networkFilters:   buildOutboundNetworkFiltersWithSingleDestination(push, node, clusterName, clusterName, "", listenPort, nil, nil),
+			})
 		}
-		out = append(out, &filterChainOpts{
-			destinationCIDRs: []string{destinationCIDR},
-			networkFilters:   buildOutboundNetworkFiltersWithSingleDestination(push, node, statPrefix, clusterName, listenPort),
-		})
 	}
 
 	return out
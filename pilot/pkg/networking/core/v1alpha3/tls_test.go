@@ -15,10 +15,23 @@
 package v1alpha3
 
 import (
+	"fmt"
+	"reflect"
 	"testing"
 
+	tcp "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+
 	"istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/features"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/networking/util"
+	"istio.io/istio/pilot/test/xdstest"
+	"istio.io/istio/pkg/config"
+	"istio.io/istio/pkg/config/constants"
 	"istio.io/istio/pkg/config/labels"
+	"istio.io/istio/pkg/config/protocol"
+	"istio.io/istio/pkg/config/schema/gvk"
 )
 
 func TestMatchTLS(t *testing.T) {
@@ -72,6 +85,54 @@ func TestMatchTLS(t *testing.T) {
 			},
 			true,
 		},
+		{
+			"mesh gateway matches sidecar",
+			args{
+				match:    &v1alpha3.TLSMatchAttributes{Gateways: []string{constants.IstioMeshGateway}},
+				gateways: map[string]bool{constants.IstioMeshGateway: true},
+			},
+			true,
+		},
+		{
+			"gateway bound match does not apply to sidecar",
+			args{
+				match:    &v1alpha3.TLSMatchAttributes{Gateways: []string{"istio-system/gateway"}},
+				gateways: map[string]bool{constants.IstioMeshGateway: true},
+			},
+			false,
+		},
+		{
+			"gateway bound match does not apply to sidecar bound to the same name",
+			args{
+				match:    &v1alpha3.TLSMatchAttributes{Gateways: []string{"istio-system/gateway"}},
+				gateways: map[string]bool{constants.IstioMeshGateway: true, "istio-system/gateway": true},
+			},
+			false,
+		},
+		{
+			"mesh bound match does not apply to gateway without mesh binding",
+			args{
+				match:    &v1alpha3.TLSMatchAttributes{Gateways: []string{constants.IstioMeshGateway}},
+				gateways: map[string]bool{constants.IstioMeshGateway: false, "istio-system/gateway": true},
+			},
+			false,
+		},
+		{
+			"mesh bound match does not apply to gateway",
+			args{
+				match:    &v1alpha3.TLSMatchAttributes{Gateways: []string{constants.IstioMeshGateway}},
+				gateways: map[string]bool{"istio-system/gateway": true},
+			},
+			false,
+		},
+		{
+			"match bound to mesh and gateway applies to gateway",
+			args{
+				match:    &v1alpha3.TLSMatchAttributes{Gateways: []string{constants.IstioMeshGateway, "istio-system/gateway"}},
+				gateways: map[string]bool{"istio-system/gateway": true},
+			},
+			true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -133,6 +194,54 @@ func TestMatchTCP(t *testing.T) {
 			},
 			true,
 		},
+		{
+			"mesh gateway matches sidecar",
+			args{
+				match:    &v1alpha3.L4MatchAttributes{Gateways: []string{constants.IstioMeshGateway}},
+				gateways: map[string]bool{constants.IstioMeshGateway: true},
+			},
+			true,
+		},
+		{
+			"gateway bound match does not apply to sidecar",
+			args{
+				match:    &v1alpha3.L4MatchAttributes{Gateways: []string{"istio-system/gateway"}},
+				gateways: map[string]bool{constants.IstioMeshGateway: true},
+			},
+			false,
+		},
+		{
+			"gateway bound match does not apply to sidecar bound to the same name",
+			args{
+				match:    &v1alpha3.L4MatchAttributes{Gateways: []string{"istio-system/gateway"}},
+				gateways: map[string]bool{constants.IstioMeshGateway: true, "istio-system/gateway": true},
+			},
+			false,
+		},
+		{
+			"mesh bound match does not apply to gateway without mesh binding",
+			args{
+				match:    &v1alpha3.L4MatchAttributes{Gateways: []string{constants.IstioMeshGateway}},
+				gateways: map[string]bool{constants.IstioMeshGateway: false, "istio-system/gateway": true},
+			},
+			false,
+		},
+		{
+			"mesh bound match does not apply to gateway",
+			args{
+				match:    &v1alpha3.L4MatchAttributes{Gateways: []string{constants.IstioMeshGateway}},
+				gateways: map[string]bool{"istio-system/gateway": true},
+			},
+			false,
+		},
+		{
+			"match bound to mesh and gateway applies to gateway",
+			args{
+				match:    &v1alpha3.L4MatchAttributes{Gateways: []string{constants.IstioMeshGateway, "istio-system/gateway"}},
+				gateways: map[string]bool{"istio-system/gateway": true},
+			},
+			true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -142,3 +251,471 @@ func TestMatchTCP(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildSidecarOutboundTCPFilterChainOptsMergeSubnets(t *testing.T) {
+	service := buildService("test.com", "10.10.0.0/24", protocol.TCP, tnow)
+	env := buildListenerEnv([]*model.Service{service})
+	env.PushContext.InitContext(env, nil, nil)
+	vs := config.Config{
+		Meta: config.Meta{GroupVersionKind: gvk.VirtualService, Name: "vs", Namespace: "default"},
+		Spec: &v1alpha3.VirtualService{
+			Hosts: []string{"test.com"},
+			Tcp: []*v1alpha3.TCPRoute{{
+				Match: []*v1alpha3.L4MatchAttributes{
+					{DestinationSubnets: []string{"10.10.0.128/25"}},
+					{DestinationSubnets: []string{"10.10.0.0/25"}},
+				},
+				Route: []*v1alpha3.RouteDestination{{Destination: &v1alpha3.Destination{Host: "test.com"}}},
+			}},
+		},
+	}
+
+	defaultValue := features.MergeTCPDestinationSubnets
+	defer func() { features.MergeTCPDestinationSubnets = defaultValue }()
+	cases := []struct {
+		merge bool
+		want  [][]string
+	}{
+		// The subnets are used verbatim, followed by the default route of the service.
+		{false, [][]string{{"10.10.0.0/25", "10.10.0.128/25"}, {"10.10.0.0/24"}}},
+		// The merged subnets are the ones of the service, so no default route is added.
+		{true, [][]string{{"10.10.0.0/24"}}},
+	}
+	for _, tt := range cases {
+		features.MergeTCPDestinationSubnets = tt.merge
+		opts := buildSidecarOutboundTCPFilterChainOpts(getProxy(), env.PushContext, "10.10.0.0/24", service,
+			service.Ports[0], nil, []config.Config{vs})
+		got := make([][]string, 0, len(opts))
+		for _, opt := range opts {
+			got = append(got, opt.destinationCIDRs)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("merge %v: got destination CIDRs %v, want %v", tt.merge, got, tt.want)
+		}
+	}
+}
+
+func TestBuildSidecarOutboundTLSFilterChainOptsSniHostsOrder(t *testing.T) {
+	service := buildService("test.com", "10.10.0.0/24", protocol.TLS, tnow)
+	env := buildListenerEnv([]*model.Service{service})
+	env.PushContext.InitContext(env, nil, nil)
+	proxy := getProxy()
+
+	virtualService := func(sniHosts ...[]string) config.Config {
+		vs := &v1alpha3.VirtualService{
+			Hosts: []string{"test.com"},
+			Tls: []*v1alpha3.TLSRoute{{
+				Route: []*v1alpha3.RouteDestination{{Destination: &v1alpha3.Destination{Host: "test.com"}}},
+			}},
+		}
+		for _, hosts := range sniHosts {
+			vs.Tls[0].Match = append(vs.Tls[0].Match, &v1alpha3.TLSMatchAttributes{SniHosts: hosts})
+		}
+		return config.Config{
+			Meta: config.Meta{GroupVersionKind: gvk.VirtualService, Name: "vs", Namespace: "default"},
+			Spec: vs,
+		}
+	}
+	sniHosts := func(configs ...config.Config) [][]string {
+		opts := buildSidecarOutboundTLSFilterChainOpts(proxy, env.PushContext, "", service, "0.0.0.0",
+			service.Ports[0], nil, configs)
+		out := make([][]string, 0, len(opts))
+		for _, o := range opts {
+			out = append(out, o.sniHosts)
+		}
+		return out
+	}
+
+	expected := [][]string{{"a.com", "b.com"}}
+	for _, cfg := range []config.Config{
+		virtualService([]string{"a.com", "b.com"}),
+		virtualService([]string{"b.com", "a.com"}),
+		virtualService([]string{"b.com", "a.com", "b.com"}),
+		virtualService([]string{"b.com", "a.com"}, []string{"a.com", "b.com"}),
+	} {
+		if got := sniHosts(cfg); !reflect.DeepEqual(got, expected) {
+			t.Errorf("unexpected SNI hosts for %v: got %v, want %v", cfg.Spec, got, expected)
+		}
+	}
+}
+
+func TestHashRuntimeTLSMatchPredicates(t *testing.T) {
+	a := hashRuntimeTLSMatchPredicates(&v1alpha3.TLSMatchAttributes{
+		SniHosts:           []string{"a.com", "b.com"},
+		DestinationSubnets: []string{"10.0.0.0/8", "192.168.0.0/16"},
+	}, []string{"h2", "http/1.1"})
+	b := hashRuntimeTLSMatchPredicates(&v1alpha3.TLSMatchAttributes{
+		SniHosts:           []string{"b.com", "a.com", "b.com"},
+		DestinationSubnets: []string{"192.168.0.0/16", "10.0.0.0/8"},
+	}, []string{"http/1.1", "h2"})
+	if a != b {
+		t.Fatalf("expected reordered matches to hash the same, got %q and %q", a, b)
+	}
+	if c := hashRuntimeTLSMatchPredicates(&v1alpha3.TLSMatchAttributes{SniHosts: []string{"a.com"}}, nil); c == a {
+		t.Fatalf("expected different SNI hosts to hash differently")
+	}
+}
+
+func TestBuildSidecarOutboundTLSFilterChainOptsWeighted(t *testing.T) {
+	service := buildService("test.com", "10.10.0.0/24", protocol.TLS, tnow)
+	other := buildService("other.com", "10.20.0.0/24", protocol.TLS, tnow)
+	env := buildListenerEnv([]*model.Service{service, other})
+	env.PushContext.InitContext(env, nil, nil)
+	proxy := getProxy()
+	proxy.SidecarScope = model.DefaultSidecarScopeForNamespace(env.PushContext, "not-default")
+
+	vs := config.Config{
+		Meta: config.Meta{GroupVersionKind: gvk.VirtualService, Name: "vs", Namespace: "default"},
+		Spec: &v1alpha3.VirtualService{
+			Hosts: []string{"test.com"},
+			Tls: []*v1alpha3.TLSRoute{{
+				Match: []*v1alpha3.TLSMatchAttributes{{SniHosts: []string{"test.com"}}},
+				Route: []*v1alpha3.RouteDestination{
+					{Destination: &v1alpha3.Destination{Host: "test.com"}, Weight: 80},
+					{Destination: &v1alpha3.Destination{Host: "other.com"}, Weight: 20},
+				},
+			}},
+		},
+	}
+	opts := buildSidecarOutboundTLSFilterChainOpts(proxy, env.PushContext, "", service, "0.0.0.0",
+		service.Ports[0], nil, []config.Config{vs})
+	if len(opts) != 1 {
+		t.Fatalf("expected a single filter chain, got %d", len(opts))
+	}
+	filters := opts[0].networkFilters
+	tcpProxy := &tcp.TcpProxy{}
+	if err := filters[len(filters)-1].GetTypedConfig().UnmarshalTo(tcpProxy); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]uint32{}
+	for _, c := range tcpProxy.GetWeightedClusters().GetClusters() {
+		got[c.Name] = c.Weight
+	}
+	expected := map[string]uint32{"outbound|8080||test.com": 80, "outbound|8080||other.com": 20}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected weighted clusters: got %v, want %v", got, expected)
+	}
+}
+
+func TestBuildSidecarOutboundTLSFilterChainOptsALPN(t *testing.T) {
+	service := buildService("test.com", wildcardIP, protocol.TLS, tnow)
+	virtualService := func(name, alpn string, destination string) config.Config {
+		cfg := config.Config{
+			Meta: config.Meta{GroupVersionKind: gvk.VirtualService, Name: name, Namespace: "default"},
+			Spec: &v1alpha3.VirtualService{
+				Hosts: []string{"test.com"},
+				Tls: []*v1alpha3.TLSRoute{{
+					Match: []*v1alpha3.TLSMatchAttributes{{SniHosts: []string{"a.com"}}},
+					Route: []*v1alpha3.RouteDestination{{Destination: &v1alpha3.Destination{Host: destination}}},
+				}},
+			},
+		}
+		if alpn != "" {
+			cfg.Annotations = map[string]string{TLSMatchALPNAnnotation: alpn}
+		}
+		return cfg
+	}
+	configs := []config.Config{
+		virtualService("h2", "istio, h2", "h2.com"),
+		virtualService("h2-duplicate", "h2,istio", "other.com"),
+		virtualService("http11", "http/1.1", "http11.com"),
+		virtualService("any", "", "any.com"),
+	}
+
+	env := buildListenerEnv([]*model.Service{service})
+	env.PushContext.InitContext(env, nil, nil)
+	opts := buildSidecarOutboundTLSFilterChainOpts(getProxy(), env.PushContext, "", service, "0.0.0.0",
+		service.Ports[0], nil, configs)
+	got := make([][]string, 0, len(opts))
+	for _, o := range opts {
+		got = append(got, o.applicationProtocols)
+	}
+	// The second config has the same runtime predicates as the first one, so it is unreachable.
+	expected := [][]string{{"h2", "istio"}, {"http/1.1"}, nil}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected ALPN matches: got %v, want %v", got, expected)
+	}
+
+	ptrs := make([]*config.Config, 0, len(configs))
+	for i := range configs {
+		ptrs = append(ptrs, &configs[i])
+	}
+	cg := NewConfigGenTest(t, TestOptions{Services: []*model.Service{service}, ConfigPointers: ptrs})
+	listeners := cg.ConfigGen.buildSidecarOutboundListeners(cg.SetupProxy(getProxy()), cg.env.PushContext)
+	l := xdstest.ExtractListener("0.0.0.0_8080", listeners)
+	if l == nil {
+		t.Fatalf("expected listener 0.0.0.0_8080")
+	}
+	if _, f := xdstest.ExtractListenerFilters(l)[wellknown.TlsInspector]; !f {
+		t.Fatalf("expected a TLS inspector")
+	}
+	clusters := map[string][]string{}
+	for _, fc := range l.FilterChains {
+		if !reflect.DeepEqual(fc.FilterChainMatch.GetServerNames(), []string{"a.com"}) {
+			continue
+		}
+		tcpProxy := xdstest.ExtractTCPProxy(t, fc)
+		clusters[tcpProxy.GetCluster()] = fc.FilterChainMatch.ApplicationProtocols
+	}
+	expectedClusters := map[string][]string{
+		"outbound|8080||h2.com":     {"h2", "istio"},
+		"outbound|8080||http11.com": {"http/1.1"},
+		"outbound|8080||any.com":    nil,
+	}
+	if !reflect.DeepEqual(clusters, expectedClusters) {
+		t.Fatalf("unexpected filter chains: got %v, want %v", clusters, expectedClusters)
+	}
+}
+
+func TestBuildSidecarOutboundTLSFilterChainOptsSniOverride(t *testing.T) {
+	destinationRule := func(policy *v1alpha3.TrafficPolicy) config.Config {
+		return config.Config{
+			Meta: config.Meta{GroupVersionKind: gvk.DestinationRule, Name: "dr", Namespace: "default"},
+			Spec: &v1alpha3.DestinationRule{Host: "test.com", TrafficPolicy: policy},
+		}
+	}
+	tlsPolicy := func(sni string) *v1alpha3.TrafficPolicy {
+		return &v1alpha3.TrafficPolicy{Tls: &v1alpha3.ClientTLSSettings{Mode: v1alpha3.ClientTLSSettings_SIMPLE, Sni: sni}}
+	}
+	autoSniDisabled := destinationRule(tlsPolicy("shared.example.com"))
+	autoSniDisabled.Annotations = map[string]string{DisableAutoSniAnnotation: "true"}
+	portPolicy := tlsPolicy("top.example.com")
+	portPolicy.PortLevelSettings = []*v1alpha3.TrafficPolicy_PortTrafficPolicy{{
+		Port: &v1alpha3.PortSelector{Number: 8080},
+		Tls:  &v1alpha3.ClientTLSSettings{Mode: v1alpha3.ClientTLSSettings_SIMPLE, Sni: "port.example.com"},
+	}}
+
+	cases := []struct {
+		name     string
+		address  string
+		configs  []config.Config
+		expected []string
+	}{
+		{
+			name:     "no destination rule",
+			address:  wildcardIP,
+			expected: []string{"test.com"},
+		},
+		{
+			name:     "destination rule without sni",
+			address:  wildcardIP,
+			configs:  []config.Config{destinationRule(tlsPolicy(""))},
+			expected: []string{"test.com"},
+		},
+		{
+			name:     "sni override",
+			address:  wildcardIP,
+			configs:  []config.Config{destinationRule(tlsPolicy("shared.example.com"))},
+			expected: []string{"shared.example.com"},
+		},
+		{
+			name:     "port level sni override",
+			address:  wildcardIP,
+			configs:  []config.Config{destinationRule(portPolicy)},
+			expected: []string{"port.example.com"},
+		},
+		{
+			name:     "auto sni disabled",
+			address:  wildcardIP,
+			configs:  []config.Config{autoSniDisabled},
+			expected: nil,
+		},
+		{
+			name:     "vip listener does not match sni",
+			address:  "10.10.0.1",
+			configs:  []config.Config{destinationRule(tlsPolicy("shared.example.com"))},
+			expected: nil,
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			service := buildService("test.com", tt.address, protocol.TLS, tnow)
+			cg := NewConfigGenTest(t, TestOptions{Services: []*model.Service{service}, Configs: tt.configs})
+			bind := "0.0.0.0"
+			if tt.address != wildcardIP {
+				bind = tt.address
+			}
+			opts := buildSidecarOutboundTLSFilterChainOpts(cg.SetupProxy(getProxy()), cg.PushContext(), "", service, bind,
+				service.Ports[0], nil, nil)
+			if len(opts) != 1 {
+				t.Fatalf("expected a single filter chain, got %d", len(opts))
+			}
+			if !reflect.DeepEqual(opts[0].sniHosts, tt.expected) {
+				t.Fatalf("unexpected SNI hosts: got %v, want %v", opts[0].sniHosts, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBuildSidecarOutboundTCPTLSFilterChainOptsNoPorts(t *testing.T) {
+	listenPort := &model.Port{Name: "tcp", Port: 9000, Protocol: protocol.TCP}
+	tlsListenPort := &model.Port{Name: "tls", Port: 9443, Protocol: protocol.TLS}
+	service := buildService("test.com", wildcardIP, protocol.TCP, tnow)
+	env := buildListenerEnv([]*model.Service{service})
+	env.PushContext.InitContext(env, nil, nil)
+	// Malformed, e.g. a ServiceEntry without ports.
+	service.Ports = nil
+	proxy := getProxy()
+
+	// A service without ports deterministically routes TLS to the listener port, and TCP to the fallback
+	// cluster of the outbound traffic policy. It is reported.
+	for port, cluster := range map[*model.Port]string{
+		listenPort:    util.BlackHoleCluster,
+		tlsListenPort: fmt.Sprintf("outbound|%d||test.com", tlsListenPort.Port),
+	} {
+		opts := buildSidecarOutboundTCPTLSFilterChainOpts(proxy, env.PushContext, nil, "", service, "0.0.0.0", port, nil)
+		expected := []string{cluster}
+		if got := tcpProxyClusters(t, opts); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("unexpected clusters for port %d: got %v, want %v", port.Port, got, expected)
+		}
+	}
+	if _, f := env.PushContext.ProxyStatus[model.ServiceNoPorts.Name()]["test.com"]; !f {
+		t.Fatalf("expected %s to be reported", model.ServiceNoPorts.Name())
+	}
+
+	// Without a service there is no default route.
+	for _, port := range []*model.Port{listenPort, tlsListenPort} {
+		if opts := buildSidecarOutboundTCPTLSFilterChainOpts(proxy, env.PushContext, nil, "", nil, "0.0.0.0", port, nil); len(opts) != 0 {
+			t.Fatalf("expected no filter chains without a service, got %d", len(opts))
+		}
+	}
+}
+
+func TestBuildSidecarOutboundTCPTLSFilterChainOptsDefaultSubset(t *testing.T) {
+	destinationRule := func(subset string) config.Config {
+		cfg := config.Config{
+			Meta: config.Meta{GroupVersionKind: gvk.DestinationRule, Name: "dr", Namespace: "default"},
+			Spec: &v1alpha3.DestinationRule{
+				Host:    "test.com",
+				Subsets: []*v1alpha3.Subset{{Name: "v1"}, {Name: "v2"}},
+			},
+		}
+		if subset != "" {
+			cfg.Annotations = map[string]string{DefaultSubsetAnnotation: subset}
+		}
+		return cfg
+	}
+	cases := []struct {
+		name     string
+		configs  []config.Config
+		expected string
+	}{
+		{"no destination rule", nil, ""},
+		{"no default subset", []config.Config{destinationRule("")}, ""},
+		{"default subset", []config.Config{destinationRule("v2")}, "v2"},
+		{"unknown default subset", []config.Config{destinationRule("v3")}, ""},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, proto := range []protocol.Instance{protocol.TCP, protocol.TLS} {
+				service := buildService("test.com", "10.10.0.1", proto, tnow)
+				cg := NewConfigGenTest(t, TestOptions{Services: []*model.Service{service}, Configs: tt.configs})
+				opts := buildSidecarOutboundTCPTLSFilterChainOpts(cg.SetupProxy(getProxy()), cg.PushContext(), nil, "",
+					service, "10.10.0.1", service.Ports[0], nil)
+				expected := []string{model.BuildSubsetKey(model.TrafficDirectionOutbound, tt.expected, "test.com", 8080)}
+				if got := tcpProxyClusters(t, opts); !reflect.DeepEqual(got, expected) {
+					t.Fatalf("unexpected %s clusters: got %v, want %v", proto, got, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildSidecarOutboundTCPFilterChainOptsFallback(t *testing.T) {
+	service := buildService("test.com", wildcardIP, protocol.TCP, tnow)
+	service.Ports = model.PortList{
+		{Name: "tcp-1", Port: 9000, Protocol: protocol.TCP},
+		{Name: "tcp-2", Port: 9001, Protocol: protocol.TCP},
+	}
+	env := buildListenerEnv([]*model.Service{service})
+	env.PushContext.InitContext(env, nil, nil)
+
+	cases := []struct {
+		name       string
+		mode       v1alpha3.OutboundTrafficPolicy_Mode
+		listenPort int
+		expected   string
+	}{
+		{"registry only", v1alpha3.OutboundTrafficPolicy_REGISTRY_ONLY, 9999, util.BlackHoleCluster},
+		{"allow any", v1alpha3.OutboundTrafficPolicy_ALLOW_ANY, 9999, util.PassthroughCluster},
+		{"registry only with service port", v1alpha3.OutboundTrafficPolicy_REGISTRY_ONLY, 9001, "outbound|9001||test.com"},
+		{"allow any with service port", v1alpha3.OutboundTrafficPolicy_ALLOW_ANY, 9001, "outbound|9001||test.com"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := getProxy()
+			proxy.SidecarScope = &model.SidecarScope{OutboundTrafficPolicy: &v1alpha3.OutboundTrafficPolicy{Mode: tt.mode}}
+			listenPort := &model.Port{Name: "tcp", Port: tt.listenPort, Protocol: protocol.TCP}
+			opts := buildSidecarOutboundTCPFilterChainOpts(proxy, env.PushContext, "0.0.0.0/0", service, listenPort, nil, nil)
+			if got := tcpProxyClusters(t, opts); !reflect.DeepEqual(got, []string{tt.expected}) {
+				t.Fatalf("unexpected clusters: got %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func tcpProxyClusters(t *testing.T, opts []*filterChainOpts) []string {
+	t.Helper()
+	out := make([]string, 0, len(opts))
+	for _, o := range opts {
+		for _, f := range o.networkFilters {
+			if f.Name != wellknown.TCPProxy {
+				continue
+			}
+			tcpProxy := &tcp.TcpProxy{}
+			if err := f.GetTypedConfig().UnmarshalTo(tcpProxy); err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, tcpProxy.GetCluster())
+		}
+	}
+	return out
+}
+
+func TestGetConfigsForHost(t *testing.T) {
+	virtualService := func(name string, hosts ...string) config.Config {
+		return config.Config{
+			Meta: config.Meta{GroupVersionKind: gvk.VirtualService, Name: name, Namespace: "default"},
+			Spec: &v1alpha3.VirtualService{
+				Hosts: hosts,
+				Tcp: []*v1alpha3.TCPRoute{{
+					Route: []*v1alpha3.RouteDestination{{Destination: &v1alpha3.Destination{Host: "a.foo.com", Subset: name}}},
+				}},
+			},
+		}
+	}
+	configs := []config.Config{
+		virtualService("wildcard", "*"),
+		virtualService("other", "b.foo.com"),
+		virtualService("subdomain", "*.foo.com"),
+		virtualService("exact", "*", "a.foo.com"),
+		virtualService("subdomain-2", "*.foo.com"),
+	}
+
+	got := make([]string, 0)
+	for _, cfg := range getConfigsForHost("a.foo.com", configs) {
+		got = append(got, cfg.Name)
+	}
+	expected := []string{"exact", "subdomain", "subdomain-2", "wildcard"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected configs: got %v, want %v", got, expected)
+	}
+
+	// The filter chain for the exact virtual service must take precedence over the wildcard ones.
+	service := buildService("a.foo.com", "10.10.0.0/24", protocol.TCP, tnow)
+	env := buildListenerEnv([]*model.Service{service})
+	env.PushContext.InitContext(env, nil, nil)
+	opts := buildSidecarOutboundTCPTLSFilterChainOpts(getProxy(), env.PushContext, configs, "10.10.0.0/24", service,
+		"0.0.0.0", service.Ports[0], nil)
+	if len(opts) != 1 {
+		t.Fatalf("expected a single filter chain, got %d", len(opts))
+	}
+	filters := opts[0].networkFilters
+	tcpProxy := &tcp.TcpProxy{}
+	if err := filters[len(filters)-1].GetTypedConfig().UnmarshalTo(tcpProxy); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tcpProxy.GetCluster(), "outbound|8080|exact|a.foo.com"; got != want {
+		t.Fatalf("unexpected cluster: got %v, want %v", got, want)
+	}
+}
@@ -15,6 +15,7 @@
 package util
 
 import (
+	"bytes"
 	"fmt"
 	"net"
 	"sort"
@@ -88,6 +89,10 @@ const (
 	// level tls transport socket configuration
 	EnvoyTLSSocketName = wellknown.TransportSocketTls
 
+	// EnvoyUpstreamProxyProtocolSocketName is the name of the Envoy transport socket which sends a PROXY
+	// protocol header on upstream connections, wrapping another transport socket
+	EnvoyUpstreamProxyProtocolSocketName = "envoy.transport_sockets.upstream_proxy_protocol"
+
 	// StatName patterns
 	serviceStatPattern         = "%SERVICE%"
 	serviceFQDNStatPattern     = "%SERVICE_FQDN%"
@@ -165,6 +170,75 @@ func ConvertAddressToCidr(addr string) *core.CidrRange {
 	return cidr
 }
 
+// MergeCidrs coalesces overlapping and adjacent CIDRs, so that the same addresses are matched with fewer
+// ranges. For example 10.0.0.0/25 and 10.0.0.128/25 are merged into 10.0.0.0/24. Plain IP addresses are
+// treated as single address CIDRs. The result is sorted, followed by the entries that could not be parsed.
+func MergeCidrs(cidrs []string) []string {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	var invalid []string
+	for _, cidr := range cidrs {
+		c := cidr
+		if !strings.Contains(c, "/") {
+			c += "/" + strconv.Itoa(int(getMaxCidrPrefix(c)))
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			invalid = append(invalid, cidr)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	// Sort by family and address, larger ranges first, so a range can only contain the ones following it.
+	sort.Slice(nets, func(i, j int) bool {
+		a, b := nets[i], nets[j]
+		if len(a.IP) != len(b.IP) {
+			return len(a.IP) < len(b.IP)
+		}
+		if c := bytes.Compare(a.IP, b.IP); c != 0 {
+			return c < 0
+		}
+		onesA, _ := a.Mask.Size()
+		onesB, _ := b.Mask.Size()
+		return onesA < onesB
+	})
+
+	merged := make([]*net.IPNet, 0, len(nets))
+	for _, n := range nets {
+		if last := len(merged) - 1; last >= 0 && len(merged[last].IP) == len(n.IP) && merged[last].Contains(n.IP) {
+			continue
+		}
+		merged = append(merged, n)
+		// Replace sibling ranges by their parent, as long as possible.
+		for len(merged) >= 2 {
+			parent := cidrParent(merged[len(merged)-2], merged[len(merged)-1])
+			if parent == nil {
+				break
+			}
+			merged = append(merged[:len(merged)-2], parent)
+		}
+	}
+
+	out := make([]string, 0, len(merged)+len(invalid))
+	for _, n := range merged {
+		out = append(out, n.String())
+	}
+	return append(out, invalid...)
+}
+
+// cidrParent returns the range made of the two given sibling ranges, or nil if they are not siblings.
+func cidrParent(a, b *net.IPNet) *net.IPNet {
+	onesA, bits := a.Mask.Size()
+	onesB, _ := b.Mask.Size()
+	if len(a.IP) != len(b.IP) || onesA != onesB || onesA == 0 || a.IP.Equal(b.IP) {
+		return nil
+	}
+	mask := net.CIDRMask(onesA-1, bits)
+	if !a.IP.Mask(mask).Equal(b.IP.Mask(mask)) {
+		return nil
+	}
+	return &net.IPNet{IP: a.IP.Mask(mask), Mask: mask}
+}
+
 // BuildAddress returns a SocketAddress with the given ip and port or uds.
 func BuildAddress(bind string, port uint32) *core.Address {
 	if port != 0 {
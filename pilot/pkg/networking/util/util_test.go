@@ -1002,6 +1002,31 @@ func TestBuildAddress(t *testing.T) {
 	}
 }
 
+func TestMergeCidrs(t *testing.T) {
+	tests := []struct {
+		name  string
+		cidrs []string
+		want  []string
+	}{
+		{"empty", nil, []string{}},
+		{"adjacent halves", []string{"10.0.0.128/25", "10.0.0.0/25"}, []string{"10.0.0.0/24"}},
+		{"adjacent quarters", []string{"10.0.0.0/26", "10.0.0.64/26", "10.0.0.128/25"}, []string{"10.0.0.0/24"}},
+		{"overlapping", []string{"10.0.0.0/24", "10.0.0.16/28", "10.0.0.0/16"}, []string{"10.0.0.0/16"}},
+		{"not aligned", []string{"10.0.0.128/25", "10.0.1.0/25"}, []string{"10.0.0.128/25", "10.0.1.0/25"}},
+		{"plain addresses", []string{"10.0.0.1", "10.0.0.0"}, []string{"10.0.0.0/31"}},
+		{"duplicates", []string{"10.0.0.0/24", "10.0.0.0/24"}, []string{"10.0.0.0/24"}},
+		{"ipv6", []string{"2001:db8::/33", "2001:db8:8000::/33", "10.0.0.0/8"}, []string{"10.0.0.0/8", "2001:db8::/32"}},
+		{"invalid", []string{"foo", "10.0.0.0/25", "10.0.0.128/25"}, []string{"10.0.0.0/24", "foo"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MergeCidrs(tt.cidrs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MergeCidrs(%v) = %v, want %v", tt.cidrs, got, tt.want)
+			}
+		})
+	}
+}
+
 func TestCidrRangeSliceEqual(t *testing.T) {
 	tests := []struct {
 		name   string
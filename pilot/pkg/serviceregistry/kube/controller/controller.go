@@ -16,6 +16,7 @@ package controller
 
 import (
 	"fmt"
+	"reflect"
 	"sort"
 	"sync"
 	"time"
@@ -146,6 +147,22 @@ type Options struct {
 
 	// If meshConfig.DiscoverySelectors are specified, the DiscoveryNamespacesFilter tracks the namespaces this controller watches.
 	DiscoveryNamespacesFilter filter.DiscoveryNamespacesFilter
+
+	// IngressMaxAttempts is the maximum number of times an ingress event is processed before it is dropped.
+	// If unset, failed events are retried until they succeed.
+	IngressMaxAttempts int
+
+	// IngressRetryDelay is the delay before a failed ingress event is retried. Defaults to 1s if unset.
+	IngressRetryDelay time.Duration
+
+	// IngressNamespaces, if set, restricts the ingress controller to the listed namespaces.
+	// Ingresses in other namespaces are neither watched nor cached. If empty, all namespaces are processed.
+	IngressNamespaces []string
+
+	// IngressDomainSuffix, if set, resolves the domain suffix used to convert the ingresses of a namespace.
+	// An empty result falls back to DomainSuffix. Providers changing the resolved suffixes are responsible
+	// for re-syncing the affected ingresses.
+	IngressDomainSuffix func(namespace string) string
 }
 
 func (o Options) GetSyncInterval() time.Duration {
@@ -155,6 +172,13 @@ func (o Options) GetSyncInterval() time.Duration {
 	return time.Millisecond * 100
 }
 
+func (o Options) GetIngressRetryDelay() time.Duration {
+	if o.IngressRetryDelay > 0 {
+		return o.IngressRetryDelay
+	}
+	return time.Second
+}
+
 // EndpointMode decides what source to use to get endpoint information
 type EndpointMode int
 
@@ -310,7 +334,7 @@ func NewController(kubeClient kubelib.Client, options Options) *Controller {
 	c.serviceInformer = filter.NewFilteredSharedIndexInformer(c.opts.DiscoveryNamespacesFilter.Filter, kubeClient.KubeInformer().Core().V1().Services().Informer())
 	c.serviceLister = listerv1.NewServiceLister(c.serviceInformer.GetIndexer())
 
-	c.registerHandlers(c.serviceInformer, "Services", c.onServiceEvent, nil)
+	c.registerHandlers(c.serviceInformer, "Services", c.onServiceEvent, serviceUpdateIsNoop)
 
 	switch options.EndpointMode {
 	case EndpointsOnly:
@@ -412,6 +436,7 @@ func (c *Controller) onServiceEvent(curr interface{}, event model.Event) error {
 	log.Debugf("Handle event %s for service %s in namespace %s", event, svc.Name, svc.Namespace)
 
 	svcConv := kube.ConvertService(*svc, c.opts.DomainSuffix, c.Cluster())
+	svcUnchanged := false
 	switch event {
 	case model.EventDelete:
 		c.Lock()
@@ -445,6 +470,7 @@ func (c *Controller) onServiceEvent(curr interface{}, event model.Event) error {
 		// instance conversion is only required when service is added/updated.
 		instances := kube.ExternalNameServiceInstances(svc, svcConv)
 		c.Lock()
+		prevConv := c.servicesMap[svcConv.Hostname]
 		c.servicesMap[svcConv.Hostname] = svcConv
 		if len(instances) > 0 {
 			c.externalNameSvcInstanceMap[svcConv.Hostname] = instances
@@ -454,6 +480,8 @@ func (c *Controller) onServiceEvent(curr interface{}, event model.Event) error {
 		if needsFullPush {
 			// networks are different, we need to update all eds endpoints
 			c.opts.XDSUpdater.ConfigUpdate(&model.PushRequest{Full: true, Reason: []model.TriggerReason{model.NetworksTrigger}})
+		} else if event == model.EventUpdate && prevConv.Equals(svcConv) {
+			svcUnchanged = true
 		}
 	}
 
@@ -461,6 +489,15 @@ func (c *Controller) onServiceEvent(curr interface{}, event model.Event) error {
 	// but workload entries will also need to be updated.
 	if event == model.EventAdd || event == model.EventUpdate {
 		endpoints := c.buildEndpointsForService(svcConv)
+		if svcUnchanged {
+			// The converted service is identical, so only its endpoints (e.g. workload entries selected
+			// through a changed targetPort) may be affected. An incremental EDS update is sufficient.
+			log.Debugf("Service %s in namespace %s unchanged, skipping full push", svc.Name, svc.Namespace)
+			if len(endpoints) > 0 {
+				c.opts.XDSUpdater.EDSUpdate(string(c.Cluster()), string(svcConv.Hostname), svc.Namespace, endpoints)
+			}
+			return nil
+		}
 		if len(endpoints) > 0 {
 			c.opts.XDSUpdater.EDSCacheUpdate(string(c.Cluster()), string(svcConv.Hostname), svc.Namespace, endpoints)
 		}
@@ -475,6 +512,23 @@ func (c *Controller) onServiceEvent(curr interface{}, event model.Event) error {
 	return nil
 }
 
+// serviceUpdateIsNoop returns true if none of the Service fields consumed by the controller changed,
+// e.g. when only the resource version or managed fields were updated.
+func serviceUpdateIsNoop(old, cur interface{}) bool {
+	oldSvc, ok := old.(*v1.Service)
+	if !ok {
+		return false
+	}
+	curSvc, ok := cur.(*v1.Service)
+	if !ok {
+		return false
+	}
+	return reflect.DeepEqual(oldSvc.Spec, curSvc.Spec) &&
+		reflect.DeepEqual(oldSvc.Status, curSvc.Status) &&
+		reflect.DeepEqual(oldSvc.Labels, curSvc.Labels) &&
+		reflect.DeepEqual(oldSvc.Annotations, curSvc.Annotations)
+}
+
 func (c *Controller) buildEndpointsForService(svc *model.Service) []*model.IstioEndpoint {
 	endpoints := c.endpoints.buildIstioEndpointsWithService(svc.Attributes.Name, svc.Attributes.Namespace, svc.Hostname)
 	if features.EnableK8SServiceSelectWorkloadEntries {
@@ -896,6 +896,46 @@ func TestController_Service(t *testing.T) {
 	}
 }
 
+func TestController_ServiceNoopUpdate(t *testing.T) {
+	controller, fx := NewFakeControllerWithOptions(FakeControllerOptions{})
+	defer controller.Stop()
+	svc1 := string(kube.ServiceHostname("svc1", "nsA", defaultFakeDomainSuffix))
+
+	createService(controller, "svc1", "nsA", map[string]string{}, []int32{8080}, map[string]string{"app": "a"}, t)
+	if ev := fx.Wait("service"); ev == nil || ev.ID != svc1 {
+		t.Fatalf("expected service event for svc1, got %v", ev)
+	}
+
+	svc, err := controller.client.CoreV1().Services("nsA").Get(context.TODO(), "svc1", metaV1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An update without any change, followed by an update of an annotation that is not
+	// reflected in the converted service. Neither should trigger a service update.
+	if _, err := controller.client.CoreV1().Services("nsA").Update(context.TODO(), svc, metaV1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	svc.Annotations = map[string]string{"unrelated": "true"}
+	if _, err := controller.client.CoreV1().Services("nsA").Update(context.TODO(), svc, metaV1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Events are processed in order, so once svc2 is seen the svc1 updates have been handled.
+	createService(controller, "svc2", "nsA", map[string]string{}, []int32{8080}, map[string]string{"app": "b"}, t)
+	if ev := fx.Wait("service"); ev == nil || ev.ID != string(kube.ServiceHostname("svc2", "nsA", defaultFakeDomainSuffix)) {
+		t.Fatalf("expected no service update for unchanged svc1, got %v", ev)
+	}
+
+	// A real change must still be pushed.
+	svc.Spec.Ports[0].Port = 9090
+	if _, err := controller.client.CoreV1().Services("nsA").Update(context.TODO(), svc, metaV1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if ev := fx.Wait("service"); ev == nil || ev.ID != svc1 {
+		t.Fatalf("expected service event for updated svc1, got %v", ev)
+	}
+}
+
 func TestController_ServiceWithFixedDiscoveryNamespaces(t *testing.T) {
 	meshWatcher := mesh.NewFixedWatcher(&meshconfig.MeshConfig{
 		DiscoverySelectors: []*metaV1.LabelSelector{
@@ -96,6 +96,13 @@ func (fx *FakeXdsUpdater) EDSCacheUpdate(_, hostname, _ string, entry []*model.I
 	}
 }
 
+func (fx *FakeXdsUpdater) FlushEDS(_ string) {
+	select {
+	case fx.Events <- FakeXdsEvent{Type: "flush eds"}:
+	default:
+	}
+}
+
 // SvcUpdate is called when a service port mapping definition is updated.
 // This interface is WIP - labels, annotations and other changes to service may be
 // updated to force a EDS and CDS recomputation and incremental push, as it doesn't affect
@@ -107,6 +114,10 @@ func (fx *FakeXdsUpdater) SvcUpdate(_, hostname string, _ string, _ model.Event)
 	}
 }
 
+func (fx *FakeXdsUpdater) SvcUpdateBatch(shard string, updates []model.ServiceChange) {
+	model.SvcUpdateEach(fx, shard, updates)
+}
+
 func (fx *FakeXdsUpdater) Wait(et string) *FakeXdsEvent {
 	for {
 		select {
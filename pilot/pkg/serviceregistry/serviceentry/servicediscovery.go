@@ -292,20 +292,20 @@ func (s *ServiceEntryStore) serviceEntryHandler(old, curr config.Config, event m
 		unchangedSvcs = cs
 	}
 
+	updates := make([]model.ServiceChange, 0, len(addedSvcs)+len(updatedSvcs)+len(deletedSvcs))
 	for _, svc := range addedSvcs {
-		s.XdsUpdater.SvcUpdate(string(s.Cluster()), string(svc.Hostname), svc.Attributes.Namespace, model.EventAdd)
-		configsUpdated[makeConfigKey(svc)] = struct{}{}
+		updates = append(updates, model.ServiceChange{Hostname: string(svc.Hostname), Namespace: svc.Attributes.Namespace, Event: model.EventAdd})
 	}
-
 	for _, svc := range updatedSvcs {
-		s.XdsUpdater.SvcUpdate(string(s.Cluster()), string(svc.Hostname), svc.Attributes.Namespace, model.EventUpdate)
-		configsUpdated[makeConfigKey(svc)] = struct{}{}
+		updates = append(updates, model.ServiceChange{Hostname: string(svc.Hostname), Namespace: svc.Attributes.Namespace, Event: model.EventUpdate})
 	}
-
 	// If service entry is deleted, cleanup endpoint shards for services.
 	for _, svc := range deletedSvcs {
-		s.XdsUpdater.SvcUpdate(string(s.Cluster()), string(svc.Hostname), svc.Attributes.Namespace, model.EventDelete)
-		configsUpdated[makeConfigKey(svc)] = struct{}{}
+		updates = append(updates, model.ServiceChange{Hostname: string(svc.Hostname), Namespace: svc.Attributes.Namespace, Event: model.EventDelete})
+	}
+	if len(updates) > 0 {
+		s.XdsUpdater.SvcUpdateBatch(string(s.Cluster()), updates)
+		configsUpdated = model.ServiceChangesConfigKeys(updates)
 	}
 
 	if len(unchangedSvcs) > 0 {
@@ -80,6 +80,7 @@ type Event struct {
 }
 
 type FakeXdsUpdater struct {
+	model.NoopEDSFlusher
 	// Events tracks notifications received by the updater
 	Events chan Event
 }
@@ -105,6 +106,10 @@ func (fx *FakeXdsUpdater) SvcUpdate(_, hostname string, namespace string, event
 	fx.Events <- Event{kind: "svcupdate", host: hostname, namespace: namespace}
 }
 
+func (fx *FakeXdsUpdater) SvcUpdateBatch(shard string, updates []model.ServiceChange) {
+	model.SvcUpdateEach(fx, shard, updates)
+}
+
 func waitForEvent(t *testing.T, ch chan Event) Event {
 	t.Helper()
 	select {
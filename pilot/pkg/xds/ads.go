@@ -15,9 +15,14 @@
 package xds
 
 import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"math/rand"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -25,14 +30,17 @@ import (
 	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	uatomic "go.uber.org/atomic"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
 
 	"istio.io/istio/pilot/pkg/controller/workloadentry"
 	"istio.io/istio/pilot/pkg/features"
 	istiogrpc "istio.io/istio/pilot/pkg/grpc"
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pilot/pkg/networking/util"
+	"istio.io/istio/pilot/pkg/util/sets"
 	v3 "istio.io/istio/pilot/pkg/xds/v3"
 	"istio.io/istio/pkg/cluster"
 	"istio.io/istio/pkg/config/schema/gvk"
@@ -46,6 +54,9 @@ var (
 
 	// Tracks connections, increment on each new connection.
 	connectionNumber = int64(0)
+
+	// Unique to this process, so connection IDs do not collide across restarts.
+	bootID = newBootID()
 )
 
 // Used only when running in KNative, to handle the load balancing behavior.
@@ -92,16 +103,32 @@ type Connection struct {
 	// deltaStream is used for Delta XDS. Only one of deltaStream or stream will be set
 	deltaStream DeltaDiscoveryStream
 
+	// sendMutex serializes sends on stream, as Push may be called concurrently with regular pushes.
+	sendMutex sync.Mutex
+
+	// lastResponses are the last responses sent, keyed by TypeUrl, for ReplayLast. Responses larger than
+	// replayMaxSize are not kept. Protected by sendMutex.
+	lastResponses map[string]*discovery.DiscoveryResponse
+	replayMaxSize int
+
+	// sentSecrets are the secrets sent to the client, keyed by name, to detect secrets that are removed and
+	// to complete pushes generating only newly subscribed secrets. Only accessed from pushXds.
+	sentSecrets map[string]*discovery.Resource
+
 	// Original node metadata, to avoid unmarshal/marshal.
 	// This is included in internal events.
 	node *core.Node
 
+	// streamMetadata is the gRPC metadata received when the stream was established.
+	streamMetadata metadata.MD
+
 	// initialized channel will be closed when proxy is initialized. Pushes, or anything accessing
 	// the proxy, should not be started until this channel is closed.
 	initialized chan struct{}
 
 	// stop can be used to end the connection manually via debug endpoints. Only to be used for testing.
-	stop chan struct{}
+	// A non-nil status is returned to the client.
+	stop chan *status.Status
 
 	// reqChan is used to receive discovery requests for this connection.
 	reqChan      chan *discovery.DiscoveryRequest
@@ -110,10 +137,42 @@ type Connection struct {
 	// errorChan is used to process error during discovery request processing.
 	errorChan chan error
 
+	// releaseStream releases the stream slot of the connection, see reserveStream. It is safe to call more
+	// than once, and may be nil if the connection does not hold a slot.
+	releaseStream func()
+
 	// blockedPushes is a map of TypeUrl to push request. This is set when we attempt to push to a busy Envoy
 	// (last push not ACKed). When we get an ACK from Envoy, if the type is populated here, we will trigger
 	// the push.
 	blockedPushes map[string]*model.PushRequest
+
+	// watchedResourceIndex maps an explicitly watched resource name to the set of TypeUrls it is watched
+	// for. It is kept in sync with proxy.WatchedResources and protected by the proxy lock.
+	watchedResourceIndex map[string]sets.Set
+
+	// watchedTypes lists the TypeUrls requested by the client, least recently requested first. It is used
+	// to evict watches when the connection exceeds the maximum number of watched types, and is protected
+	// by the proxy lock.
+	watchedTypes []string
+
+	// pushesEnqueued counts the pushes enqueued for the connection, pushesDequeued how many of them are
+	// covered by the push being processed, and pushesDone how many have been delivered. They are
+	// protected by the PushQueue lock.
+	pushesEnqueued, pushesDequeued, pushesDone int64
+
+	// reinitialize is set by ReinitializeProxy to recompute the proxy state on the next push.
+	reinitialize uatomic.Bool
+
+	// incarnation is the state of the proxy when it last disconnected, if the same process reconnected.
+	// It is set on initialization.
+	incarnation *proxyIncarnation
+}
+
+// proxyIncarnation is the state of a disconnected proxy process, identified by its boot ID.
+type proxyIncarnation struct {
+	bootID string
+	// watched holds the watched resources whose last response was ACKed on disconnection.
+	watched map[string]*model.WatchedResource
 }
 
 // Event represents a config or registry event that results in a push.
@@ -129,7 +188,7 @@ func newConnection(peerAddr string, stream DiscoveryStream) *Connection {
 	return &Connection{
 		pushChannel:   make(chan *Event),
 		initialized:   make(chan struct{}),
-		stop:          make(chan struct{}),
+		stop:          make(chan *status.Status),
 		reqChan:       make(chan *discovery.DiscoveryRequest, 1),
 		errorChan:     make(chan error, 1),
 		PeerAddr:      peerAddr,
@@ -173,7 +232,7 @@ func (s *DiscoveryServer) receive(con *Connection) {
 			}
 			// TODO: We should validate that the namespace in the cert matches the claimed namespace in metadata.
 			if err := s.initConnection(req.Node, con); err != nil {
-				con.errorChan <- err
+				con.errorChan <- initConnectionStatus(err)
 				return
 			}
 			defer s.closeConnection(con)
@@ -203,17 +262,22 @@ func (s *DiscoveryServer) processRequest(req *discovery.DiscoveryRequest, con *C
 			TypeUrl: req.TypeUrl, ResourceNames: req.ResourceNames,
 		}, &model.PushRequest{Full: true})
 	}
+	if req.TypeUrl == v3.ResetType {
+		log.Debugf("ADS: RESET %s", con.ConID)
+		con.resetWatches()
+		return nil
+	}
 	if s.StatusReporter != nil {
 		s.StatusReporter.RegisterEvent(con.ConID, req.TypeUrl, req.ResponseNonce)
 	}
-	shouldRespond := s.shouldRespond(con, req)
+	shouldRespond, delta := s.shouldRespond(con, req)
 
 	var request *model.PushRequest
 	push := s.globalPushContext()
 	if shouldRespond {
 		// This is a request, trigger a full push for this type. Override the blocked push (if it exists),
 		// as this full push is guaranteed to be a superset of what we would have pushed from the blocked push.
-		request = &model.PushRequest{Full: true, Push: push}
+		request = &model.PushRequest{Full: true, Push: push, Delta: delta}
 	} else {
 		// Check if we have a blocked push. If this was an ACK, we will send it.
 		// Either way we remove the blocked push as we will send a push.
@@ -261,6 +325,11 @@ func (s *DiscoveryServer) Stream(stream DiscoveryStream) error {
 	if !s.IsServerReady() {
 		return status.Error(codes.Unavailable, "server is not ready to serve discovery information")
 	}
+	releaseStream, err := s.reserveStream()
+	if err != nil {
+		return err
+	}
+	defer releaseStream()
 
 	ctx := stream.Context()
 	peerAddr := "0.0.0.0"
@@ -286,7 +355,10 @@ func (s *DiscoveryServer) Stream(stream DiscoveryStream) error {
 		return status.Error(codes.Unavailable, "error reading config")
 	}
 	con := newConnection(peerAddr, stream)
+	con.releaseStream = releaseStream
 	con.Identities = ids
+	con.replayMaxSize = s.ReplayMaxSize
+	con.streamMetadata, _ = metadata.FromIncomingContext(ctx)
 
 	// Do not call: defer close(con.pushChannel). The push channel will be garbage collected
 	// when the connection is no longer used. Closing the channel can cause subtle race conditions
@@ -297,12 +369,12 @@ func (s *DiscoveryServer) Stream(stream DiscoveryStream) error {
 	// We need 2 go routines because 'read' blocks in Recv().
 	go s.receive(con)
 
-	// Wait for the proxy to be fully initialized before we start serving traffic. Because
-	// initialization doesn't have dependencies that will block, there is no need to add any timeout
-	// here. Prior to this explicit wait, we were implicitly waiting by receive() not sending to
-	// reqChannel and the connection not being enqueued for pushes to pushChannel until the
-	// initialization is complete.
-	<-con.initialized
+	// Wait for the proxy to be fully initialized before we start serving traffic. Prior to this
+	// explicit wait, we were implicitly waiting by receive() not sending to reqChannel and the
+	// connection not being enqueued for pushes to pushChannel until the initialization is complete.
+	if err := s.waitForInitialization(con); err != nil {
+		return err
+	}
 
 	for {
 		select {
@@ -321,23 +393,43 @@ func (s *DiscoveryServer) Stream(stream DiscoveryStream) error {
 			if err != nil {
 				return err
 			}
-		case <-con.stop:
-			return nil
+		case st := <-con.stop:
+			return con.stopError(st)
 		}
 	}
 }
 
-// shouldRespond determines whether this request needs to be responded back. It applies the ack/nack rules as per xds protocol
-// using WatchedResource for previous state and discovery request for the current state.
-func (s *DiscoveryServer) shouldRespond(con *Connection, request *discovery.DiscoveryRequest) bool {
-	stype := v3.GetShortType(request.TypeUrl)
+// waitForInitialization blocks until the connection is initialized, or InitTimeout elapses. On timeout
+// the stream is failed with DeadlineExceeded; the receiving goroutine still owns the connection and
+// releases it through closeConnection once initialization returns and it observes the closed stream.
+func (s *DiscoveryServer) waitForInitialization(con *Connection) error {
+	if s.InitTimeout <= 0 {
+		<-con.initialized
+		return nil
+	}
+	timer := time.NewTimer(s.InitTimeout)
+	defer timer.Stop()
+	select {
+	case <-con.initialized:
+		return nil
+	case <-timer.C:
+		log.Warnf("ADS: %q timed out after %v waiting for connection initialization", con.PeerAddr, s.InitTimeout)
+		xdsInitTimeouts.Increment()
+		return status.Errorf(codes.DeadlineExceeded, "connection initialization did not complete within %v", s.InitTimeout)
+	}
+}
 
+// shouldRespond determines whether this request needs to be responded back. It applies the ack/nack rules as per xds protocol
+// using WatchedResource for previous state and discovery request for the current state. When responding to a change of
+// the requested resource names, it also returns the resource names subscribed to and unsubscribed from.
+func (s *DiscoveryServer) shouldRespond(con *Connection, request *discovery.DiscoveryRequest) (bool, model.ResourceDelta) {
 	// If there is an error in request that means previous response is erroneous.
 	// We do not have to respond in that case. In this case request's version info
 	// will be different from the version sent. But it is fragile to rely on that.
 	if request.ErrorDetail != nil {
 		errCode := codes.Code(request.ErrorDetail.Code)
-		log.Warnf("ADS:%s: ACK ERROR %s %s:%s", stype, con.ConID, errCode.String(), request.ErrorDetail.GetMessage())
+		con.logger(request.TypeUrl, "nonce", request.ResponseNonce, "code", errCode.String()).
+			Warnf("ACK ERROR: %s", request.ErrorDetail.GetMessage())
 		incrementXDSRejects(request.TypeUrl, con.proxy.ID, errCode.String())
 		if s.StatusGen != nil {
 			s.StatusGen.OnNack(con.proxy, request)
@@ -345,26 +437,58 @@ func (s *DiscoveryServer) shouldRespond(con *Connection, request *discovery.Disc
 		con.proxy.Lock()
 		if w, f := con.proxy.WatchedResources[request.TypeUrl]; f {
 			w.NonceNacked = request.ResponseNonce
+			w.LastError = request.ErrorDetail.GetMessage()
+			w.LastErrorCode = errCode
 		}
 		con.proxy.Unlock()
-		return false
+		return false, model.ResourceDelta{}
 	}
 
 	if shouldUnsubscribe(request) {
-		log.Debugf("ADS:%s: UNSUBSCRIBE %s %s %s", stype, con.ConID, request.VersionInfo, request.ResponseNonce)
 		con.proxy.Lock()
+		_, watched := con.proxy.WatchedResources[request.TypeUrl]
+		if !watched && request.ResponseNonce == "" {
+			// The initial request of a client that does not know its resource names yet, e.g. an SDS
+			// client opening its stream early. Record an empty watch so the names it adds later are
+			// handled as a change of the watch, but there is nothing to respond.
+			con.proxy.WatchedResources[request.TypeUrl] = &model.WatchedResource{TypeUrl: request.TypeUrl, LastRequest: request}
+			con.touchWatchedType(request.TypeUrl)
+			con.evictWatchedTypes(s.MaxWatchedTypes)
+			con.proxy.Unlock()
+			if log.DebugEnabled() {
+				con.logger(request.TypeUrl, "version", request.VersionInfo).Debug("INIT empty")
+			}
+			return false, model.ResourceDelta{}
+		}
+		con.proxy.Unlock()
+		if log.DebugEnabled() {
+			con.logger(request.TypeUrl, "version", request.VersionInfo, "nonce", request.ResponseNonce).Debug("UNSUBSCRIBE")
+		}
+		con.proxy.Lock()
+		if w, f := con.proxy.WatchedResources[request.TypeUrl]; f {
+			con.updateWatchedResourceIndex(request.TypeUrl, w.ResourceNames, nil)
+		}
 		delete(con.proxy.WatchedResources, request.TypeUrl)
+		con.removeWatchedType(request.TypeUrl)
 		con.proxy.Unlock()
-		return false
+		return false, model.ResourceDelta{}
 	}
 
 	// This is first request - initialize typeUrl watches.
 	if request.ResponseNonce == "" {
-		log.Debugf("ADS:%s: INIT %s %s %s", stype, con.ConID, request.VersionInfo, request.ResponseNonce)
+		if log.DebugEnabled() {
+			con.logger(request.TypeUrl, "version", request.VersionInfo, "nonce", request.ResponseNonce).Debug("INIT")
+		}
 		con.proxy.Lock()
+		if w, f := con.proxy.WatchedResources[request.TypeUrl]; f {
+			con.updateWatchedResourceIndex(request.TypeUrl, w.ResourceNames, nil)
+		}
+		con.updateWatchedResourceIndex(request.TypeUrl, nil, request.ResourceNames)
 		con.proxy.WatchedResources[request.TypeUrl] = &model.WatchedResource{TypeUrl: request.TypeUrl, ResourceNames: request.ResourceNames, LastRequest: request}
+		con.touchWatchedType(request.TypeUrl)
+		con.evictWatchedTypes(s.MaxWatchedTypes)
 		con.proxy.Unlock()
-		return true
+		return true, model.ResourceDelta{}
 	}
 
 	con.proxy.RLock()
@@ -376,54 +500,89 @@ func (s *DiscoveryServer) shouldRespond(con *Connection, request *discovery.Disc
 	// because Istiod is restarted or Envoy disconnects and reconnects.
 	// We should always respond with the current resource names.
 	if previousInfo == nil {
-		log.Debugf("ADS:%s: RECONNECT %s %s %s", stype, con.ConID, request.VersionInfo, request.ResponseNonce)
+		if log.DebugEnabled() {
+			con.logger(request.TypeUrl, "version", request.VersionInfo, "nonce", request.ResponseNonce).Debug("RECONNECT")
+		}
+		w := &model.WatchedResource{TypeUrl: request.TypeUrl, ResourceNames: request.ResourceNames, LastRequest: request}
+		// If the same proxy process reconnects and already has the current config, the response it
+		// ACKed last is still valid: resume from it rather than resending the config.
+		unchanged := con.unchangedSinceIncarnation(request)
+		if unchanged {
+			w.NonceSent, w.NonceAcked = request.ResponseNonce, request.ResponseNonce
+			w.VersionSent, w.VersionAcked = request.VersionInfo, request.VersionInfo
+		}
 		con.proxy.Lock()
-		con.proxy.WatchedResources[request.TypeUrl] = &model.WatchedResource{TypeUrl: request.TypeUrl, ResourceNames: request.ResourceNames, LastRequest: request}
+		con.updateWatchedResourceIndex(request.TypeUrl, nil, request.ResourceNames)
+		con.proxy.WatchedResources[request.TypeUrl] = w
+		con.touchWatchedType(request.TypeUrl)
+		con.evictWatchedTypes(s.MaxWatchedTypes)
 		con.proxy.Unlock()
-		return true
+		return !unchanged, model.ResourceDelta{}
 	}
 
 	// If there is mismatch in the nonce, that is a case of expired/stale nonce.
 	// A nonce becomes stale following a newer nonce being sent to Envoy.
 	if request.ResponseNonce != previousInfo.NonceSent {
-		log.Debugf("ADS:%s: REQ %s Expired nonce received %s, sent %s", stype,
-			con.ConID, request.ResponseNonce, previousInfo.NonceSent)
+		if log.DebugEnabled() {
+			con.logger(request.TypeUrl, "nonce", request.ResponseNonce, "nonceSent", previousInfo.NonceSent).Debug("expired nonce received")
+		}
 		xdsExpiredNonce.With(typeTag.Value(v3.GetMetricType(request.TypeUrl))).Increment()
 		con.proxy.Lock()
 		con.proxy.WatchedResources[request.TypeUrl].NonceNacked = ""
 		con.proxy.WatchedResources[request.TypeUrl].LastRequest = request
 		con.proxy.Unlock()
-		return false
+		return false, model.ResourceDelta{}
 	}
 
 	// If it comes here, that means nonce match. This an ACK. We should record
 	// the ack details and respond if there is a change in resource names.
+	ackedVersion, reason := parseVersionInfo(request.VersionInfo)
 	con.proxy.Lock()
 	previousResources := con.proxy.WatchedResources[request.TypeUrl].ResourceNames
-	con.proxy.WatchedResources[request.TypeUrl].VersionAcked = request.VersionInfo
+	con.proxy.WatchedResources[request.TypeUrl].VersionAcked = ackedVersion
 	con.proxy.WatchedResources[request.TypeUrl].NonceAcked = request.ResponseNonce
 	con.proxy.WatchedResources[request.TypeUrl].NonceNacked = ""
+	con.proxy.WatchedResources[request.TypeUrl].LastError = ""
+	con.proxy.WatchedResources[request.TypeUrl].LastErrorCode = codes.OK
 	con.proxy.WatchedResources[request.TypeUrl].ResourceNames = request.ResourceNames
 	con.proxy.WatchedResources[request.TypeUrl].LastRequest = request
-	con.proxy.Unlock()
-
 	// Envoy can send two DiscoveryRequests with same version and nonce
 	// when it detects a new resource. We should respond if they change.
-	if listEqualUnordered(previousResources, request.ResourceNames) {
-		log.Debugf("ADS:%s: ACK %s %s %s", stype, con.ConID, request.VersionInfo, request.ResponseNonce)
-		return false
+	resourcesUnchanged := listEqualUnordered(s.CanonicalizeResourceNames(request.TypeUrl, previousResources),
+		s.CanonicalizeResourceNames(request.TypeUrl, request.ResourceNames))
+	if !resourcesUnchanged {
+		con.updateWatchedResourceIndex(request.TypeUrl, previousResources, request.ResourceNames)
 	}
-	log.Debugf("ADS:%s: RESOURCE CHANGE previous resources: %v, new resources: %v %s %s %s", stype,
-		previousResources, request.ResourceNames, con.ConID, request.VersionInfo, request.ResponseNonce)
+	con.touchWatchedType(request.TypeUrl)
+	con.proxy.Unlock()
 
-	return true
+	if resourcesUnchanged {
+		if log.DebugEnabled() {
+			l := con.logger(request.TypeUrl, "version", ackedVersion, "nonce", request.ResponseNonce)
+			if reason != "" {
+				l = l.WithLabels("reason", reason)
+			}
+			l.Debug("ACK")
+		}
+		return false, model.ResourceDelta{}
+	}
+	if log.DebugEnabled() {
+		con.logger(request.TypeUrl, "version", request.VersionInfo, "nonce", request.ResponseNonce).
+			Debugf("RESOURCE CHANGE previous resources: %v, new resources: %v", previousResources, request.ResourceNames)
+	}
+
+	previous, current := sets.NewSet(previousResources...), sets.NewSet(request.ResourceNames...)
+	return true, model.ResourceDelta{
+		Subscribed:   current.Difference(previous),
+		Unsubscribed: previous.Difference(current),
+	}
 }
 
 // shouldUnsubscribe checks if we should unsubscribe. This is done when Envoy is
 // no longer watching. For example, we remove all RDS references, we will
 // unsubscribe from RDS. NOTE: This may happen as part of the initial request. If
-// there are no routes needed, Envoy will send an empty request, which this
-// properly handles by not adding it to the watched resource list.
+// there are no routes needed, Envoy will send an empty request. shouldRespond
+// records an empty watch in that case, which is not pushed until names are added.
 func shouldUnsubscribe(request *discovery.DiscoveryRequest) bool {
 	return len(request.ResourceNames) == 0 && !isWildcardTypeURL(request.TypeUrl)
 }
@@ -449,16 +608,37 @@ func isWildcardTypeURL(typeURL string) bool {
 	}
 }
 
+// CanonicalizeResourceNames returns the canonical form of the resource names of the given type, see
+// ResourceNameCanonicalizers. Names of types without canonicalizer are returned as is.
+func (s *DiscoveryServer) CanonicalizeResourceNames(typeURL string, names []string) []string {
+	if canonicalize, f := s.ResourceNameCanonicalizers[typeURL]; f {
+		return canonicalize(names)
+	}
+	return names
+}
+
 // listEqualUnordered checks that two lists contain all the same elements
 func listEqualUnordered(a []string, b []string) bool {
 	if len(a) != len(b) {
 		return false
 	}
+	// Envoy typically sends resource names in the same order on every request, so compare
+	// in place first. This avoids allocating a set for the common ACK case.
+	i := 0
+	for ; i < len(a); i++ {
+		if a[i] != b[i] {
+			break
+		}
+	}
+	if i == len(a) {
+		return true
+	}
 	first := make(map[string]struct{}, len(a))
 	for _, c := range a {
 		first[c] = struct{}{}
 	}
-	for _, c := range b {
+	// b[:i] is identical to a[:i], so only the remaining elements need to be checked.
+	for _, c := range b[i:] {
 		_, f := first[c]
 		if !f {
 			return false
@@ -467,6 +647,47 @@ func listEqualUnordered(a []string, b []string) bool {
 	return true
 }
 
+var (
+	// ErrInvalidNodeMetadata is returned when the node sent by the client cannot be parsed.
+	ErrInvalidNodeMetadata = errors.New("invalid node metadata")
+	// ErrProxyInit is returned when the proxy of a valid node could not be initialized.
+	ErrProxyInit = errors.New("proxy initialization failed")
+)
+
+// initError is an error of initConnection, of kind ErrInvalidNodeMetadata or ErrProxyInit, wrapping its cause.
+type initError struct {
+	kind  error
+	cause error
+}
+
+func (e *initError) Error() string {
+	return fmt.Sprintf("%v: %v", e.kind, e.cause)
+}
+
+func (e *initError) Is(target error) bool {
+	return target == e.kind
+}
+
+func (e *initError) Unwrap() error {
+	return e.cause
+}
+
+// initConnectionStatus maps an error of initConnection to a gRPC status error, so that clients can tell
+// their invalid requests from server failures. Errors that are already gRPC status errors are unchanged.
+func initConnectionStatus(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch {
+	case errors.Is(err, ErrInvalidNodeMetadata):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrProxyInit):
+		return status.Error(codes.Internal, err.Error())
+	default:
+		return err
+	}
+}
+
 // update the node associated with the connection, after receiving a packet from envoy, also adds the connection
 // to the tracking map.
 func (s *DiscoveryServer) initConnection(node *core.Node, con *Connection) error {
@@ -479,6 +700,7 @@ func (s *DiscoveryServer) initConnection(node *core.Node, con *Connection) error
 	con.ConID = connectionID(proxy.ID)
 	con.node = node
 	con.proxy = proxy
+	proxy.StreamMetadata = con.streamMetadata
 	if features.EnableXDSIdentityCheck && con.Identities != nil {
 		// TODO: allow locking down, rejecting unauthenticated requests.
 		id, err := checkConnectionIdentity(con)
@@ -502,7 +724,7 @@ func (s *DiscoveryServer) initConnection(node *core.Node, con *Connection) error
 	// Complete full initialization of the proxy
 	if err := s.initializeProxy(node, con); err != nil {
 		s.closeConnection(con)
-		return err
+		return &initError{kind: ErrProxyInit, cause: err}
 	}
 
 	if s.StatusGen != nil {
@@ -512,10 +734,15 @@ func (s *DiscoveryServer) initConnection(node *core.Node, con *Connection) error
 }
 
 func (s *DiscoveryServer) closeConnection(con *Connection) {
+	// Release the stream slot as soon as the connection is closed, rather than once the stream returns.
+	if con.releaseStream != nil {
+		con.releaseStream()
+	}
 	if con.ConID == "" {
 		return
 	}
 	s.removeCon(con.ConID)
+	s.recordIncarnation(con)
 	if s.StatusGen != nil {
 		s.StatusGen.OnDisconnect(con)
 	}
@@ -525,6 +752,71 @@ func (s *DiscoveryServer) closeConnection(con *Connection) {
 	s.WorkloadEntryController.QueueUnregisterWorkload(con.proxy, con.Connect)
 }
 
+// recordIncarnation records the state of a disconnecting proxy which provided a boot ID, so that the
+// process can resume from it if it reconnects before the next full push.
+func (s *DiscoveryServer) recordIncarnation(con *Connection) {
+	if con.proxy.Metadata.BootID == "" {
+		return
+	}
+	inc := &proxyIncarnation{bootID: con.proxy.Metadata.BootID, watched: map[string]*model.WatchedResource{}}
+	con.proxy.RLock()
+	for typeURL, w := range con.proxy.WatchedResources {
+		if w.NonceSent != "" && w.NonceAcked == w.NonceSent {
+			inc.watched[typeURL] = &model.WatchedResource{
+				TypeUrl:       typeURL,
+				ResourceNames: w.ResourceNames,
+				VersionAcked:  w.VersionAcked,
+				NonceAcked:    w.NonceAcked,
+			}
+		}
+	}
+	con.proxy.RUnlock()
+
+	s.incarnationsMutex.Lock()
+	defer s.incarnationsMutex.Unlock()
+	if s.incarnations == nil {
+		s.incarnations = map[string]*proxyIncarnation{}
+	}
+	s.incarnations[con.proxy.ID] = inc
+}
+
+// takeIncarnation returns the state recorded when the proxy last disconnected, if it is the same process.
+func (s *DiscoveryServer) takeIncarnation(proxy *model.Proxy) *proxyIncarnation {
+	if proxy.Metadata.BootID == "" {
+		return nil
+	}
+	s.incarnationsMutex.Lock()
+	defer s.incarnationsMutex.Unlock()
+	inc := s.incarnations[proxy.ID]
+	delete(s.incarnations, proxy.ID)
+	if inc == nil || inc.bootID != proxy.Metadata.BootID {
+		return nil
+	}
+	return inc
+}
+
+// resetIncarnations drops the recorded state of disconnected proxies, which is outdated after a full push.
+func (s *DiscoveryServer) resetIncarnations() {
+	s.incarnationsMutex.Lock()
+	defer s.incarnationsMutex.Unlock()
+	s.incarnations = nil
+}
+
+// unchangedSinceIncarnation returns true if the reconnection request resumes from the last response ACKed
+// by the same process before it disconnected, and that response is still current. EDS is excluded, as
+// incremental pushes update endpoints without changing the version.
+func (con *Connection) unchangedSinceIncarnation(request *discovery.DiscoveryRequest) bool {
+	if con.incarnation == nil || request.TypeUrl == v3.EndpointType {
+		return false
+	}
+	w := con.incarnation.watched[request.TypeUrl]
+	if w == nil || w.NonceAcked != request.ResponseNonce || !listEqualUnordered(w.ResourceNames, request.ResourceNames) {
+		return false
+	}
+	version, _ := parseVersionInfo(request.VersionInfo)
+	return version == versionInfo()
+}
+
 func checkConnectionIdentity(con *Connection) (*spiffe.Identity, error) {
 	for _, rawID := range con.Identities {
 		spiffeID, err := spiffe.ParseIdentity(rawID)
@@ -542,9 +834,19 @@ func checkConnectionIdentity(con *Connection) (*spiffe.Identity, error) {
 	return nil, fmt.Errorf("no identities (%v) matched %v/%v", con.Identities, con.proxy.ConfigNamespace, con.proxy.Metadata.ServiceAccount)
 }
 
+// connectionID returns a connection ID of the form <node>-<boot ID>-<connection number>.
 func connectionID(node string) string {
 	id := atomic.AddInt64(&connectionNumber, 1)
-	return node + "-" + strconv.FormatInt(id, 10)
+	return node + "-" + bootID + "-" + strconv.FormatInt(id, 10)
+}
+
+// newBootID returns a short random ID, falling back to the start time if no randomness is available.
+func newBootID() string {
+	b := make([]byte, 4)
+	if _, err := crand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
 }
 
 // initProxyMetadata initializes just the basic metadata of a proxy. This is decoupled from
@@ -553,18 +855,56 @@ func connectionID(node string) string {
 func (s *DiscoveryServer) initProxyMetadata(node *core.Node) (*model.Proxy, error) {
 	meta, err := model.ParseMetadata(node.Metadata)
 	if err != nil {
-		return nil, err
+		return nil, &initError{kind: ErrInvalidNodeMetadata, cause: err}
 	}
 	proxy, err := model.ParseServiceNodeWithMetadata(node.Id, meta)
 	if err != nil {
-		return nil, err
+		return nil, &initError{kind: ErrInvalidNodeMetadata, cause: err}
 	}
 	// Update the config namespace associated with this proxy
 	proxy.ConfigNamespace = model.GetProxyConfigNamespace(proxy)
 	proxy.XdsNode = node
+	// The locality of the registry, if any, takes precedence once the proxy is initialized.
+	proxy.Locality = nodeLocality(node, meta)
 	return proxy, nil
 }
 
+// nodeLocality returns the locality sent by the proxy: the locality of the node, or else the istio-locality
+// label of its metadata. A malformed label is ignored, resulting in an empty locality.
+func nodeLocality(node *core.Node, meta *model.NodeMetadata) *core.Locality {
+	if node.Locality.GetRegion() != "" || node.Locality.GetZone() != "" || node.Locality.GetSubZone() != "" {
+		return &core.Locality{
+			Region:  node.Locality.GetRegion(),
+			Zone:    node.Locality.GetZone(),
+			SubZone: node.Locality.GetSubZone(),
+		}
+	}
+	label := meta.Labels[model.LocalityLabel]
+	if label == "" {
+		return &core.Locality{}
+	}
+	locality, err := parseLocality(model.GetLocalityLabelOrDefault(label, ""))
+	if err != nil {
+		log.Warnf("ignoring locality of node %s: %v", node.Id, err)
+		return &core.Locality{}
+	}
+	return locality
+}
+
+// parseLocality parses a region[/zone[/subzone]] locality.
+func parseLocality(locality string) (*core.Locality, error) {
+	parts := strings.Split(locality, "/")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("invalid locality %q: expected region[/zone[/subzone]]", locality)
+	}
+	for _, p := range parts {
+		if p == "" {
+			return nil, fmt.Errorf("invalid locality %q: empty segment", locality)
+		}
+	}
+	return util.ConvertLocality(locality), nil
+}
+
 // initializeProxy completes the initialization of a proxy. It is expected to be called only after
 // initProxyMetadata.
 func (s *DiscoveryServer) initializeProxy(node *core.Node, con *Connection) error {
@@ -575,7 +915,26 @@ func (s *DiscoveryServer) initializeProxy(node *core.Node, con *Connection) erro
 		return err
 	}
 	s.computeProxyState(proxy, nil)
+	setProxyLocality(proxy, node)
+
+	// Discover supported IP Versions of proxy so that appropriate config can be delivered.
+	proxy.DiscoverIPVersions()
+
+	proxy.WatchedResources = map[string]*model.WatchedResource{}
+	con.incarnation = s.takeIncarnation(proxy)
+	// Based on node metadata and version, we can associate a different generator.
+	if proxy.Metadata.Generator != "" {
+		proxy.XdsResourceGenerator = s.Generators[proxy.Metadata.Generator]
+	}
 
+	recordXDSClients(proxy.Metadata.IstioVersion, 1)
+	return nil
+}
+
+// setProxyLocality sets the locality of the proxy from its service instances, falling back to the
+// locality sent by the node.
+func setProxyLocality(proxy *model.Proxy, node *core.Node) {
+	proxy.Locality = nil
 	// Get the locality from the proxy's service instances.
 	// We expect all instances to have the same IP and therefore the same locality.
 	// So its enough to look at the first instance.
@@ -587,24 +946,31 @@ func (s *DiscoveryServer) initializeProxy(node *core.Node, con *Connection) erro
 	// This is not preferable as only the connected Pilot is aware of this proxies location, but it
 	// can still help provide some client-side Envoy context when load balancing based on location.
 	if util.IsLocalityEmpty(proxy.Locality) {
-		proxy.Locality = &core.Locality{
-			Region:  node.Locality.GetRegion(),
-			Zone:    node.Locality.GetZone(),
-			SubZone: node.Locality.GetSubZone(),
-		}
+		proxy.Locality = nodeLocality(node, proxy.Metadata)
 	}
+}
 
-	// Discover supported IP Versions of proxy so that appropriate config can be delivered.
-	proxy.DiscoverIPVersions()
+// ReinitializeProxy requests the proxy of the connection to be reinitialized on its next push, for
+// example after a PushContext swap left its cached state stale. The state derived from the registries
+// and the push context is recomputed as on connection: workload labels, service instances, sidecar
+// scope, gateways, locality and IP versions. The state tied to the connection is preserved: metadata,
+// watched resources, the resource generator and the workload entry registration.
+func (s *DiscoveryServer) ReinitializeProxy(con *Connection) {
+	con.reinitialize.Store(true)
+}
 
-	proxy.WatchedResources = map[string]*model.WatchedResource{}
-	// Based on node metadata and version, we can associate a different generator.
-	if proxy.Metadata.Generator != "" {
-		proxy.XdsResourceGenerator = s.Generators[proxy.Metadata.Generator]
+// refreshProxy updates the proxy of the connection before a push, reinitializing it if requested.
+func (s *DiscoveryServer) refreshProxy(con *Connection, request *model.PushRequest) {
+	if con.reinitialize.CAS(true, false) {
+		s.computeProxyState(con.proxy, &model.PushRequest{Push: request.Push})
+		setProxyLocality(con.proxy, con.node)
+		con.proxy.DiscoverIPVersions()
+		return
+	}
+	if request.Full {
+		// Update Proxy with current information.
+		s.updateProxy(con.proxy, request)
 	}
-
-	recordXDSClients(proxy.Metadata.IstioVersion, 1)
-	return nil
 }
 
 func (s *DiscoveryServer) updateProxy(proxy *model.Proxy, request *model.PushRequest) {
@@ -691,11 +1057,15 @@ func (s *DiscoveryServer) DeltaAggregatedResources(stream discovery.AggregatedDi
 func (s *DiscoveryServer) pushConnection(con *Connection, pushEv *Event) error {
 	pushRequest := pushEv.pushRequest
 
-	if pushRequest.Full {
-		// Update Proxy with current information.
-		s.updateProxy(con.proxy, pushRequest)
+	// Connections are added once their proxy is set, but AllClients does not promise it, so never
+	// push to a connection that is not initialized.
+	if con.proxy == nil {
+		log.Debugf("Skipping push to %v, connection is not initialized", con.ConID)
+		return nil
 	}
 
+	s.refreshProxy(con, pushRequest)
+
 	if !s.ProxyNeedsPush(con.proxy, pushRequest) {
 		log.Debugf("Skipping push to %v, no updates required", con.ConID)
 		if pushRequest.Full {
@@ -710,6 +1080,10 @@ func (s *DiscoveryServer) pushConnection(con *Connection, pushEv *Event) error {
 	// Send pushes to all generators
 	// Each Generator is responsible for determining if the push event requires a push
 	for _, w := range orderWatchedResources(con.proxy.WatchedResources) {
+		// An empty watch of a non-wildcard type has no resources to push.
+		if len(w.ResourceNames) == 0 && !isWildcardTypeURL(w.TypeUrl) {
+			continue
+		}
 		if !features.EnableFlowControl {
 			// Always send the push if flow control disabled
 			if err := s.pushXds(con, pushRequest.Push, currentVersion, w, pushRequest); err != nil {
@@ -798,6 +1172,27 @@ func reportAllEvents(s DistributionStatusCache, id, version string, ignored map[
 	}
 }
 
+// reserveStream reserves one of the MaxConcurrentStreams slots for a new stream, or rejects the stream if
+// none is left. The slot is taken atomically when the stream is accepted, so concurrent streams cannot
+// exceed the limit while they are being initialized. The returned function releases the slot.
+func (s *DiscoveryServer) reserveStream() (func(), error) {
+	if s.MaxConcurrentStreams <= 0 {
+		return func() {}, nil
+	}
+	if count := s.activeStreams.Inc(); count > int64(s.MaxConcurrentStreams) {
+		s.activeStreams.Dec()
+		log.Warnf("ADS: rejecting stream, %d streams connected (limit %d)", count-1, s.MaxConcurrentStreams)
+		xdsStreamLimitRejections.Increment()
+		return nil, status.Errorf(codes.ResourceExhausted, "too many XDS streams, limit is %d", s.MaxConcurrentStreams)
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.activeStreams.Dec()
+		})
+	}, nil
+}
+
 func (s *DiscoveryServer) adsClientCount() int {
 	s.adsClientsMutex.RLock()
 	defer s.adsClientsMutex.RUnlock()
@@ -805,34 +1200,42 @@ func (s *DiscoveryServer) adsClientCount() int {
 }
 
 func (s *DiscoveryServer) ProxyUpdate(clusterID cluster.ID, ip string) {
-	var connection *Connection
-
+	// IPs are only unique within a cluster, so both must match. A proxy may also have several
+	// connections, e.g. while reconnecting, which all need the push.
+	var connections []*Connection
 	for _, v := range s.Clients() {
-		if v.proxy.Metadata.ClusterID == clusterID && v.proxy.IPAddresses[0] == ip {
-			connection = v
-			break
+		if v.proxy.Metadata.ClusterID == clusterID && hasIPAddress(v.proxy, ip) {
+			connections = append(connections, v)
 		}
 	}
 
 	// It is possible that the envoy has not connected to this pilot, maybe connected to another pilot
-	if connection == nil {
+	if len(connections) == 0 {
 		return
 	}
-	if log.DebugEnabled() {
-		currentlyPending := s.pushQueue.Pending()
-		if currentlyPending != 0 {
-			log.Debugf("Starting new push while %v were still pending", currentlyPending)
-		}
-	}
 
-	s.pushQueue.Enqueue(connection, &model.PushRequest{
-		Full:   true,
-		Push:   s.globalPushContext(),
-		Start:  time.Now(),
-		Reason: []model.TriggerReason{model.ProxyUpdate},
+	targets := sets.NewSet()
+	for _, con := range connections {
+		targets.Insert(model.ProxyTargetKey(clusterID, con.proxy.ID))
+	}
+	s.startPush(&model.PushRequest{
+		Full:    true,
+		Push:    s.globalPushContext(),
+		Reason:  []model.TriggerReason{model.ProxyUpdate},
+		Targets: targets,
 	})
 }
 
+// hasIPAddress returns whether ip is one of the addresses of the proxy.
+func hasIPAddress(proxy *model.Proxy, ip string) bool {
+	for _, addr := range proxy.IPAddresses {
+		if addr == ip {
+			return true
+		}
+	}
+	return false
+}
+
 // AdsPushAll will send updates to all nodes, for a full config or incremental EDS.
 func AdsPushAll(s *DiscoveryServer) {
 	s.AdsPushAll(versionInfo(), &model.PushRequest{
@@ -864,7 +1267,7 @@ func (s *DiscoveryServer) AdsPushAll(version string, req *model.PushRequest) {
 	s.startPush(req)
 }
 
-// Send a signal to all connections, with a push event.
+// Send a signal to all connections, or the connections of req.Targets, with a push event.
 func (s *DiscoveryServer) startPush(req *model.PushRequest) {
 	// Push config changes, iterating over connected envoys.
 	if log.DebugEnabled() {
@@ -874,9 +1277,37 @@ func (s *DiscoveryServer) startPush(req *model.PushRequest) {
 		}
 	}
 	req.Start = time.Now()
-	for _, p := range s.AllClients() {
+	clients := s.AllClients()
+	if len(req.Targets) > 0 {
+		targeted := make([]*Connection, 0, len(req.Targets))
+		for _, p := range clients {
+			// Connections without a proxy cannot be matched against the targets, so they are skipped.
+			if p.proxy != nil && req.Targets.Contains(model.ProxyTargetKey(p.proxy.Metadata.ClusterID, p.proxy.ID)) {
+				targeted = append(targeted, p)
+			}
+		}
+		clients = targeted
+	}
+	delay := s.pushStaggerDelay(len(clients))
+	for i, p := range clients {
+		if delay > 0 && i > 0 && i%s.PushStaggerBatchSize == 0 {
+			// Jitter between half and one and a half of the delay, so that batches from
+			// multiple istiod replicas do not line up.
+			time.Sleep(delay/2 + time.Duration(rand.Int63n(int64(delay))))
+		}
 		s.pushQueue.Enqueue(p, req)
 	}
+	pushFanoutTime.Record(time.Since(req.Start).Seconds())
+}
+
+// pushStaggerDelay returns the average delay between enqueueing two batches of proxies, so
+// that a push to the given number of proxies is spread over PushStaggerWindow.
+func (s *DiscoveryServer) pushStaggerDelay(clients int) time.Duration {
+	if s.PushStaggerWindow <= 0 || s.PushStaggerBatchSize <= 0 || clients <= s.PushStaggerBatchSize {
+		return 0
+	}
+	batches := (clients + s.PushStaggerBatchSize - 1) / s.PushStaggerBatchSize
+	return s.PushStaggerWindow / time.Duration(batches-1)
 }
 
 func (s *DiscoveryServer) addCon(conID string, con *Connection) {
@@ -900,10 +1331,20 @@ func (s *DiscoveryServer) removeCon(conID string) {
 
 // Send with timeout if configured.
 func (conn *Connection) send(res *discovery.DiscoveryResponse) error {
+	// Sent responses are tracked in the watched resources of the proxy.
+	if conn.proxy == nil {
+		return fmt.Errorf("connection %s is not initialized", conn.ConID)
+	}
 	sendHandler := func() error {
+		conn.sendMutex.Lock()
+		defer conn.sendMutex.Unlock()
 		start := time.Now()
 		defer func() { recordSendTime(time.Since(start)) }()
-		return conn.stream.Send(res)
+		if err := conn.stream.Send(res); err != nil {
+			return err
+		}
+		conn.keepLastResponse(res)
+		return nil
 	}
 	err := istiogrpc.Send(conn.stream.Context(), sendHandler)
 	if err == nil {
@@ -917,28 +1358,166 @@ func (conn *Connection) send(res *discovery.DiscoveryResponse) error {
 				conn.proxy.WatchedResources[res.TypeUrl] = &model.WatchedResource{TypeUrl: res.TypeUrl}
 			}
 			conn.proxy.WatchedResources[res.TypeUrl].NonceSent = res.Nonce
-			conn.proxy.WatchedResources[res.TypeUrl].VersionSent = res.VersionInfo
+			conn.proxy.WatchedResources[res.TypeUrl].VersionSent, _ = parseVersionInfo(res.VersionInfo)
 			conn.proxy.WatchedResources[res.TypeUrl].LastSent = time.Now()
 			conn.proxy.WatchedResources[res.TypeUrl].LastSize = sz
 			conn.proxy.Unlock()
 		}
 	} else if status.Convert(err).Code() == codes.DeadlineExceeded {
-		log.Infof("Timeout writing %s", conn.ConID)
+		conn.logger(res.TypeUrl, "version", res.VersionInfo, "nonce", res.Nonce).Info("timeout writing response")
 		xdsResponseWriteTimeouts.Increment()
 	}
 	return err
 }
 
+// keepLastResponse keeps res as the last response of its type for ReplayLast, unless it is too large,
+// in which case the previous response of the type is dropped too. Must be called with sendMutex held.
+func (conn *Connection) keepLastResponse(res *discovery.DiscoveryResponse) {
+	if res.Nonce == "" || strings.HasPrefix(res.TypeUrl, v3.DebugType) {
+		return
+	}
+	sz := 0
+	for _, rc := range res.Resources {
+		sz += len(rc.Value)
+	}
+	if conn.replayMaxSize <= 0 || sz > conn.replayMaxSize {
+		delete(conn.lastResponses, res.TypeUrl)
+		return
+	}
+	if conn.lastResponses == nil {
+		conn.lastResponses = map[string]*discovery.DiscoveryResponse{}
+	}
+	conn.lastResponses[res.TypeUrl] = res
+}
+
+// ReplayLast re-sends the last response sent for the type, with the same version and nonce, e.g. when
+// the config of the proxy is suspected to be corrupted. Unlike a push, nothing is regenerated. ReplayLast
+// fails if there is no kept response for the type, because none was sent yet or it was larger than
+// ReplayMaxSize, or if the connection uses delta XDS.
+func (conn *Connection) ReplayLast(typeURL string) error {
+	if conn.stream == nil {
+		return fmt.Errorf("connection %s does not support replay", conn.ConID)
+	}
+	conn.sendMutex.Lock()
+	res := conn.lastResponses[typeURL]
+	conn.sendMutex.Unlock()
+	if res == nil {
+		return fmt.Errorf("connection %s has no response to replay for %s", conn.ConID, v3.GetShortType(typeURL))
+	}
+	return conn.send(res)
+}
+
+// lastSentHash returns the hash of the resources last sent for the type, if any.
+func (conn *Connection) lastSentHash(typeURL string) string {
+	conn.proxy.RLock()
+	defer conn.proxy.RUnlock()
+	if w := conn.proxy.WatchedResources[typeURL]; w != nil {
+		return w.LastSentHash
+	}
+	return ""
+}
+
+func (conn *Connection) setLastSentHash(typeURL, hash string) {
+	conn.proxy.Lock()
+	defer conn.proxy.Unlock()
+	if w := conn.proxy.WatchedResources[typeURL]; w != nil {
+		w.LastSentHash = hash
+	}
+}
+
+// logger returns the ads scope labeled with the connection, the short type of typeURL and the given
+// key/value pairs, so connection events have consistent fields. Labels are copied on each call, so hot
+// paths should check log.DebugEnabled() before building a debug logger.
+func (conn *Connection) logger(typeURL string, kvs ...interface{}) *istiolog.Scope {
+	return log.WithLabels(append([]interface{}{
+		"conID", conn.ConID, "peerAddr", conn.PeerAddr, "type", v3.GetShortType(typeURL),
+	}, kvs...)...)
+}
+
+// Push sends an unsolicited response with the given resources to the client, outside of the normal push
+// cycle. Generators can use it to deliver resources out of band, e.g. a one-off secret. The response has
+// a fresh nonce, which is tracked in the watched resources like for any other response, so the ACK of the
+// client is handled as usual. As for regular pushes, the nonce is prefixed with the ledger version of the
+// given push context. Push fails if the connection is not initialized, or uses delta XDS.
+func (conn *Connection) Push(push *model.PushContext, typeURL string, resources []*anypb.Any) error {
+	select {
+	case <-conn.initialized:
+	default:
+		return fmt.Errorf("connection %s is not initialized", conn.ConID)
+	}
+	// initialized is also closed when the connection fails to initialize.
+	if conn.proxy == nil {
+		return fmt.Errorf("connection %s has no proxy", conn.ConID)
+	}
+	if conn.stream == nil {
+		return fmt.Errorf("connection %s does not support unsolicited pushes", conn.ConID)
+	}
+	return conn.send(&discovery.DiscoveryResponse{
+		ControlPlane: ControlPlane(),
+		TypeUrl:      typeURL,
+		VersionInfo:  versionInfo(),
+		Nonce:        nonce(push.LedgerVersion),
+		Resources:    resources,
+	})
+}
+
 // nolint
 // Synced checks if the type has been synced, meaning the most recent push was ACKed
 func (conn *Connection) Synced(typeUrl string) (bool, bool) {
 	conn.proxy.RLock()
 	defer conn.proxy.RUnlock()
-	acked := conn.proxy.WatchedResources[typeUrl].NonceAcked
-	sent := conn.proxy.WatchedResources[typeUrl].NonceSent
-	nacked := conn.proxy.WatchedResources[typeUrl].NonceNacked != ""
-	sendTime := conn.proxy.WatchedResources[typeUrl].LastSent
-	return nacked || acked == sent, time.Since(sendTime) > features.FlowControlTimeout
+	w := conn.proxy.WatchedResources[typeUrl]
+	if w == nil {
+		return true, false
+	}
+	return watchSynced(w), time.Since(w.LastSent) > features.FlowControlTimeout
+}
+
+// SyncedTypes returns whether each type watched by the connection has been synced, as per Synced.
+func (conn *Connection) SyncedTypes() map[string]bool {
+	conn.proxy.RLock()
+	defer conn.proxy.RUnlock()
+	out := make(map[string]bool, len(conn.proxy.WatchedResources))
+	for typeURL, w := range conn.proxy.WatchedResources {
+		out[typeURL] = watchSynced(w)
+	}
+	return out
+}
+
+// FullySynced returns true if every type watched by the connection is synced, as per Synced, e.g. to gate
+// readiness. A watched type that was never responded to is not synced, unless there is nothing to send for
+// it, i.e. the empty watch of a non-wildcard type. An uninitialized connection is not synced.
+func (conn *Connection) FullySynced() bool {
+	if conn.proxy == nil {
+		return false
+	}
+	conn.proxy.RLock()
+	defer conn.proxy.RUnlock()
+	for typeURL, w := range conn.proxy.WatchedResources {
+		if w.NonceSent == "" && (len(w.ResourceNames) > 0 || isWildcardTypeURL(typeURL)) {
+			return false
+		}
+		if !watchSynced(w) {
+			return false
+		}
+	}
+	return true
+}
+
+// watchSynced returns true if the most recent push of the watched resource was ACKed or NACKed.
+func watchSynced(w *model.WatchedResource) bool {
+	return w.NonceNacked != "" || w.NonceAcked == w.NonceSent
+}
+
+// watchedTypeURLs returns the type URLs the connection is watching.
+func (conn *Connection) watchedTypeURLs() []string {
+	conn.proxy.RLock()
+	defer conn.proxy.RUnlock()
+	types := make([]string, 0, len(conn.proxy.WatchedResources))
+	for typeURL := range conn.proxy.WatchedResources {
+		types = append(types, typeURL)
+	}
+	return types
 }
 
 // nolint
@@ -999,6 +1578,103 @@ func (conn *Connection) Watched(typeUrl string) *model.WatchedResource {
 	return nil
 }
 
+// WatchedResourcesForName returns the watched resources that explicitly include the given resource name.
+// Wildcard watches, which do not list any resource names, are not included.
+func (conn *Connection) WatchedResourcesForName(name string) []*model.WatchedResource {
+	conn.proxy.RLock()
+	defer conn.proxy.RUnlock()
+	types := conn.watchedResourceIndex[name]
+	out := make([]*model.WatchedResource, 0, len(types))
+	for typeURL := range types {
+		if w := conn.proxy.WatchedResources[typeURL]; w != nil {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// updateWatchedResourceIndex updates the watched resource index after the resource names watched for
+// typeURL changed from previous to current. The caller must hold the proxy lock.
+func (conn *Connection) updateWatchedResourceIndex(typeURL string, previous, current []string) {
+	if conn.watchedResourceIndex == nil {
+		conn.watchedResourceIndex = map[string]sets.Set{}
+	}
+	for _, name := range previous {
+		if types, f := conn.watchedResourceIndex[name]; f {
+			types.Delete(typeURL)
+			if types.Empty() {
+				delete(conn.watchedResourceIndex, name)
+			}
+		}
+	}
+	for _, name := range current {
+		types, f := conn.watchedResourceIndex[name]
+		if !f {
+			types = sets.NewSet()
+			conn.watchedResourceIndex[name] = types
+		}
+		types.Insert(typeURL)
+	}
+}
+
+// resetWatches drops all watches of the connection, including pushes blocked on an ACK. The client is
+// expected to subscribe again, which is handled as an initial request for each type.
+func (conn *Connection) resetWatches() {
+	conn.proxy.Lock()
+	defer conn.proxy.Unlock()
+	conn.proxy.WatchedResources = map[string]*model.WatchedResource{}
+	conn.watchedResourceIndex = nil
+	conn.watchedTypes = nil
+	conn.blockedPushes = map[string]*model.PushRequest{}
+}
+
+// touchWatchedType marks typeURL as the most recently requested type. Must be called with the proxy lock held.
+func (conn *Connection) touchWatchedType(typeURL string) {
+	conn.removeWatchedType(typeURL)
+	conn.watchedTypes = append(conn.watchedTypes, typeURL)
+}
+
+// removeWatchedType forgets typeURL in the request order. Must be called with the proxy lock held.
+func (conn *Connection) removeWatchedType(typeURL string) {
+	for i, t := range conn.watchedTypes {
+		if t == typeURL {
+			conn.watchedTypes = append(conn.watchedTypes[:i], conn.watchedTypes[i+1:]...)
+			return
+		}
+	}
+}
+
+// evictWatchedTypes drops the watches of the least recently requested types until the connection
+// watches at most max types. A max of zero disables eviction. Must be called with the proxy lock held.
+func (conn *Connection) evictWatchedTypes(max int) {
+	for max > 0 && len(conn.proxy.WatchedResources) > max && len(conn.watchedTypes) > 0 {
+		typeURL := conn.watchedTypes[0]
+		conn.watchedTypes = conn.watchedTypes[1:]
+		if w, f := conn.proxy.WatchedResources[typeURL]; f {
+			conn.updateWatchedResourceIndex(typeURL, w.ResourceNames, nil)
+			delete(conn.proxy.WatchedResources, typeURL)
+		}
+		log.Warnf("ADS: %s exceeded %d watched types, dropping watch for %s", conn.ConID, max, typeURL)
+		watchedTypeEvictions.With(typeTag.Value(v3.GetMetricType(typeURL))).Increment()
+	}
+}
+
+// Stop ends the connection, without reporting a reason to the client.
 func (conn *Connection) Stop() {
-	conn.stop <- struct{}{}
+	conn.StopWithStatus(nil)
+}
+
+// StopWithStatus ends the connection, returning st to the client.
+func (conn *Connection) StopWithStatus(st *status.Status) {
+	conn.stop <- st
+}
+
+// stopError logs why the connection was stopped and returns the error ending the stream.
+func (conn *Connection) stopError(st *status.Status) error {
+	if st == nil {
+		log.Infof("ADS: %s stopped", conn.ConID)
+		return nil
+	}
+	log.Infof("ADS: %s stopped: %s %s", conn.ConID, st.Code(), st.Message())
+	return st.Err()
 }
@@ -14,14 +14,28 @@
 package xds_test
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"net"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"go.opencensus.io/stats/view"
+	"go.uber.org/atomic"
 	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
 
 	networking "istio.io/api/networking/v1alpha3"
 	"istio.io/istio/pilot/pkg/features"
@@ -110,6 +124,35 @@ func TestAdsUnsubscribe(t *testing.T) {
 		VersionInfo:   res.VersionInfo,
 	})
 	ads.ExpectNoResponse(t)
+	retry.UntilOrFail(t, func() bool {
+		return s.Discovery.Clients()[0].Watched(v3.EndpointType) == nil
+	}, retry.Timeout(time.Second*5))
+}
+
+func TestAdsInitialEmptyRequest(t *testing.T) {
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{})
+
+	// A client that does not know its resource names yet opens the watch with an empty request.
+	ads := s.ConnectADS().WithType(v3.EndpointType)
+	ads.Request(t, &discovery.DiscoveryRequest{ResourceNames: nil})
+	ads.ExpectNoResponse(t)
+	retry.UntilOrFail(t, func() bool {
+		clients := s.Discovery.Clients()
+		if len(clients) != 1 {
+			return false
+		}
+		w := clients[0].Watched(v3.EndpointType)
+		return w != nil && len(w.ResourceNames) == 0
+	}, retry.Timeout(time.Second*5))
+
+	// The empty watch is not pushed.
+	xds.AdsPushAll(s.Discovery)
+	ads.ExpectNoResponse(t)
+
+	// Adding names later is responded to, and pushed from then on.
+	ads.RequestResponseAck(t, &discovery.DiscoveryRequest{ResourceNames: []string{"fake-cluster"}})
+	xds.AdsPushAll(s.Discovery)
+	ads.ExpectResponse(t)
 }
 
 // Regression for envoy restart and overlapping connections
@@ -130,6 +173,297 @@ func TestAdsReconnect(t *testing.T) {
 	ads2.ExpectResponse(t)
 }
 
+type slowServiceDiscovery struct {
+	model.ServiceDiscovery
+	unblock chan struct{}
+}
+
+func (s *slowServiceDiscovery) GetProxyServiceInstances(proxy *model.Proxy) []*model.ServiceInstance {
+	<-s.unblock
+	return s.ServiceDiscovery.GetProxyServiceInstances(proxy)
+}
+
+func TestAdsInitTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{
+		DiscoveryServerModifier: func(s *xds.DiscoveryServer) {
+			s.InitTimeout = 50 * time.Millisecond
+			s.Env.ServiceDiscovery = &slowServiceDiscovery{ServiceDiscovery: s.Env.ServiceDiscovery, unblock: unblock}
+		},
+	})
+	ads := s.ConnectADS().WithType(v3.ClusterType)
+	ads.Request(t, nil)
+	if err := ads.ExpectError(t); grpcstatus.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+
+	// Once initialization completes, the abandoned connection should be cleaned up.
+	close(unblock)
+	retry.UntilOrFail(t, func() bool {
+		return len(s.Discovery.AllClients()) == 0
+	}, retry.Timeout(time.Second*5))
+}
+
+func TestAdsInvalidNode(t *testing.T) {
+	cases := []struct {
+		name string
+		node *core.Node
+	}{
+		{
+			name: "malformed metadata",
+			node: &core.Node{
+				Id: "sidecar~1.1.1.1~test.default~default.svc.cluster.local",
+				Metadata: &structpb.Struct{Fields: map[string]*structpb.Value{
+					"LABELS": structpb.NewNumberValue(1),
+				}},
+			},
+		},
+		{
+			name: "malformed id",
+			node: &core.Node{Id: "sidecar~1.1.1.1"},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{})
+			ads := s.ConnectADS().WithType(v3.ClusterType)
+			ads.Request(t, &discovery.DiscoveryRequest{Node: tt.node})
+			if err := ads.ExpectError(t); grpcstatus.Code(err) != codes.InvalidArgument {
+				t.Fatalf("expected InvalidArgument, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAdsFirstRequestTimeout(t *testing.T) {
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{
+		DiscoveryServerModifier: func(s *xds.DiscoveryServer) {
+			s.InitTimeout = 50 * time.Millisecond
+		},
+	})
+	timeouts := func() float64 {
+		rows, err := view.RetrieveData("pilot_xds_init_timeouts")
+		if err != nil || len(rows) == 0 {
+			return 0
+		}
+		return rows[0].Data.(*view.SumData).Value
+	}
+	before := timeouts()
+
+	// The client connects but never sends its first request.
+	ads := s.ConnectADS()
+	if err := ads.ExpectError(t); grpcstatus.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	if got := timeouts(); got != before+1 {
+		t.Fatalf("expected init timeouts to be incremented, got %v want %v", got, before+1)
+	}
+	retry.UntilOrFail(t, func() bool {
+		return len(s.Discovery.AllClients()) == 0
+	}, retry.Timeout(time.Second*5))
+}
+
+func TestAdsMaxConcurrentStreams(t *testing.T) {
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{
+		DiscoveryServerModifier: func(s *xds.DiscoveryServer) {
+			s.MaxConcurrentStreams = 1
+		},
+	})
+	rejections := func() float64 {
+		rows, err := view.RetrieveData("pilot_xds_stream_limit_rejections")
+		if err != nil || len(rows) == 0 {
+			return 0
+		}
+		return rows[0].Data.(*view.SumData).Value
+	}
+	before := rejections()
+
+	ads := s.ConnectADS().WithType(v3.ClusterType)
+	ads.RequestResponseAck(t, nil)
+
+	ads2 := s.ConnectADS().WithType(v3.ClusterType)
+	ads2.Request(t, nil)
+	if err := ads2.ExpectError(t); grpcstatus.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+	if got := rejections(); got != before+1 {
+		t.Fatalf("expected stream limit rejections to be incremented, got %v want %v", got, before+1)
+	}
+
+	// Once the first stream is closed, new streams are accepted again.
+	ads.Cleanup()
+	retry.UntilOrFail(t, func() bool {
+		return len(s.Discovery.AllClients()) == 0
+	}, retry.Timeout(time.Second*5))
+	s.ConnectADS().WithType(v3.ClusterType).RequestResponseAck(t, nil)
+}
+
+type streamMetadataGenerator struct {
+	streamMetadata chan map[string][]string
+}
+
+func (g *streamMetadataGenerator) Generate(proxy *model.Proxy, _ *model.PushContext, _ *model.WatchedResource,
+	_ *model.PushRequest) (model.Resources, model.XdsLogDetails, error) {
+	select {
+	case g.streamMetadata <- proxy.StreamMetadata:
+	default:
+	}
+	return nil, model.DefaultXdsLogDetails, nil
+}
+
+func TestAdsStreamMetadata(t *testing.T) {
+	const typeURL = "type.googleapis.com/istio.test.StreamMetadata"
+	gen := &streamMetadataGenerator{streamMetadata: make(chan map[string][]string, 1)}
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{
+		DiscoveryServerModifier: func(s *xds.DiscoveryServer) {
+			s.Generators[typeURL] = gen
+		},
+	})
+	conn, err := grpc.Dial("buffcon", grpc.WithInsecure(), grpc.WithBlock(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return s.BufListener.Dial()
+	}))
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	ads := xds.NewXdsTest(t, conn, func(conn *grpc.ClientConn) (xds.DiscoveryClient, error) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "client-version", "1.2.3")
+		return discovery.NewAggregatedDiscoveryServiceClient(conn).StreamAggregatedResources(ctx)
+	}).WithType(typeURL)
+	ads.Request(t, nil)
+
+	select {
+	case md := <-gen.streamMetadata:
+		if got := md["client-version"]; !reflect.DeepEqual(got, []string{"1.2.3"}) {
+			t.Fatalf("expected client-version metadata, got %v", md)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("generator was not called")
+	}
+}
+
+type contentGenerator struct {
+	content *atomic.String
+}
+
+func (g *contentGenerator) Generate(_ *model.Proxy, _ *model.PushContext, _ *model.WatchedResource,
+	_ *model.PushRequest) (model.Resources, model.XdsLogDetails, error) {
+	return model.Resources{{
+		Name:     "content",
+		Resource: &anypb.Any{TypeUrl: "type.googleapis.com/istio.test.Content", Value: []byte(g.content.Load())},
+	}}, model.DefaultXdsLogDetails, nil
+}
+
+func TestAdsSkipUnchangedPushes(t *testing.T) {
+	const typeURL = "type.googleapis.com/istio.test.Content"
+	gen := &contentGenerator{content: atomic.NewString("v1")}
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{
+		DiscoveryServerModifier: func(s *xds.DiscoveryServer) {
+			s.SkipUnchangedPushes = true
+			s.Generators[typeURL] = gen
+		},
+	})
+	ads := s.ConnectADS().WithType(typeURL)
+	ads.RequestResponseAck(t, nil)
+
+	// Stable content is not sent again.
+	xds.AdsPushAll(s.Discovery)
+	ads.ExpectNoResponse(t)
+
+	// Changed content is sent.
+	gen.content.Store("v2")
+	xds.AdsPushAll(s.Discovery)
+	if res := ads.ExpectResponse(t); string(res.Resources[0].Value) != "v2" {
+		t.Fatalf("expected changed content to be pushed, got %v", res.Resources)
+	}
+}
+
+type notModifiedGenerator struct {
+	contentGenerator
+	skip *atomic.Bool
+}
+
+func (g *notModifiedGenerator) Generate(proxy *model.Proxy, push *model.PushContext, w *model.WatchedResource,
+	req *model.PushRequest) (model.Resources, model.XdsLogDetails, error) {
+	if g.skip.Load() {
+		return nil, model.NotModified("test skip"), nil
+	}
+	return g.contentGenerator.Generate(proxy, push, w, req)
+}
+
+func TestAdsNotModified(t *testing.T) {
+	const typeURL = "type.googleapis.com/istio.test.NotModified"
+	gen := &notModifiedGenerator{contentGenerator: contentGenerator{content: atomic.NewString("v1")}, skip: atomic.NewBool(false)}
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{
+		DiscoveryServerModifier: func(s *xds.DiscoveryServer) {
+			s.Generators[typeURL] = gen
+		},
+	})
+	skipped := func() float64 {
+		rows, err := view.RetrieveData("pilot_xds_skipped_pushes")
+		if err != nil {
+			return 0
+		}
+		for _, row := range rows {
+			labels := map[string]string{}
+			for _, t := range row.Tags {
+				labels[t.Key.Name()] = t.Value
+			}
+			if labels["type"] == typeURL && labels["reason"] == "test skip" {
+				return row.Data.(*view.SumData).Value
+			}
+		}
+		return 0
+	}
+	ads := s.ConnectADS().WithType(typeURL)
+	ads.RequestResponseAck(t, nil)
+	if got := skipped(); got != 0 {
+		t.Fatalf("expected no skipped pushes, got %v", got)
+	}
+
+	gen.skip.Store(true)
+	xds.AdsPushAll(s.Discovery)
+	ads.ExpectNoResponse(t)
+	if got := skipped(); got != 1 {
+		t.Fatalf("expected the skipped push to be recorded, got %v", got)
+	}
+}
+
+func TestAdsReconnectBootID(t *testing.T) {
+	cases := []struct {
+		name           string
+		bootID         string
+		expectResponse bool
+	}{
+		{"same boot", "boot-1", false},
+		{"new boot", "boot-2", true},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{})
+			ads := s.ConnectADS().WithType(v3.ClusterType).WithMetadata(model.NodeMetadata{BootID: "boot-1"})
+			res := ads.RequestResponseAck(t, nil)
+			retry.UntilOrFail(t, s.Discovery.AllSynced, retry.Timeout(time.Second*5))
+			ads.Cleanup()
+			retry.UntilOrFail(t, func() bool {
+				return len(s.Discovery.AllClients()) == 0
+			}, retry.Timeout(time.Second*5))
+
+			// The proxy reconnects, resuming from the response it ACKed.
+			ads = s.ConnectADS().WithType(v3.ClusterType).WithMetadata(model.NodeMetadata{BootID: tt.bootID})
+			ads.Request(t, &discovery.DiscoveryRequest{VersionInfo: res.VersionInfo, ResponseNonce: res.Nonce})
+			if tt.expectResponse {
+				ads.ExpectResponse(t)
+			} else {
+				ads.ExpectNoResponse(t)
+			}
+
+			// Further pushes are sent regardless.
+			xds.AdsPushAll(s.Discovery)
+			ads.ExpectResponse(t)
+		})
+	}
+}
+
 // Regression for connection with a bad ID
 func TestAdsBadId(t *testing.T) {
 	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{})
@@ -815,6 +1149,27 @@ func TestEnvoyRDSProtocolError(t *testing.T) {
 	})
 }
 
+func TestAdsCrossTypeNonce(t *testing.T) {
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{})
+	ads := s.ConnectADS().WithType(v3.ClusterType)
+	cds := ads.RequestResponseAck(t, nil)
+	rds := ads.WithType(v3.RouteType).RequestResponseAck(t, &discovery.DiscoveryRequest{ResourceNames: []string{routeA}})
+	if cds.Nonce == rds.Nonce {
+		t.Fatalf("expected responses of a push to have distinct nonces, got %s", cds.Nonce)
+	}
+
+	// Echoing the nonce of another type is a stale nonce, even if it was sent in the same push.
+	ads.Request(t, &discovery.DiscoveryRequest{ResourceNames: []string{routeA, routeB}, ResponseNonce: cds.Nonce})
+	ads.ExpectNoResponse(t)
+	retry.UntilOrFail(t, func() bool {
+		clients := s.Discovery.Clients()
+		return len(clients) == 1 && clients[0].NonceAcked(v3.RouteType) == rds.Nonce
+	}, retry.Timeout(time.Second*5))
+	if got := s.Discovery.Clients()[0].Watched(v3.RouteType).ResourceNames; !reflect.DeepEqual(got, []string{routeA}) {
+		t.Fatalf("expected the route watch to be unchanged, got %v", got)
+	}
+}
+
 func TestBlockedPush(t *testing.T) {
 	original := features.EnableFlowControl
 	t.Cleanup(func() {
@@ -1020,3 +1375,212 @@ func TestEdsCache(t *testing.T) {
 	assertEndpoints(ads)
 	t.Logf("endpoints: %+v", ads.GetEndpoints())
 }
+
+func TestMaxWatchedTypes(t *testing.T) {
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{})
+	s.Discovery.MaxWatchedTypes = 2
+	ads := s.ConnectADS()
+
+	ads.WithType(v3.ClusterType).RequestResponseAck(t, nil)
+	ads.WithType(v3.ListenerType).RequestResponseAck(t, nil)
+	// Request clusters again, so listeners become the least recently requested type.
+	ads.WithType(v3.ClusterType).RequestResponseAck(t, nil)
+	ads.WithType(v3.RouteType).RequestResponseAck(t, &discovery.DiscoveryRequest{ResourceNames: []string{routeA}})
+
+	retry.UntilOrFail(t, func() bool {
+		clients := s.Discovery.AllClients()
+		if len(clients) != 1 {
+			return false
+		}
+		con := clients[0]
+		return con.Watched(v3.ClusterType) != nil && con.Watched(v3.RouteType) != nil && con.Watched(v3.ListenerType) == nil
+	}, retry.Timeout(time.Second*5))
+}
+
+func TestResetWatches(t *testing.T) {
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{})
+	ads := s.ConnectADS().WithType(v3.ClusterType)
+	initial := ads.RequestResponseAck(t, nil)
+
+	ads.WithType(v3.ResetType).Request(t, nil)
+	ads.ExpectNoResponse(t)
+	retry.UntilOrFail(t, func() bool {
+		clients := s.Discovery.AllClients()
+		return len(clients) == 1 && clients[0].Watched(v3.ClusterType) == nil
+	}, retry.Timeout(time.Second*5))
+
+	// Without the reset, this would be handled as an ACK of the initial response and not be answered.
+	resp := ads.WithType(v3.ClusterType).RequestResponseAck(t, &discovery.DiscoveryRequest{
+		VersionInfo:   initial.VersionInfo,
+		ResponseNonce: initial.Nonce,
+	})
+	if len(resp.Resources) != len(initial.Resources) {
+		t.Fatalf("expected a full response with %d clusters, got %d", len(initial.Resources), len(resp.Resources))
+	}
+}
+
+func TestProxyUpdateMatchesCluster(t *testing.T) {
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{})
+	// Both proxies have the default IP and ID of the test node, 1.1.1.1 and test.default.
+	ads1 := s.ConnectADS().WithMetadata(model.NodeMetadata{ClusterID: "cluster-1"}).WithType(v3.ClusterType)
+	ads2 := s.ConnectADS().WithMetadata(model.NodeMetadata{ClusterID: "cluster-2"}).WithType(v3.ClusterType)
+	ads1.RequestResponseAck(t, nil)
+	ads2.RequestResponseAck(t, nil)
+	retry.UntilOrFail(t, func() bool {
+		return len(s.Discovery.Clients()) == 2
+	}, retry.Timeout(time.Second*5))
+	for _, con := range s.Discovery.Clients() {
+		if !strings.HasPrefix(con.ConID, "test.default-") {
+			t.Fatalf("expected both proxies to have the ID test.default, got connection %s", con.ConID)
+		}
+	}
+
+	s.Discovery.ProxyUpdate("cluster-1", "1.1.1.1")
+	ads1.ExpectResponse(t)
+	ads2.ExpectNoResponse(t)
+
+	s.Discovery.ProxyUpdate("cluster-2", "1.1.1.1")
+	ads2.ExpectResponse(t)
+	ads1.ExpectNoResponse(t)
+
+	s.Discovery.ProxyUpdate("cluster-3", "1.1.1.1")
+	ads1.ExpectNoResponse(t)
+	ads2.ExpectNoResponse(t)
+}
+
+func TestConnectionPush(t *testing.T) {
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{})
+	ads := s.ConnectADS().WithType(v3.ClusterType)
+	ads.RequestResponseAck(t, nil)
+	retry.UntilOrFail(t, func() bool {
+		return len(s.Discovery.Clients()) == 1
+	}, retry.Timeout(time.Second*5))
+	con := s.Discovery.Clients()[0]
+	previous := con.NonceSent(v3.ClusterType)
+
+	resource, err := anypb.New(&cluster.Cluster{Name: "out-of-band"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	push := &model.PushContext{LedgerVersion: "ledger-version"}
+	if err := con.Push(push, v3.ClusterType, []*anypb.Any{resource}); err != nil {
+		t.Fatal(err)
+	}
+	res := ads.ExpectResponse(t)
+	if res.Nonce == previous {
+		t.Fatalf("expected a fresh nonce, got %s", res.Nonce)
+	}
+	if !strings.HasPrefix(res.Nonce, push.LedgerVersion) {
+		t.Fatalf("expected nonce %s to be prefixed with the ledger version %s", res.Nonce, push.LedgerVersion)
+	}
+	if len(res.Resources) != 1 || !proto.Equal(res.Resources[0], resource) {
+		t.Fatalf("unexpected resources %v", res.Resources)
+	}
+	if got := con.NonceSent(v3.ClusterType); got != res.Nonce {
+		t.Fatalf("expected watched nonce %s, got %s", res.Nonce, got)
+	}
+}
+
+func TestConnectionReplayLast(t *testing.T) {
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{})
+	ads := s.ConnectADS().WithType(v3.ClusterType)
+	last := ads.RequestResponseAck(t, nil)
+	retry.UntilOrFail(t, func() bool {
+		return len(s.Discovery.Clients()) == 1
+	}, retry.Timeout(time.Second*5))
+	con := s.Discovery.Clients()[0]
+
+	if err := con.ReplayLast(v3.ListenerType); err == nil {
+		t.Fatalf("expected an error replaying a type that was never sent")
+	}
+	if err := con.ReplayLast(v3.ClusterType); err != nil {
+		t.Fatal(err)
+	}
+	res := ads.ExpectResponse(t)
+	if res.Nonce != last.Nonce || res.VersionInfo != last.VersionInfo {
+		t.Fatalf("expected nonce %s and version %s, got %s and %s", last.Nonce, last.VersionInfo, res.Nonce, res.VersionInfo)
+	}
+	if len(res.Resources) != len(last.Resources) {
+		t.Fatalf("expected %d resources, got %d", len(last.Resources), len(res.Resources))
+	}
+	for i := range res.Resources {
+		if !bytes.Equal(res.Resources[i].Value, last.Resources[i].Value) {
+			t.Fatalf("resource %d differs from the last response", i)
+		}
+	}
+}
+
+func TestConnectionReplayLastTooLarge(t *testing.T) {
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{})
+	s.Discovery.ReplayMaxSize = 1
+	ads := s.ConnectADS().WithType(v3.ClusterType)
+	ads.RequestResponseAck(t, nil)
+	retry.UntilOrFail(t, func() bool {
+		return len(s.Discovery.Clients()) == 1
+	}, retry.Timeout(time.Second*5))
+
+	if err := s.Discovery.Clients()[0].ReplayLast(v3.ClusterType); err == nil {
+		t.Fatalf("expected an error replaying a response larger than the maximum size")
+	}
+}
+
+func TestMemoryStream(t *testing.T) {
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{})
+	stream := xdstest.NewMemoryStream(context.Background(), t)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Discovery.Stream(stream)
+	}()
+
+	node := &core.Node{
+		Id:       "sidecar~1.1.1.1~test.default~default.svc.cluster.local",
+		Metadata: model.NodeMetadata{}.ToStruct(),
+	}
+	stream.RecvQueue <- &discovery.DiscoveryRequest{Node: node, TypeUrl: v3.ClusterType}
+	res := stream.ExpectResponse(t)
+	if res.TypeUrl != v3.ClusterType || len(res.Resources) == 0 {
+		t.Fatalf("unexpected response %v", res)
+	}
+
+	// An ACK is not responded to.
+	stream.RecvQueue <- &discovery.DiscoveryRequest{Node: node, TypeUrl: v3.ClusterType, ResponseNonce: res.Nonce}
+	stream.ExpectNoResponse(t)
+
+	// Neither is a NACK.
+	stream.RecvQueue <- &discovery.DiscoveryRequest{
+		Node: node, TypeUrl: v3.ClusterType, ResponseNonce: res.Nonce,
+		ErrorDetail: &status.Status{Message: "Test request NACK"},
+	}
+	stream.ExpectNoResponse(t)
+
+	// A request for a new type is.
+	stream.RecvQueue <- &discovery.DiscoveryRequest{Node: node, TypeUrl: v3.ListenerType}
+	if res := stream.ExpectResponse(t); res.TypeUrl != v3.ListenerType {
+		t.Fatalf("expected a listener response, got %v", res.TypeUrl)
+	}
+
+	close(stream.RecvQueue)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected the stream to end cleanly, got %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("stream did not end")
+	}
+}
+
+func TestConnectionStopWithStatus(t *testing.T) {
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{})
+	ads := s.ConnectADS().WithType(v3.ClusterType)
+	ads.RequestResponseAck(t, nil)
+	retry.UntilOrFail(t, func() bool {
+		return len(s.Discovery.Clients()) == 1
+	}, retry.Timeout(time.Second*5))
+
+	s.Discovery.Clients()[0].StopWithStatus(grpcstatus.New(codes.ResourceExhausted, "too many connections"))
+	err := ads.ExpectError(t)
+	if got := grpcstatus.Code(err); got != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+}
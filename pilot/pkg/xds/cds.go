@@ -71,7 +71,7 @@ func cdsNeedsPush(req *model.PushRequest, proxy *model.Proxy) bool {
 func (c CdsGenerator) Generate(proxy *model.Proxy, push *model.PushContext, w *model.WatchedResource,
 	req *model.PushRequest) (model.Resources, model.XdsLogDetails, error) {
 	if !cdsNeedsPush(req, proxy) {
-		return nil, model.DefaultXdsLogDetails, nil
+		return nil, model.NotModified("no relevant updates"), nil
 	}
 	clusters, logs := c.Server.ConfigGenerator.BuildClusters(proxy, req)
 	return clusters, logs, nil
@@ -32,6 +32,8 @@ import (
 	"github.com/golang/protobuf/jsonpb"
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes/any"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/anypb"
 
 	"istio.io/istio/pilot/pkg/config/kube/crd"
@@ -98,6 +100,14 @@ type AdsClient struct {
 	ConnectedAt  time.Time           `json:"connectedAt"`
 	PeerAddress  string              `json:"address"`
 	Watches      map[string][]string `json:"watches,omitempty"`
+	// Rejections holds, per type URL, the last rejection of the client that was not followed by an ACK.
+	Rejections map[string]AdsRejection `json:"rejections,omitempty"`
+}
+
+// AdsRejection is the error reported by a client when it rejected (NACKed) the config it was sent.
+type AdsRejection struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
 // AdsClients is collection of AdsClient connected to this Istiod.
@@ -183,6 +193,8 @@ func (s *DiscoveryServer) AddDebugHandlers(mux, internalMux *http.ServeMux, enab
 	s.addDebugHandler(mux, internalMux, "/debug/registryz", "Debug support for registry", s.registryz)
 	s.addDebugHandler(mux, internalMux, "/debug/endpointz", "Debug support for endpoints", s.endpointz)
 	s.addDebugHandler(mux, internalMux, "/debug/endpointShardz", "Info about the endpoint shards", s.endpointShardz)
+	s.addDebugHandler(mux, internalMux, "/debug/endpointShardz?shard=", "Endpoints contributed by a single shard (registry)", s.endpointShardz)
+	s.addDebugHandler(mux, internalMux, "/debug/endpointShardz?counts=true", "Number of endpoints of each shard (registry)", s.endpointShardz)
 	s.addDebugHandler(mux, internalMux, "/debug/cachez", "Info about the internal XDS caches", s.cachez)
 	s.addDebugHandler(mux, internalMux, "/debug/cachez?sizes=true", "Info about the size of the internal XDS caches", s.cachez)
 	s.addDebugHandler(mux, internalMux, "/debug/configz", "Debug support for config", s.configz)
@@ -194,6 +206,10 @@ func (s *DiscoveryServer) AddDebugHandlers(mux, internalMux *http.ServeMux, enab
 	s.addDebugHandler(mux, internalMux, "/debug/telemetryz", "Debug Telemetry configuration", s.telemetryz)
 	s.addDebugHandler(mux, internalMux, "/debug/config_dump", "ConfigDump in the form of the Envoy admin config dump API for passed in proxyID", s.ConfigDump)
 	s.addDebugHandler(mux, internalMux, "/debug/push_status", "Last PushContext Details", s.PushStatusHandler)
+	s.addDebugHandler(mux, internalMux, "/debug/duplicated_domains", "Most recent virtual hosts rejected due to duplicate domains",
+		s.duplicatedDomainsz)
+	s.addDebugHandler(mux, internalMux, "/debug/metric_details", "Most recent details of the push context metrics, e.g. conflicting listeners",
+		s.metricDetailsz)
 	s.addDebugHandler(mux, internalMux, "/debug/pushcontext", "Debug support for current push context", s.PushContextHandler)
 	s.addDebugHandler(mux, internalMux, "/debug/connections", "Info about the connected XDS clients", s.ConnectionsHandler)
 
@@ -296,6 +312,16 @@ func (s *DiscoveryServer) registryz(w http.ResponseWriter, req *http.Request) {
 // the full push.
 func (s *DiscoveryServer) endpointShardz(w http.ResponseWriter, req *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
+	if req.URL.Query().Get("counts") != "" {
+		out, _ := json.MarshalIndent(s.ShardEndpointCounts(), " ", " ")
+		_, _ = w.Write(out)
+		return
+	}
+	if shard := req.URL.Query().Get("shard"); shard != "" {
+		out, _ := json.MarshalIndent(s.EndpointsForShard(shard), " ", " ")
+		_, _ = w.Write(out)
+		return
+	}
 	s.mutex.RLock()
 	out, _ := json.MarshalIndent(s.EndpointShardsByService, " ", " ")
 	s.mutex.RUnlock()
@@ -533,6 +559,12 @@ func (s *DiscoveryServer) adsz(w http.ResponseWriter, req *http.Request) {
 				r = []string{}
 			}
 			adsClient.Watches[k] = r
+			if wr.LastError != "" {
+				if adsClient.Rejections == nil {
+					adsClient.Rejections = map[string]AdsRejection{}
+				}
+				adsClient.Rejections[k] = AdsRejection{Code: wr.LastErrorCode.String(), Message: wr.LastError}
+			}
 		}
 		c.proxy.RUnlock()
 		adsClients.Connected = append(adsClients.Connected, adsClient)
@@ -794,7 +826,7 @@ func (s *DiscoveryServer) ForceDisconnect(w http.ResponseWriter, req *http.Reque
 	if con == nil {
 		return
 	}
-	con.Stop()
+	con.StopWithStatus(status.New(codes.Unavailable, "disconnected via debug endpoint"))
 	_, _ = w.Write([]byte("OK"))
 }
 
@@ -826,6 +858,21 @@ func (s *DiscoveryServer) networkz(w http.ResponseWriter, _ *http.Request) {
 	writeJSON(w, mgr.AllGateways())
 }
 
+// duplicatedDomainsz lists the most recent virtual hosts counted by the pilot_vservice_dup_domain metric.
+func (s *DiscoveryServer) duplicatedDomainsz(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, model.RecentDuplicatedDomains())
+}
+
+// metricDetailsz lists the most recent details of the push context metrics, optionally of a single metric.
+func (s *DiscoveryServer) metricDetailsz(w http.ResponseWriter, req *http.Request) {
+	details := model.RecentMetricDetails()
+	if metric := req.URL.Query().Get("metric"); metric != "" {
+		writeJSON(w, details[metric])
+		return
+	}
+	writeJSON(w, details)
+}
+
 func (s *DiscoveryServer) exportz(w http.ResponseWriter, _ *http.Request) {
 	aggregateController, ok := s.Env.ServiceDiscovery.(*aggregate.Controller)
 	if !ok {
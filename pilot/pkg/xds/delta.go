@@ -23,6 +23,7 @@ import (
 	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	"github.com/golang/protobuf/jsonpb"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 
@@ -32,6 +33,7 @@ import (
 	"istio.io/istio/pilot/pkg/networking/util"
 	"istio.io/istio/pilot/pkg/util/sets"
 	v3 "istio.io/istio/pilot/pkg/xds/v3"
+	"istio.io/istio/pkg/config/schema/gvk"
 )
 
 func (s *DiscoveryServer) StreamDeltas(stream DeltaDiscoveryStream) error {
@@ -54,6 +56,11 @@ func (s *DiscoveryServer) StreamDeltas(stream DeltaDiscoveryStream) error {
 	if !s.IsServerReady() {
 		return errors.New("server is not ready to serve discovery information")
 	}
+	releaseStream, err := s.reserveStream()
+	if err != nil {
+		return err
+	}
+	defer releaseStream()
 
 	ctx := stream.Context()
 	peerAddr := "0.0.0.0"
@@ -79,7 +86,9 @@ func (s *DiscoveryServer) StreamDeltas(stream DeltaDiscoveryStream) error {
 		return err
 	}
 	con := newDeltaConnection(peerAddr, stream)
+	con.releaseStream = releaseStream
 	con.Identities = ids
+	con.streamMetadata, _ = metadata.FromIncomingContext(ctx)
 
 	// Do not call: defer close(con.pushChannel). The push channel will be garbage collected
 	// when the connection is no longer used. Closing the channel can cause subtle race conditions
@@ -90,12 +99,12 @@ func (s *DiscoveryServer) StreamDeltas(stream DeltaDiscoveryStream) error {
 	// We need 2 go routines because 'read' blocks in Recv().
 	go s.receiveDelta(con)
 
-	// Wait for the proxy to be fully initialized before we start serving traffic. Because
-	// initialization doesn't have dependencies that will block, there is no need to add any timeout
-	// here. Prior to this explicit wait, we were implicitly waiting by receive() not sending to
-	// reqChannel and the connection not being enqueued for pushes to pushChannel until the
-	// initialization is complete.
-	<-con.initialized
+	// Wait for the proxy to be fully initialized before we start serving traffic. Prior to this
+	// explicit wait, we were implicitly waiting by receive() not sending to reqChannel and the
+	// connection not being enqueued for pushes to pushChannel until the initialization is complete.
+	if err := s.waitForInitialization(con); err != nil {
+		return err
+	}
 
 	for {
 		select {
@@ -117,8 +126,8 @@ func (s *DiscoveryServer) StreamDeltas(stream DeltaDiscoveryStream) error {
 			if err != nil {
 				return err
 			}
-		case <-con.stop:
-			return nil
+		case st := <-con.stop:
+			return con.stopError(st)
 		}
 	}
 }
@@ -128,11 +137,15 @@ func (s *DiscoveryServer) StreamDeltas(stream DeltaDiscoveryStream) error {
 func (s *DiscoveryServer) pushConnectionDelta(con *Connection, pushEv *Event) error {
 	pushRequest := pushEv.pushRequest
 
-	if pushRequest.Full {
-		// Update Proxy with current information.
-		s.updateProxy(con.proxy, pushRequest)
+	// Connections are added once their proxy is set, but AllClients does not promise it, so never
+	// push to a connection that is not initialized.
+	if con.proxy == nil {
+		log.Debugf("Skipping push to %v, connection is not initialized", con.ConID)
+		return nil
 	}
 
+	s.refreshProxy(con, pushRequest)
+
 	if !s.ProxyNeedsPush(con.proxy, pushRequest) {
 		log.Debugf("Skipping push to %v, no updates required", con.ConID)
 		if pushRequest.Full {
@@ -149,7 +162,7 @@ func (s *DiscoveryServer) pushConnectionDelta(con *Connection, pushEv *Event) er
 	for _, w := range orderWatchedResources(con.proxy.WatchedResources) {
 		if !features.EnableFlowControl {
 			// Always send the push if flow control disabled
-			if err := s.pushDeltaXds(con, pushRequest.Push, currentVersion, w, nil, pushRequest); err != nil {
+			if err := s.pushDeltaConfigUpdate(con, currentVersion, w, pushRequest); err != nil {
 				return err
 			}
 			continue
@@ -165,7 +178,7 @@ func (s *DiscoveryServer) pushConnectionDelta(con *Connection, pushEv *Event) er
 		}
 		if synced || timeout {
 			// Send the push now
-			if err := s.pushDeltaXds(con, pushRequest.Push, currentVersion, w, nil, pushRequest); err != nil {
+			if err := s.pushDeltaConfigUpdate(con, currentVersion, w, pushRequest); err != nil {
 				return err
 			}
 		} else {
@@ -189,6 +202,46 @@ func (s *DiscoveryServer) pushConnectionDelta(con *Connection, pushEv *Event) er
 	return nil
 }
 
+// pushDeltaConfigUpdate pushes the watched resources of the given type affected by the push request. When
+// the affected resources can be derived from the updated configs, only these are sent.
+func (s *DiscoveryServer) pushDeltaConfigUpdate(con *Connection, currentVersion string, w *model.WatchedResource,
+	req *model.PushRequest) error {
+	var subscribe []string
+	if delta, ok := configsResourceDelta(con, w, req); ok {
+		if delta.Subscribed.Empty() {
+			// None of the watched resources is affected.
+			if s.StatusReporter != nil {
+				s.StatusReporter.RegisterEvent(con.ConID, w.TypeUrl, req.Push.LedgerVersion)
+			}
+			return nil
+		}
+		subscribe = delta.Subscribed.SortedList()
+	}
+	return s.pushDeltaXds(con, req.Push, currentVersion, w, subscribe, req)
+}
+
+// configsResourceDelta translates the configs updated by req into the watched resources of the connection to
+// send again, as the Subscribed resources of the delta. Removed resources are detected when generating the
+// response. It returns false if the resources cannot be derived from the configs, in which case all watched
+// resources are pushed. Currently only secrets map to resource names.
+func configsResourceDelta(con *Connection, w *model.WatchedResource, req *model.PushRequest) (model.ResourceDelta, bool) {
+	if w.TypeUrl != v3.SecretType || len(req.ConfigsUpdated) == 0 {
+		return model.ResourceDelta{}, false
+	}
+	updated := model.ConfigsOfKind(req.ConfigsUpdated, gvk.Secret)
+	delta := model.ResourceDelta{Subscribed: sets.NewSet()}
+	for _, resource := range w.ResourceNames {
+		sr, err := parseResourceName(resource, con.proxy.ConfigNamespace, string(con.proxy.Metadata.ClusterID))
+		if err != nil {
+			continue
+		}
+		if containsAny(updated, relatedConfigs(model.ConfigKey{Kind: gvk.Secret, Name: sr.Name, Namespace: sr.Namespace})) {
+			delta.Subscribed.Insert(resource)
+		}
+	}
+	return delta, true
+}
+
 func (s *DiscoveryServer) receiveDelta(con *Connection) {
 	defer func() {
 		close(con.deltaReqChan)
@@ -222,7 +275,7 @@ func (s *DiscoveryServer) receiveDelta(con *Connection) {
 			}
 			// TODO: We should validate that the namespace in the cert matches the claimed namespace in metadata.
 			if err := s.initConnection(req.Node, con); err != nil {
-				con.errorChan <- err
+				con.errorChan <- initConnectionStatus(err)
 				return
 			}
 			defer s.closeConnection(con)
@@ -280,6 +333,11 @@ func (s *DiscoveryServer) processDeltaRequest(req *discovery.DeltaDiscoveryReque
 			TypeUrl: req.TypeUrl, ResourceNames: req.ResourceNamesSubscribe,
 		}, &model.PushRequest{Full: true})
 	}
+	if req.TypeUrl == v3.ResetType {
+		log.Debugf("dADS: RESET %s", con.ConID)
+		con.resetWatches()
+		return nil
+	}
 	if s.StatusReporter != nil {
 		s.StatusReporter.RegisterEvent(con.ConID, req.TypeUrl, req.ResponseNonce)
 	}
@@ -328,7 +386,12 @@ func (s *DiscoveryServer) shouldRespondDelta(con *Connection, request *discovery
 			s.StatusGen.OnNack(con.proxy, deltaToSotwRequest(request))
 		}
 		con.proxy.Lock()
-		con.proxy.WatchedResources[request.TypeUrl].NonceNacked = request.ResponseNonce
+		// The watch may have been evicted, see evictWatchedTypes.
+		if w, f := con.proxy.WatchedResources[request.TypeUrl]; f {
+			w.NonceNacked = request.ResponseNonce
+			w.LastError = request.ErrorDetail.GetMessage()
+			w.LastErrorCode = errCode
+		}
 		con.proxy.Unlock()
 		return false
 	}
@@ -345,11 +408,15 @@ func (s *DiscoveryServer) shouldRespondDelta(con *Connection, request *discovery
 		// TODO: can we distinguish init and reconnect? Do we care?
 		log.Debugf("dADS:%s: INIT/RECONNECT %s %s", stype, con.ConID, request.ResponseNonce)
 		con.proxy.Lock()
+		resourceNames := deltaWatchedResources(nil, request)
+		con.updateWatchedResourceIndex(request.TypeUrl, nil, resourceNames)
 		con.proxy.WatchedResources[request.TypeUrl] = &model.WatchedResource{
 			TypeUrl:       request.TypeUrl,
-			ResourceNames: deltaWatchedResources(nil, request),
+			ResourceNames: resourceNames,
 			LastRequest:   deltaToSotwRequest(request),
 		}
+		con.touchWatchedType(request.TypeUrl)
+		con.evictWatchedTypes(s.MaxWatchedTypes)
 		con.proxy.Unlock()
 		return true
 	}
@@ -375,11 +442,21 @@ func (s *DiscoveryServer) shouldRespondDelta(con *Connection, request *discovery
 	con.proxy.WatchedResources[request.TypeUrl].VersionAcked = ""
 	con.proxy.WatchedResources[request.TypeUrl].NonceAcked = request.ResponseNonce
 	con.proxy.WatchedResources[request.TypeUrl].NonceNacked = ""
-	con.proxy.WatchedResources[request.TypeUrl].ResourceNames = deltaWatchedResources(previousResources, request)
+	if request.ResponseNonce != "" {
+		con.proxy.WatchedResources[request.TypeUrl].LastError = ""
+		con.proxy.WatchedResources[request.TypeUrl].LastErrorCode = codes.OK
+	}
+	currentResources := deltaWatchedResources(previousResources, request)
+	con.proxy.WatchedResources[request.TypeUrl].ResourceNames = currentResources
 	con.proxy.WatchedResources[request.TypeUrl].LastRequest = deltaToSotwRequest(request)
+	oldAck := listEqualUnordered(s.CanonicalizeResourceNames(request.TypeUrl, previousResources),
+		s.CanonicalizeResourceNames(request.TypeUrl, currentResources))
+	if !oldAck {
+		con.updateWatchedResourceIndex(request.TypeUrl, previousResources, currentResources)
+	}
+	con.touchWatchedType(request.TypeUrl)
 	con.proxy.Unlock()
 
-	oldAck := listEqualUnordered(previousResources, con.proxy.WatchedResources[request.TypeUrl].ResourceNames)
 	newAck := request.ResponseNonce != ""
 	if newAck != oldAck {
 		// Not sure which is better, lets just log if they don't match for now and compare.
@@ -395,7 +472,7 @@ func (s *DiscoveryServer) shouldRespondDelta(con *Connection, request *discovery
 		return false
 	}
 	log.Debugf("dADS:%s: RESOURCE CHANGE previous resources: %v, new resources: %v %s %s", stype,
-		previousResources, con.proxy.WatchedResources[request.TypeUrl].ResourceNames, con.ConID, request.ResponseNonce)
+		previousResources, currentResources, con.ConID, request.ResponseNonce)
 
 	return true
 }
@@ -415,7 +492,11 @@ func (s *DiscoveryServer) pushDeltaXds(con *Connection, push *model.PushContext,
 
 	t0 := time.Now()
 
+	req = stablePushRequest(req, push)
 	res, logdata, err := gen.Generate(con.proxy, push, w, req)
+	if err == nil && logdata.NotModified {
+		reportSkippedPush(con, w.TypeUrl, logdata.AdditionalInfo)
+	}
 	if err != nil || res == nil {
 		// If we have nothing to send, report that we got an ACK for this version.
 		if s.StatusReporter != nil {
@@ -424,6 +505,7 @@ func (s *DiscoveryServer) pushDeltaXds(con *Connection, push *model.PushContext,
 		return err
 	}
 	defer func() { recordPushTime(w.TypeUrl, time.Since(t0)) }()
+	res = s.transformResources(con.proxy, w.TypeUrl, res)
 
 	originalNames := extractNames(res)
 	if subscribe != nil {
@@ -465,10 +547,16 @@ func (s *DiscoveryServer) pushDeltaXds(con *Connection, push *model.PushContext,
 	configSize := ResourceSize(res)
 	configSizeBytes.With(typeTag.Value(w.TypeUrl)).Record(float64(configSize))
 
-	if err := con.sendDelta(resp); err != nil {
+	s.beforePush(con, w)
+	err = con.sendDelta(resp)
+	s.afterPush(con, w, err)
+	if err != nil {
 		recordSendError(w.TypeUrl, con.ConID, err)
 		return err
 	}
+	if s.LogPushPayloads && log.DebugEnabled() {
+		logPushPayload(con, w.TypeUrl, res)
+	}
 
 	ptype := "PUSH"
 	info := ""
@@ -502,7 +590,7 @@ func newDeltaConnection(peerAddr string, stream DeltaDiscoveryStream) *Connectio
 	return &Connection{
 		pushChannel:   make(chan *Event),
 		initialized:   make(chan struct{}),
-		stop:          make(chan struct{}),
+		stop:          make(chan *status.Status),
 		PeerAddr:      peerAddr,
 		Connect:       time.Now(),
 		deltaStream:   stream,
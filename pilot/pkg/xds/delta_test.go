@@ -21,8 +21,10 @@ import (
 	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 
 	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/util/sets"
 	v3 "istio.io/istio/pilot/pkg/xds/v3"
 	"istio.io/istio/pilot/test/xdstest"
+	"istio.io/istio/pkg/config/schema/gvk"
 )
 
 func TestDeltaAds(t *testing.T) {
@@ -53,3 +55,43 @@ func TestDeltaAdsClusterUpdate(t *testing.T) {
 	// TODO: should we just respond with nothing here? Probably...
 	sendEDSReqAndVerify(nil, []string{"outbound|81||local.default.svc.cluster.local"}, []string{"outbound|80||local.default.svc.cluster.local"})
 }
+
+func TestConfigsResourceDelta(t *testing.T) {
+	connection := func(namespace string) *Connection {
+		return &Connection{proxy: &model.Proxy{ConfigNamespace: namespace, Metadata: &model.NodeMetadata{}}}
+	}
+	secrets := &model.WatchedResource{
+		TypeUrl:       v3.SecretType,
+		ResourceNames: []string{"kubernetes://a", "kubernetes://b", "kubernetes://b-cacert"},
+	}
+	secretUpdate := &model.PushRequest{Full: true, ConfigsUpdated: map[model.ConfigKey]struct{}{
+		{Kind: gvk.Secret, Name: "b", Namespace: "ns1"}: {},
+	}}
+
+	cases := []struct {
+		name string
+		con  *Connection
+		w    *model.WatchedResource
+		req  *model.PushRequest
+		want []string
+		ok   bool
+	}{
+		{"affected secrets", connection("ns1"), secrets, secretUpdate, []string{"kubernetes://b", "kubernetes://b-cacert"}, true},
+		{"other namespace", connection("ns2"), secrets, secretUpdate, []string{}, true},
+		{"other secret", connection("ns1"), &model.WatchedResource{TypeUrl: v3.SecretType, ResourceNames: []string{"kubernetes://a"}},
+			secretUpdate, []string{}, true},
+		{"unknown configs", connection("ns1"), secrets, &model.PushRequest{Full: true}, nil, false},
+		{"other type", connection("ns1"), &model.WatchedResource{TypeUrl: v3.ClusterType}, secretUpdate, nil, false},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			delta, ok := configsResourceDelta(tt.con, tt.w, tt.req)
+			if ok != tt.ok {
+				t.Fatalf("expected ok %v, got %v", tt.ok, ok)
+			}
+			if ok && !delta.Subscribed.Equals(sets.NewSet(tt.want...)) {
+				t.Fatalf("expected resources %v, got %v", tt.want, delta.Subscribed.SortedList())
+			}
+		})
+	}
+}
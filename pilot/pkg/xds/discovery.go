@@ -15,7 +15,9 @@
 package xds
 
 import (
+	"fmt"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -91,6 +93,19 @@ type DiscoveryServer struct {
 	// may also choose to not send any updates.
 	ProxyNeedsPush func(proxy *model.Proxy, req *model.PushRequest) bool
 
+	// ResourceTransformer, if set, post-processes the resources generated for a proxy before they are sent.
+	// Generated resources may be shared with other proxies through the cache, so the transformer must
+	// return modified copies rather than mutate them in place.
+	ResourceTransformer func(proxy *model.Proxy, typeURL string, res model.Resources) model.Resources
+
+	// BeforePush and AfterPush, if set, are called immediately before and after a generated response is
+	// sent to a connection, for example to coordinate listener drain timers or cluster warming. AfterPush
+	// is passed the result of the send.
+	BeforePush func(con *Connection, w *model.WatchedResource)
+	AfterPush  func(con *Connection, w *model.WatchedResource, err error)
+
+	// concurrentPushLimit bounds the number of connections generating and sending a push concurrently,
+	// see doSendPushes. Its capacity is configured with PILOT_PUSH_THROTTLE.
 	concurrentPushLimit chan struct{}
 
 	// InboundUpdates describes the number of configuration updates the discovery server has received
@@ -108,10 +123,27 @@ type DiscoveryServer struct {
 	// incremental updates. This is keyed by service and namespace
 	EndpointShardsByService map[string]map[string]*EndpointShards
 
+	// pendingEDSFlushes tracks, per shard, the services updated with EDSCacheUpdate since the last FlushEDS.
+	// Only the shards in flushedEDSShards, which opted in with EnableEDSFlush, are tracked.
+	pendingEDSFlushes      map[string]*edsFlush
+	flushedEDSShards       map[string]struct{}
+	pendingEDSFlushesMutex sync.Mutex
+
+	// shardEndpoints is the total number of endpoints contributed by each shard, across all services.
+	// It backs the pilot_endpoints_per_shard gauge.
+	shardEndpoints      map[string]int
+	shardEndpointsMutex sync.Mutex
+
 	// pushChannel is the buffer used for debouncing.
 	// after debouncing the pushRequest will be sent to pushQueue
 	pushChannel chan *model.PushRequest
 
+	// overflow is the merge of the config updates that did not fit in pushChannel. It is sent to
+	// pushChannel by handleUpdates, signaled through overflowCh, so ConfigUpdate never blocks.
+	overflow      *model.PushRequest
+	overflowMutex sync.Mutex
+	overflowCh    chan struct{}
+
 	// mutex used for protecting Environment.PushContext
 	updateMutex sync.RWMutex
 
@@ -125,6 +157,11 @@ type DiscoveryServer struct {
 	adsClients      map[string]*Connection
 	adsClientsMutex sync.RWMutex
 
+	// incarnations holds, by proxy ID, the state of disconnected proxies that provided a boot ID. It is
+	// reset on full pushes, after which the state of the proxies is outdated.
+	incarnations      map[string]*proxyIncarnation
+	incarnationsMutex sync.Mutex
+
 	StatusReporter DistributionStatusCache
 
 	// Authenticators for XDS requests. Should be same/subset of the CA authenticators.
@@ -137,8 +174,53 @@ type DiscoveryServer struct {
 	// serverReady indicates caches have been synced up and server is ready to process requests.
 	serverReady atomic.Bool
 
+	// activeStreams counts the streams holding one of the MaxConcurrentStreams slots.
+	activeStreams atomic.Int64
+
+	// readinessProbes must all pass, in addition to caches being synced, for the server to be ready.
+	readinessProbes      []func() bool
+	readinessProbesMutex sync.RWMutex
+
 	debounceOptions debounceOptions
 
+	// InitTimeout is the maximum time a new connection waits for its proxy to be initialized
+	// before the stream is rejected. Zero means wait indefinitely.
+	InitTimeout time.Duration
+
+	// PushStaggerWindow, if non-zero, is the time over which a push is spread across the connected
+	// proxies. Proxies are enqueued in batches of PushStaggerBatchSize, with a jittered delay between batches.
+	PushStaggerWindow    time.Duration
+	PushStaggerBatchSize int
+
+	// MaxWatchedTypes caps the number of distinct types a connection may watch, evicting the least
+	// recently requested type when exceeded. Zero means no limit.
+	MaxWatchedTypes int
+
+	// MaxConcurrentStreams caps the number of connected XDS streams, rejecting new streams with
+	// ResourceExhausted when reached. Zero means no limit.
+	MaxConcurrentStreams int
+
+	// SkipUnchangedPushes, if set, skips pushes whose resources are the same as the last response sent for
+	// the type. Responses to requests of the proxy are always sent.
+	SkipUnchangedPushes bool
+
+	// ReplayMaxSize is the maximum size in bytes of the last response per type kept on each connection
+	// for Connection.ReplayLast. Zero means no responses are kept.
+	ReplayMaxSize int
+
+	// LogPushPayloads, if set, logs the resources of each push at debug level, redacting secrets.
+	LogPushPayloads bool
+
+	// ResourceNameCanonicalizers, keyed by type URL, normalize equivalent resource names before requested
+	// resource names are compared, so cosmetic differences do not trigger a push. The requested names
+	// themselves are kept unchanged. Types without canonicalizer are compared as is.
+	ResourceNameCanonicalizers map[string]func(names []string) []string
+
+	// SecretLess, if set, orders the secrets of an SDS response by resource name, so critical secrets, e.g.
+	// the serving certificate of a gateway, come before the CA roots and Envoy can start serving sooner.
+	// By default, secrets are ordered by name.
+	SecretLess func(a, b string) bool
+
 	instanceID string
 
 	// Cache for XDS resources
@@ -182,6 +264,7 @@ func NewDiscoveryServer(env *model.Environment, plugins []string, instanceID str
 		InboundUpdates:          atomic.NewInt64(0),
 		CommittedUpdates:        atomic.NewInt64(0),
 		pushChannel:             make(chan *model.PushRequest, 10),
+		overflowCh:              make(chan struct{}, 1),
 		pushQueue:               NewPushQueue(),
 		debugHandlers:           map[string]string{},
 		adsClients:              map[string]*Connection{},
@@ -190,6 +273,17 @@ func NewDiscoveryServer(env *model.Environment, plugins []string, instanceID str
 			debounceMax:       features.DebounceMax,
 			enableEDSDebounce: features.EnableEDSDebounce,
 		},
+		InitTimeout:          features.XDSInitTimeout,
+		PushStaggerWindow:    features.PushStaggerWindow,
+		PushStaggerBatchSize: features.PushStaggerBatchSize,
+		MaxWatchedTypes:      features.MaxWatchedTypes,
+		MaxConcurrentStreams: features.MaxConcurrentXDSStreams,
+		SkipUnchangedPushes:  features.SkipUnchangedXDSPushes,
+		ReplayMaxSize:        features.XDSReplayMaxSize,
+		LogPushPayloads:      features.LogXDSPushPayloads,
+		ResourceNameCanonicalizers: map[string]func([]string) []string{
+			v3.SecretType: canonicalSecretNames,
+		},
 		Cache:      model.DisabledCache{},
 		instanceID: instanceID,
 	}
@@ -244,8 +338,27 @@ func (s *DiscoveryServer) CachesSynced() {
 	s.serverReady.Store(true)
 }
 
+// IsServerReady returns true once caches have been synced and all the readiness probes pass.
 func (s *DiscoveryServer) IsServerReady() bool {
-	return s.serverReady.Load()
+	if !s.serverReady.Load() {
+		return false
+	}
+	s.readinessProbesMutex.RLock()
+	defer s.readinessProbesMutex.RUnlock()
+	for _, probe := range s.readinessProbes {
+		if !probe() {
+			return false
+		}
+	}
+	return true
+}
+
+// AddReadinessProbe adds a condition that must hold for the server to be ready, e.g. that a config
+// store backing the server has synced. New streams are rejected until every probe passes.
+func (s *DiscoveryServer) AddReadinessProbe(probe func() bool) {
+	s.readinessProbesMutex.Lock()
+	defer s.readinessProbesMutex.Unlock()
+	s.readinessProbes = append(s.readinessProbes, probe)
 }
 
 func (s *DiscoveryServer) Start(stopCh <-chan struct{}) {
@@ -281,9 +394,11 @@ func (s *DiscoveryServer) getNonK8sRegistries() []serviceregistry.Instance {
 func (s *DiscoveryServer) periodicRefreshMetrics(stopCh <-chan struct{}) {
 	ticker := time.NewTicker(periodicRefreshMetrics)
 	defer ticker.Stop()
+	unsyncedTypes := map[string]struct{}{}
 	for {
 		select {
 		case <-ticker.C:
+			s.recordUnsyncedConnections(unsyncedTypes)
 			push := s.globalPushContext()
 			model.LastPushMutex.Lock()
 			if model.LastPushStatus != push {
@@ -301,6 +416,52 @@ func (s *DiscoveryServer) periodicRefreshMetrics(stopCh <-chan struct{}) {
 	}
 }
 
+// AllSynced returns true if every type watched by the initialized connections has been synced, meaning
+// the most recent push was ACKed or NACKed. It can be used to wait for pushes to settle before draining.
+func (s *DiscoveryServer) AllSynced() bool {
+	for _, con := range s.Clients() {
+		for _, synced := range con.SyncedTypes() {
+			if !synced {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// unsyncedConnections returns, per type URL, the number of connections with a response that has been
+// neither ACKed nor NACKed within features.FlowControlTimeout, per Connection.Synced.
+func (s *DiscoveryServer) unsyncedConnections() map[string]int {
+	out := map[string]int{}
+	for _, con := range s.Clients() {
+		for _, typeURL := range con.watchedTypeURLs() {
+			if synced, timeout := con.Synced(typeURL); !synced && timeout {
+				out[typeURL]++
+			}
+		}
+	}
+	return out
+}
+
+// recordUnsyncedConnections updates the unsynced connections gauge. reported holds the metric types
+// recorded previously, so that types which no longer have unsynced connections are reset to zero.
+func (s *DiscoveryServer) recordUnsyncedConnections(reported map[string]struct{}) {
+	counts := map[string]int{}
+	for typeURL, n := range s.unsyncedConnections() {
+		counts[v3.GetMetricType(typeURL)] += n
+	}
+	for metricType := range reported {
+		if _, f := counts[metricType]; !f {
+			unsyncedConnections.With(typeTag.Value(metricType)).Record(0)
+			delete(reported, metricType)
+		}
+	}
+	for metricType, n := range counts {
+		unsyncedConnections.With(typeTag.Value(metricType)).Record(float64(n))
+		reported[metricType] = struct{}{}
+	}
+}
+
 // dropCacheForRequest clears the cache in response to a push request
 func (s *DiscoveryServer) dropCacheForRequest(req *model.PushRequest) {
 	// If we don't know what updated, cannot safely cache. Clear the whole cache
@@ -321,6 +482,8 @@ func (s *DiscoveryServer) Push(req *model.PushRequest) {
 		s.AdsPushAll(versionInfo(), req)
 		return
 	}
+	// The full push covers the endpoint updates waiting to be flushed.
+	s.clearPendingEDSFlushes()
 	// Reset the status during the push.
 	oldPushContext := s.globalPushContext()
 	if oldPushContext != nil {
@@ -342,6 +505,7 @@ func (s *DiscoveryServer) Push(req *model.PushRequest) {
 	versionMutex.Lock()
 	version = versionLocal
 	versionMutex.Unlock()
+	s.resetIncarnations()
 
 	req.Push = push
 	s.AdsPushAll(versionLocal, req)
@@ -357,7 +521,35 @@ func versionInfo() string {
 	return version
 }
 
+// versionReasonSeparator separates the push version from the push reasons in the version info of XDS responses.
+const versionReasonSeparator = ";"
+
+// versionInfoWithReason returns the version info to send in a response for the given push. If
+// features.XDSVersionInfoReason is enabled, the reasons of the push are appended to the version.
+func versionInfoWithReason(version string, req *model.PushRequest) string {
+	if !features.XDSVersionInfoReason || req == nil || len(req.Reason) == 0 {
+		return version
+	}
+	reasons := sets.NewSet()
+	for _, r := range req.Reason {
+		reasons.Insert(string(r))
+	}
+	return version + versionReasonSeparator + strings.Join(reasons.SortedList(), ",")
+}
+
+// parseVersionInfo splits a version info built by versionInfoWithReason, as echoed back by proxies,
+// into the push version and reasons.
+func parseVersionInfo(versionInfo string) (version string, reason string) {
+	if i := strings.LastIndex(versionInfo, versionReasonSeparator); i >= 0 {
+		return versionInfo[:i], versionInfo[i+len(versionReasonSeparator):]
+	}
+	return versionInfo, ""
+}
+
 // Returns the global push context.
+// globalPushContext returns the current global PushContext. It may be replaced by a
+// concurrent push at any time, so callers should fetch it once and use that reference
+// for the whole push.
 func (s *DiscoveryServer) globalPushContext() *model.PushContext {
 	s.updateMutex.RLock()
 	defer s.updateMutex.RUnlock()
@@ -369,7 +561,66 @@ func (s *DiscoveryServer) globalPushContext() *model.PushContext {
 func (s *DiscoveryServer) ConfigUpdate(req *model.PushRequest) {
 	inboundConfigUpdates.Increment()
 	s.InboundUpdates.Inc()
-	s.pushChannel <- req
+	select {
+	case s.pushChannel <- req:
+		pushChannelDepth.Record(float64(len(s.pushChannel)))
+		return
+	default:
+	}
+	// The channel is full. Do not block the caller, usually a registry event handler, and merge the
+	// update into the overflow request instead.
+	pushChannelOverflows.Increment()
+	s.overflowMutex.Lock()
+	if s.overflow != nil {
+		// debounce commits the overflow request as a single update once it is pushed, so the updates
+		// folded into it are committed here, keeping CommittedUpdates in line with InboundUpdates.
+		s.CommittedUpdates.Inc()
+	}
+	s.overflow = s.overflow.Merge(req)
+	s.overflowMutex.Unlock()
+	select {
+	case s.overflowCh <- struct{}{}:
+	default:
+	}
+}
+
+// ConfigUpdateAndWait requests a push like ConfigUpdate, and waits until the push has been delivered
+// to all the connections present when it started, or the timeout elapses. Connections closing in the
+// meantime are not waited for.
+// Completion is tracked through CommittedUpdates, so this requires incremental pushes to be debounced
+// (PILOT_ENABLE_EDS_DEBOUNCE), which is the default.
+func (s *DiscoveryServer) ConfigUpdateAndWait(req *model.PushRequest, timeout time.Duration) error {
+	s.ConfigUpdate(req)
+	inbound := s.InboundUpdates.Load()
+	deadline := time.Now().Add(timeout)
+	wait := func(done func() bool) bool {
+		for !done() {
+			if time.Now().After(deadline) {
+				return false
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+		return true
+	}
+
+	// Wait for the push including the update to be enqueued for all connections.
+	if !wait(func() bool { return s.CommittedUpdates.Load() >= inbound }) {
+		return fmt.Errorf("timed out after %v waiting for the push to start", timeout)
+	}
+	pushes := s.pushQueue.enqueuedPushes(s.AllClients())
+	if !wait(func() bool {
+		s.adsClientsMutex.RLock()
+		for con := range pushes {
+			if s.adsClients[con.ConID] != con {
+				delete(pushes, con)
+			}
+		}
+		s.adsClientsMutex.RUnlock()
+		return s.pushQueue.delivered(pushes)
+	}) {
+		return fmt.Errorf("timed out after %v waiting for the push to be delivered", timeout)
+	}
+	return nil
 }
 
 // Debouncing and push request happens in a separate thread, it uses locks
@@ -378,9 +629,33 @@ func (s *DiscoveryServer) ConfigUpdate(req *model.PushRequest) {
 // It ensures that at minimum minQuiet time has elapsed since the last event before processing it.
 // It also ensures that at most maxDelay is elapsed between receiving an event and processing it.
 func (s *DiscoveryServer) handleUpdates(stopCh <-chan struct{}) {
+	go s.drainOverflow(stopCh)
 	debounce(s.pushChannel, stopCh, s.debounceOptions, s.Push, s.CommittedUpdates)
 }
 
+// drainOverflow sends the overflow request to pushChannel whenever ConfigUpdate merged an update into it.
+func (s *DiscoveryServer) drainOverflow(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-s.overflowCh:
+			s.overflowMutex.Lock()
+			req := s.overflow
+			s.overflow = nil
+			s.overflowMutex.Unlock()
+			if req == nil {
+				continue
+			}
+			select {
+			case s.pushChannel <- req:
+			case <-stopCh:
+				return
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
 // The debounce helper function is implemented to enable mocking
 func debounce(ch chan *model.PushRequest, stopCh <-chan struct{}, opts debounceOptions, pushFn func(req *model.PushRequest), updateSent *atomic.Int64) {
 	var timeChan <-chan time.Time
@@ -429,6 +704,7 @@ func debounce(ch chan *model.PushRequest, stopCh <-chan struct{}, opts debounceO
 			free = true
 			pushWorker()
 		case r := <-ch:
+			pushChannelDepth.Record(float64(len(ch)))
 			// If reason is not set, record it as an unknown reason
 			if len(r.Reason) == 0 {
 				r.Reason = []model.TriggerReason{model.UnknownTrigger}
@@ -457,6 +733,10 @@ func debounce(ch chan *model.PushRequest, stopCh <-chan struct{}, opts debounceO
 	}
 }
 
+// doSendPushes dispatches the pushes of the queue to the connections. At most cap(semaphore) pushes are
+// in flight at any time: a push holds a slot from the moment it is dequeued until the connection has
+// generated and sent it, and the queue is not read while all slots are taken. startPush therefore only
+// enqueues, and the parallelism of config generation does not grow with the number of connections.
 func doSendPushes(stopCh <-chan struct{}, semaphore chan struct{}, queue *PushQueue) {
 	for {
 		select {
@@ -54,7 +54,7 @@ func ecdsNeedsPush(req *model.PushRequest) bool {
 func (e *EcdsGenerator) Generate(proxy *model.Proxy, push *model.PushContext, w *model.WatchedResource,
 	req *model.PushRequest) (model.Resources, model.XdsLogDetails, error) {
 	if !ecdsNeedsPush(req) {
-		return nil, model.DefaultXdsLogDetails, nil
+		return nil, model.NotModified("no relevant updates"), nil
 	}
 	ec := e.Server.ConfigGenerator.BuildExtensionConfiguration(proxy, push, w.ResourceNames)
 	if ec == nil {
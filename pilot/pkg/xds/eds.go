@@ -16,6 +16,7 @@ package xds
 
 import (
 	"fmt"
+	"sort"
 
 	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
@@ -85,6 +86,12 @@ func (s *DiscoveryServer) SvcUpdate(cluster, hostname string, namespace string,
 	}
 }
 
+// SvcUpdateBatch processes the service updates of a shard. Like SvcUpdate, it does not request a
+// push: callers should issue a single ConfigUpdate, e.g. using model.ServiceChangesConfigKeys.
+func (s *DiscoveryServer) SvcUpdateBatch(shard string, updates []model.ServiceChange) {
+	model.SvcUpdateEach(s, shard, updates)
+}
+
 // EDSUpdate computes destination address membership across all clusters and networks.
 // This is the main method implementing EDS.
 // It replaces InstancesByPort in model - instead of iterating over all endpoints it uses
@@ -118,7 +125,80 @@ func (s *DiscoveryServer) EDSCacheUpdate(shard, serviceName string, namespace st
 	istioEndpoints []*model.IstioEndpoint) {
 	inboundEDSUpdates.Increment()
 	// Update the endpoint shards
-	s.edsCacheUpdate(shard, serviceName, namespace, istioEndpoints)
+	fp := s.edsCacheUpdate(shard, serviceName, namespace, istioEndpoints)
+	// Remember the update, so it is pushed by the next FlushEDS of the shard
+	s.pendingEDSFlushesMutex.Lock()
+	defer s.pendingEDSFlushesMutex.Unlock()
+	if _, f := s.flushedEDSShards[shard]; !f {
+		return
+	}
+	if s.pendingEDSFlushes == nil {
+		s.pendingEDSFlushes = map[string]*edsFlush{}
+	}
+	pending, f := s.pendingEDSFlushes[shard]
+	if !f {
+		pending = &edsFlush{configsUpdated: map[model.ConfigKey]struct{}{}}
+		s.pendingEDSFlushes[shard] = pending
+	}
+	pending.full = pending.full || fp
+	pending.configsUpdated[model.ConfigKey{Kind: gvk.ServiceEntry, Name: serviceName, Namespace: namespace}] = struct{}{}
+}
+
+// edsFlush accumulates the endpoint cache updates of a shard until they are flushed.
+type edsFlush struct {
+	full           bool
+	configsUpdated map[model.ConfigKey]struct{}
+}
+
+// EnableEDSFlush opts the shard in to batched flushing: its updates with EDSCacheUpdate are tracked
+// until FlushEDS is called for it. Registries which push their updates otherwise, e.g. through
+// ConfigUpdate, should not opt in.
+func (s *DiscoveryServer) EnableEDSFlush(shard string) {
+	s.pendingEDSFlushesMutex.Lock()
+	defer s.pendingEDSFlushesMutex.Unlock()
+	if s.flushedEDSShards == nil {
+		s.flushedEDSShards = map[string]struct{}{}
+	}
+	s.flushedEDSShards[shard] = struct{}{}
+}
+
+// FlushEDS triggers a single push for all the services of the shard updated with EDSCacheUpdate
+// since the last flush. It is a no-op if there were no such updates, or if the shard did not opt in
+// with EnableEDSFlush.
+func (s *DiscoveryServer) FlushEDS(shard string) {
+	s.pendingEDSFlushesMutex.Lock()
+	pending, f := s.pendingEDSFlushes[shard]
+	delete(s.pendingEDSFlushes, shard)
+	s.pendingEDSFlushesMutex.Unlock()
+	if !f {
+		return
+	}
+	s.ConfigUpdate(&model.PushRequest{
+		Full:           pending.full,
+		ConfigsUpdated: pending.configsUpdated,
+		Reason:         []model.TriggerReason{model.EndpointUpdate},
+	})
+}
+
+// dropPendingEDSFlush forgets the pending update of the service in the shard, e.g. once it is deleted.
+func (s *DiscoveryServer) dropPendingEDSFlush(shard, hostname, namespace string) {
+	s.pendingEDSFlushesMutex.Lock()
+	defer s.pendingEDSFlushesMutex.Unlock()
+	pending, f := s.pendingEDSFlushes[shard]
+	if !f {
+		return
+	}
+	delete(pending.configsUpdated, model.ConfigKey{Kind: gvk.ServiceEntry, Name: hostname, Namespace: namespace})
+	if len(pending.configsUpdated) == 0 {
+		delete(s.pendingEDSFlushes, shard)
+	}
+}
+
+// clearPendingEDSFlushes forgets the pending updates of all the shards, e.g. when a full push covers them.
+func (s *DiscoveryServer) clearPendingEDSFlushes() {
+	s.pendingEDSFlushesMutex.Lock()
+	defer s.pendingEDSFlushesMutex.Unlock()
+	s.pendingEDSFlushes = nil
 }
 
 // edsCacheUpdate updates EndpointShards data by clusterID, hostname, IstioEndpoints.
@@ -146,6 +226,7 @@ func (s *DiscoveryServer) edsCacheUpdate(shard string, hostname string, namespac
 	}
 
 	ep.mutex.Lock()
+	s.updateShardEndpoints(shard, len(istioEndpoints)-len(ep.Shards[shard]))
 	ep.Shards[shard] = istioEndpoints
 	// Check if ServiceAccounts have changed. We should do a full push if they have changed.
 	saUpdated := s.UpdateServiceAccount(ep, hostname)
@@ -205,6 +286,7 @@ func (s *DiscoveryServer) deleteEndpointShards(shard string, serviceName, namesp
 		s.EndpointShardsByService[serviceName][namespace] != nil {
 		epShards := s.EndpointShardsByService[serviceName][namespace]
 		epShards.mutex.Lock()
+		s.updateShardEndpoints(shard, -len(epShards.Shards[shard]))
 		delete(epShards.Shards, shard)
 		// Clear the cache here to avoid race in cache writes (see edsCacheUpdate for details).
 		s.Cache.Clear(map[model.ConfigKey]struct{}{{
@@ -219,6 +301,8 @@ func (s *DiscoveryServer) deleteEndpointShards(shard string, serviceName, namesp
 // deleteService deletes all service related references from EndpointShardsByService. This is called
 // when a service is deleted.
 func (s *DiscoveryServer) deleteService(cluster, serviceName, namespace string) {
+	s.dropPendingEDSFlush(cluster, serviceName, namespace)
+
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
@@ -226,6 +310,7 @@ func (s *DiscoveryServer) deleteService(cluster, serviceName, namespace string)
 		s.EndpointShardsByService[serviceName][namespace] != nil {
 		epShards := s.EndpointShardsByService[serviceName][namespace]
 		epShards.mutex.Lock()
+		s.updateShardEndpoints(cluster, -len(epShards.Shards[cluster]))
 		delete(epShards.Shards, cluster)
 		shardsLen := len(epShards.Shards)
 		s.UpdateServiceAccount(epShards, serviceName)
@@ -245,6 +330,74 @@ func (s *DiscoveryServer) deleteService(cluster, serviceName, namespace string)
 	}
 }
 
+// updateShardEndpoints adjusts the endpoint count of the given shard by delta and records it in the
+// pilot_endpoints_per_shard gauge. Callers must hold the lock of the EndpointShards being modified.
+func (s *DiscoveryServer) updateShardEndpoints(shard string, delta int) {
+	if delta == 0 {
+		return
+	}
+	s.shardEndpointsMutex.Lock()
+	defer s.shardEndpointsMutex.Unlock()
+	if s.shardEndpoints == nil {
+		s.shardEndpoints = map[string]int{}
+	}
+	count := s.shardEndpoints[shard] + delta
+	if count <= 0 {
+		delete(s.shardEndpoints, shard)
+		count = 0
+	} else {
+		s.shardEndpoints[shard] = count
+	}
+	endpointsPerShard.With(shardTag.Value(shard)).Record(float64(count))
+}
+
+// EndpointsForShard returns the endpoints contributed by the given shard, keyed by "namespace/hostname".
+// The returned map and slices are copies, so they can be safely read while EDS updates are applied concurrently.
+func (s *DiscoveryServer) EndpointsForShard(shard string) map[string][]*model.IstioEndpoint {
+	out := map[string][]*model.IstioEndpoint{}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for hostname, byNamespace := range s.EndpointShardsByService {
+		for namespace, epShards := range byNamespace {
+			epShards.mutex.RLock()
+			if eps, f := epShards.Shards[shard]; f {
+				out[namespace+"/"+hostname] = append(make([]*model.IstioEndpoint, 0, len(eps)), eps...)
+			}
+			epShards.mutex.RUnlock()
+		}
+	}
+	return out
+}
+
+// ShardEndpointCounts returns the number of endpoints of each shard, including shards that currently have no
+// endpoints. It can be safely called while EDS updates are applied concurrently.
+func (s *DiscoveryServer) ShardEndpointCounts() map[string]int {
+	out := map[string]int{}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, byNamespace := range s.EndpointShardsByService {
+		for _, epShards := range byNamespace {
+			epShards.mutex.RLock()
+			for shard, eps := range epShards.Shards {
+				out[shard] += len(eps)
+			}
+			epShards.mutex.RUnlock()
+		}
+	}
+	return out
+}
+
+// Shards returns the sorted names of the shards with endpoint entries for at least one service.
+func (s *DiscoveryServer) Shards() []string {
+	counts := s.ShardEndpointCounts()
+	out := make([]string, 0, len(counts))
+	for shard := range counts {
+		out = append(out, shard)
+	}
+	sort.Strings(out)
+	return out
+}
+
 // UpdateServiceAccount updates the service endpoints' sa when service/endpoint event happens.
 // Note: it is not concurrent safe.
 func (s *DiscoveryServer) UpdateServiceAccount(shards *EndpointShards, serviceName string) bool {
@@ -376,7 +529,7 @@ func edsNeedsPush(updates model.XdsUpdates) bool {
 func (eds *EdsGenerator) Generate(proxy *model.Proxy, push *model.PushContext, w *model.WatchedResource,
 	req *model.PushRequest) (model.Resources, model.XdsLogDetails, error) {
 	if !edsNeedsPush(req.ConfigsUpdated) {
-		return nil, model.DefaultXdsLogDetails, nil
+		return nil, model.NotModified("no relevant updates"), nil
 	}
 	var edsUpdatedServices map[string]struct{}
 	if !req.Full {
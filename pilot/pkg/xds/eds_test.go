@@ -437,6 +437,67 @@ func TestDeleteService(t *testing.T) {
 	}
 }
 
+func TestEndpointsForShard(t *testing.T) {
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{})
+	s.Discovery.EDSCacheUpdate("c1", "a.com", "ns", []*model.IstioEndpoint{{Address: "10.0.0.1"}, {Address: "10.0.0.2"}})
+	s.Discovery.EDSCacheUpdate("c1", "b.com", "ns", []*model.IstioEndpoint{{Address: "10.0.0.3"}})
+	s.Discovery.EDSCacheUpdate("c2", "a.com", "ns", []*model.IstioEndpoint{{Address: "10.1.0.1"}})
+
+	c1 := s.Discovery.EndpointsForShard("c1")
+	if len(c1) != 2 || len(c1["ns/a.com"]) != 2 || len(c1["ns/b.com"]) != 1 {
+		t.Fatalf("unexpected endpoints for shard c1: %v", c1)
+	}
+	c2 := s.Discovery.EndpointsForShard("c2")
+	if len(c2) != 1 || len(c2["ns/a.com"]) != 1 || c2["ns/a.com"][0].Address != "10.1.0.1" {
+		t.Fatalf("unexpected endpoints for shard c2: %v", c2)
+	}
+	if c3 := s.Discovery.EndpointsForShard("c3"); len(c3) != 0 {
+		t.Fatalf("expected no endpoints for unknown shard, got %v", c3)
+	}
+
+	// The returned slices must not alias the internal shard state.
+	c1["ns/a.com"][0] = nil
+	if s.Discovery.EndpointShardsByService["a.com"]["ns"].Shards["c1"][0] == nil {
+		t.Fatalf("EndpointsForShard returned a slice sharing memory with the shard")
+	}
+}
+
+func TestShards(t *testing.T) {
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{})
+	s.Discovery.EDSCacheUpdate("c1", "a.com", "ns", []*model.IstioEndpoint{{Address: "10.0.0.1"}, {Address: "10.0.0.2"}})
+	s.Discovery.EDSCacheUpdate("c1", "b.com", "ns", []*model.IstioEndpoint{{Address: "10.0.0.3"}})
+	s.Discovery.EDSCacheUpdate("c2", "a.com", "ns", []*model.IstioEndpoint{{Address: "10.1.0.1"}})
+
+	if got, want := s.Discovery.Shards(), []string{"c1", "c2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected shards %v, got %v", want, got)
+	}
+	if got, want := s.Discovery.ShardEndpointCounts(), map[string]int{"c1": 3, "c2": 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected shard endpoint counts %v, got %v", want, got)
+	}
+
+	// Removing all the endpoints of a shard removes it.
+	s.Discovery.EDSCacheUpdate("c2", "a.com", "ns", nil)
+	if got, want := s.Discovery.Shards(), []string{"c1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected shards %v after removal, got %v", want, got)
+	}
+
+	// Enumerating the shards must be safe while they are updated.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			s.Discovery.EDSCacheUpdate("c4", "a.com", "ns", []*model.IstioEndpoint{{Address: fmt.Sprintf("10.2.0.%d", i)}})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		s.Discovery.Shards()
+	}
+	<-done
+	if got := s.Discovery.ShardEndpointCounts()["c4"]; got != 1 {
+		t.Fatalf("expected 1 endpoint for shard c4, got %v", got)
+	}
+}
+
 func TestUpdateServiceAccount(t *testing.T) {
 	cluster1Endppoints := []*model.IstioEndpoint{
 		{Address: "10.172.0.1", ServiceAccount: "sa1"},
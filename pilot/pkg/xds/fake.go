@@ -468,6 +468,13 @@ func (fx *FakeXdsUpdater) EDSCacheUpdate(s, hostname string, namespace string, e
 	}
 }
 
+func (fx *FakeXdsUpdater) FlushEDS(s string) {
+	fx.Events <- FakeXdsEvent{Kind: "flusheds"}
+	if fx.Delegate != nil {
+		fx.Delegate.FlushEDS(s)
+	}
+}
+
 func (fx *FakeXdsUpdater) ConfigUpdate(req *model.PushRequest) {
 	fx.Events <- FakeXdsEvent{Kind: "xds", PushReq: req}
 	if fx.Delegate != nil {
@@ -488,6 +495,10 @@ func (fx *FakeXdsUpdater) SvcUpdate(s, hostname string, namespace string, e mode
 	}
 }
 
+func (fx *FakeXdsUpdater) SvcUpdateBatch(s string, updates []model.ServiceChange) {
+	model.SvcUpdateEach(fx, s, updates)
+}
+
 func (fx *FakeXdsUpdater) WaitOrFail(t test.Failer, types ...string) *FakeXdsEvent {
 	t.Helper()
 	got := fx.Wait(types...)
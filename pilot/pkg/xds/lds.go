@@ -59,7 +59,7 @@ func ldsNeedsPush(req *model.PushRequest) bool {
 func (l LdsGenerator) Generate(proxy *model.Proxy, push *model.PushContext, w *model.WatchedResource,
 	req *model.PushRequest) (model.Resources, model.XdsLogDetails, error) {
 	if !ldsNeedsPush(req) {
-		return nil, model.DefaultXdsLogDetails, nil
+		return nil, model.NotModified("no relevant updates"), nil
 	}
 	listeners := l.Server.ConfigGenerator.BuildListeners(proxy, push)
 	resources := model.Resources{}
@@ -26,10 +26,14 @@ import (
 )
 
 var (
-	errTag     = monitoring.MustCreateLabel("err")
-	nodeTag    = monitoring.MustCreateLabel("node")
-	typeTag    = monitoring.MustCreateLabel("type")
-	versionTag = monitoring.MustCreateLabel("version")
+	errTag       = monitoring.MustCreateLabel("err")
+	nodeTag      = monitoring.MustCreateLabel("node")
+	reasonTag    = monitoring.MustCreateLabel("reason")
+	resourcesTag = monitoring.MustCreateLabel("resources")
+	resultTag    = monitoring.MustCreateLabel("result")
+	shardTag     = monitoring.MustCreateLabel("shard")
+	typeTag      = monitoring.MustCreateLabel("type")
+	versionTag   = monitoring.MustCreateLabel("version")
 
 	// pilot_total_xds_rejects should be used instead. This is for backwards compatibility
 	cdsReject = monitoring.NewGauge(
@@ -101,11 +105,36 @@ var (
 	xdsClientTrackerMutex = &sync.Mutex{}
 	xdsClientTracker      = make(map[string]float64)
 
+	// Labeled by shard only, so cardinality is bounded by the number of registries/clusters.
+	endpointsPerShard = monitoring.NewGauge(
+		"pilot_endpoints_per_shard",
+		"Number of endpoints known to pilot, per endpoint shard.",
+		monitoring.WithLabels(shardTag),
+	)
+
+	// Complements ProxyStatusEndpointNotReady, to find proxies silently dropping pushes.
+	unsyncedConnections = monitoring.NewGauge(
+		"generic_xds_unsynced_connections",
+		"Number of connections with a response not ACKed within PILOT_FLOW_CONTROL_TIMEOUT, per type.",
+		monitoring.WithLabels(typeTag),
+	)
+
 	xdsResponseWriteTimeouts = monitoring.NewSum(
 		"pilot_xds_write_timeout",
 		"Pilot XDS response write timeouts.",
 	)
 
+	xdsInitTimeouts = monitoring.NewSum(
+		"pilot_xds_init_timeouts",
+		"Pilot XDS connections closed because they were not initialized within PILOT_XDS_INIT_TIMEOUT, "+
+			"usually as the client did not send its first request.",
+	)
+
+	xdsStreamLimitRejections = monitoring.NewSum(
+		"pilot_xds_stream_limit_rejections",
+		"Pilot XDS streams rejected because PILOT_MAX_CONCURRENT_XDS_STREAMS streams were already connected.",
+	)
+
 	// Covers xds_builderr and xds_senderr for xds in {lds, rds, cds, eds}.
 	pushes = monitoring.NewSum(
 		"pilot_xds_pushes",
@@ -113,6 +142,14 @@ var (
 		monitoring.WithLabels(typeTag),
 	)
 
+	// Pushes skipped as the generated configuration was not modified. Unlike pushes with nothing to send,
+	// these are reported explicitly, by the generators or by PILOT_SKIP_UNCHANGED_XDS_PUSHES.
+	skippedPushes = monitoring.NewSum(
+		"pilot_xds_skipped_pushes",
+		"Total number of XDS pushes skipped as the configuration was not modified, labeled by type and reason.",
+		monitoring.WithLabels(typeTag, reasonTag),
+	)
+
 	cdsSendErrPushes = pushes.With(typeTag.Value("cds_senderr"))
 	edsSendErrPushes = pushes.With(typeTag.Value("eds_senderr"))
 	ldsSendErrPushes = pushes.With(typeTag.Value("lds_senderr"))
@@ -137,6 +174,21 @@ var (
 		[]float64{.01, .1, 1, 3, 5, 10, 20, 30},
 	)
 
+	// pushDuration includes the time to generate and send a response, for a single connection.
+	// Unlike pilot_xds_push_time it is also recorded for failed pushes.
+	pushDuration = monitoring.NewDistribution(
+		"generic_xds_push_duration_seconds",
+		"Time in seconds to generate and send a response to a single connection.",
+		[]float64{.001, .01, .1, .5, 1, 3, 5, 10, 30},
+		monitoring.WithLabels(typeTag, resultTag, resourcesTag),
+	)
+
+	pushFanoutTime = monitoring.NewDistribution(
+		"pilot_xds_push_fanout_time",
+		"Time in seconds Pilot takes to enqueue a push for all connected proxies.",
+		[]float64{.01, .1, 1, 3, 5, 10, 20, 30},
+	)
+
 	// only supported dimension is millis, unfortunately. default to unitdimensionless.
 	proxiesQueueTime = monitoring.NewDistribution(
 		"pilot_proxy_queue_time",
@@ -144,6 +196,12 @@ var (
 		[]float64{.1, .5, 1, 3, 5, 10, 20, 30},
 	)
 
+	watchedTypeEvictions = monitoring.NewSum(
+		"pilot_xds_watched_type_evictions",
+		"Total number of watches dropped because a connection exceeded the maximum number of watched types.",
+		monitoring.WithLabels(typeTag),
+	)
+
 	pushTriggers = monitoring.NewSum(
 		"pilot_push_triggers",
 		"Total number of times a push was triggered, labeled by reason for the push.",
@@ -173,6 +231,24 @@ var (
 		monitoring.WithLabels(typeTag),
 	)
 
+	pushChannelDepth = monitoring.NewGauge(
+		"pilot_push_channel_depth",
+		"Number of config updates waiting in the debounce channel. A full channel means pilot is "+
+			"falling behind, and further updates are merged into the overflow request.",
+	)
+
+	pushChannelOverflows = monitoring.NewSum(
+		"pilot_push_channel_overflows_total",
+		"Total number of config updates merged into the pending overflow request because the debounce "+
+			"channel was full. A steady increase means pilot cannot keep up with the update rate.",
+	)
+
+	pilotSDSRemovedSecrets = monitoring.NewSum(
+		"pilot_sds_removed_secrets_total",
+		"Total number of secrets previously sent to a proxy that are no longer available. "+
+			"SotW SDS cannot remove a secret, so the proxy keeps the last version it received.",
+	)
+
 	pilotSDSCertificateErrors = monitoring.NewSum(
 		"pilot_sds_certificate_errors_total",
 		"Total number of failures to fetch SDS key and certificate.",
@@ -256,6 +332,39 @@ func recordPushTime(xdsType string, duration time.Duration) {
 	pushes.With(typeTag.Value(v3.GetMetricType(xdsType))).Increment()
 }
 
+func recordSkippedPush(xdsType string, reason string) {
+	skippedPushes.With(typeTag.Value(v3.GetMetricType(xdsType)), reasonTag.Value(reason)).Increment()
+}
+
+// recordPushDuration records the time taken to generate and send a response with the given number of resources.
+func recordPushDuration(xdsType string, resources int, err error, duration time.Duration) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	pushDuration.With(
+		typeTag.Value(v3.GetMetricType(xdsType)),
+		resultTag.Value(result),
+		resourcesTag.Value(resourceCountBucket(resources)),
+	).Record(duration.Seconds())
+}
+
+// resourceCountBucket buckets the number of resources of a response, to keep the label cardinality bounded.
+func resourceCountBucket(resources int) string {
+	switch {
+	case resources == 0:
+		return "0"
+	case resources <= 10:
+		return "1-10"
+	case resources <= 100:
+		return "11-100"
+	case resources <= 1000:
+		return "101-1000"
+	default:
+		return "1000+"
+	}
+}
+
 func init() {
 	monitoring.MustRegister(
 		cdsReject,
@@ -263,22 +372,33 @@ func init() {
 		ldsReject,
 		rdsReject,
 		xdsExpiredNonce,
+		watchedTypeEvictions,
 		totalXDSRejects,
 		monServices,
 		xdsClients,
+		endpointsPerShard,
+		unsyncedConnections,
 		xdsResponseWriteTimeouts,
+		xdsInitTimeouts,
+		xdsStreamLimitRejections,
 		pushes,
+		skippedPushes,
 		pushTime,
+		pushDuration,
 		proxiesConvergeDelay,
 		proxiesQueueTime,
 		pushContextErrors,
 		totalXDSInternalErrors,
 		inboundUpdates,
+		pushChannelDepth,
+		pushChannelOverflows,
 		pushTriggers,
 		sendTime,
+		pushFanoutTime,
 		totalDelayedPushes,
 		totalDelayedPushTimeouts,
 		pilotSDSCertificateErrors,
+		pilotSDSRemovedSecrets,
 		configSizeBytes,
 	)
 }
@@ -0,0 +1,100 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xds
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.opencensus.io/stats/view"
+	"go.uber.org/atomic"
+
+	"istio.io/istio/pilot/pkg/model"
+	v3 "istio.io/istio/pilot/pkg/xds/v3"
+)
+
+func TestResourceCountBucket(t *testing.T) {
+	cases := map[int]string{
+		0:    "0",
+		1:    "1-10",
+		10:   "1-10",
+		11:   "11-100",
+		1000: "101-1000",
+		1001: "1000+",
+	}
+	for resources, want := range cases {
+		if got := resourceCountBucket(resources); got != want {
+			t.Errorf("resourceCountBucket(%d) = %q, want %q", resources, got, want)
+		}
+	}
+}
+
+func TestRecordPushDuration(t *testing.T) {
+	recordPushDuration(v3.ClusterType, 5, nil, time.Millisecond)
+	recordPushDuration(v3.ClusterType, 0, errors.New("failed"), time.Millisecond)
+
+	rows, err := view.RetrieveData("generic_xds_push_duration_seconds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	for _, row := range rows {
+		labels := map[string]string{}
+		for _, t := range row.Tags {
+			labels[t.Key.Name()] = t.Value
+		}
+		if labels["type"] == "cds" {
+			found[labels["result"]+"/"+labels["resources"]] = true
+		}
+	}
+	for _, want := range []string{"success/1-10", "failure/0"} {
+		if !found[want] {
+			t.Errorf("expected push duration recorded for %s, got %v", want, found)
+		}
+	}
+}
+
+func TestPushChannelMetrics(t *testing.T) {
+	value := func(name string) float64 {
+		rows, err := view.RetrieveData(name)
+		if err != nil || len(rows) == 0 {
+			return 0
+		}
+		switch data := rows[0].Data.(type) {
+		case *view.SumData:
+			return data.Value
+		case *view.LastValueData:
+			return data.Value
+		}
+		return 0
+	}
+	s := &DiscoveryServer{
+		InboundUpdates:   atomic.NewInt64(0),
+		CommittedUpdates: atomic.NewInt64(0),
+		pushChannel:      make(chan *model.PushRequest, 10),
+		overflowCh:       make(chan struct{}, 1),
+	}
+	overflows := value("pilot_push_channel_overflows_total")
+	for i := 0; i < 12; i++ {
+		s.ConfigUpdate(&model.PushRequest{Full: true})
+	}
+	if got := value("pilot_push_channel_depth"); got != 10 {
+		t.Fatalf("expected a depth of 10, got %v", got)
+	}
+	if got := value("pilot_push_channel_overflows_total") - overflows; got != 2 {
+		t.Fatalf("expected 2 overflows, got %v", got)
+	}
+}
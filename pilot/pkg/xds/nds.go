@@ -70,7 +70,7 @@ func ndsNeedsPush(req *model.PushRequest) bool {
 func (n NdsGenerator) Generate(proxy *model.Proxy, push *model.PushContext, w *model.WatchedResource,
 	req *model.PushRequest) (model.Resources, model.XdsLogDetails, error) {
 	if !ndsNeedsPush(req) {
-		return nil, model.DefaultXdsLogDetails, nil
+		return nil, model.NotModified("no relevant updates"), nil
 	}
 	nt := n.Server.ConfigGenerator.BuildNameTable(proxy, push)
 	if nt == nil {
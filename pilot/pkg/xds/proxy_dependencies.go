@@ -93,3 +93,44 @@ func DefaultProxyNeedsPush(proxy *model.Proxy, req *model.PushRequest) bool {
 
 	return false
 }
+
+// ProxyVersionAtLeast returns true if the Istio version of the proxy is at least major.minor. As in
+// model.ParseIstioVersion, proxies with a missing or unparseable version are assumed to run the latest version.
+func ProxyVersionAtLeast(proxy *model.Proxy, major, minor int) bool {
+	version := proxy.IstioVersion
+	if version == nil {
+		metaVersion := ""
+		if proxy.Metadata != nil {
+			metaVersion = proxy.Metadata.IstioVersion
+		}
+		version = model.ParseIstioVersion(metaVersion)
+	}
+	return version.Compare(&model.IstioVersion{Major: major, Minor: minor, Patch: -1}) >= 0
+}
+
+// ProxyNeedsPushAtVersion wraps a ProxyNeedsPush implementation, to skip pushes to proxies older than
+// major.minor when all the updated configs are of the given kinds, typically the configs only used to
+// generate a type these proxies do not support. ProxyNeedsPush is not aware of the pushed types, so types
+// are gated through the config kinds they are generated from. Other pushes are left to needsPush.
+func ProxyNeedsPushAtVersion(needsPush func(proxy *model.Proxy, req *model.PushRequest) bool,
+	major, minor int, kinds ...config.GroupVersionKind) func(proxy *model.Proxy, req *model.PushRequest) bool {
+	gated := make(map[config.GroupVersionKind]struct{}, len(kinds))
+	for _, kind := range kinds {
+		gated[kind] = struct{}{}
+	}
+	return func(proxy *model.Proxy, req *model.PushRequest) bool {
+		if len(req.ConfigsUpdated) > 0 && !ProxyVersionAtLeast(proxy, major, minor) {
+			onlyGated := true
+			for cfg := range req.ConfigsUpdated {
+				if _, f := gated[cfg.Kind]; !f {
+					onlyGated = false
+					break
+				}
+			}
+			if onlyGated {
+				return false
+			}
+		}
+		return needsPush(proxy, req)
+	}
+}
@@ -192,15 +192,55 @@ func BenchmarkListEquals(b *testing.B) {
 	for i := 0; i < size; i++ {
 		equal = append(equal, strconv.Itoa(i))
 	}
+	var reversed []string
+	for i := size - 1; i >= 0; i-- {
+		reversed = append(reversed, strconv.Itoa(i))
+	}
 	var notEqual []string
 	for i := 0; i < size; i++ {
 		notEqual = append(notEqual, strconv.Itoa(i))
 	}
 	notEqual[size-1] = "z"
 
-	for n := 0; n < b.N; n++ {
-		listEqualUnordered(l, equal)
-		listEqualUnordered(l, notEqual)
+	b.Run("ordered", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			listEqualUnordered(l, equal)
+		}
+	})
+	b.Run("unordered", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			listEqualUnordered(l, reversed)
+		}
+	})
+	b.Run("not equal", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			listEqualUnordered(l, notEqual)
+		}
+	})
+}
+
+func TestListEqualUnordered(t *testing.T) {
+	cases := []struct {
+		a, b  []string
+		equal bool
+	}{
+		{nil, nil, true},
+		{nil, []string{}, true},
+		{[]string{"a"}, nil, false},
+		{[]string{"a", "b"}, []string{"a", "b"}, true},
+		{[]string{"a", "b"}, []string{"b", "a"}, true},
+		{[]string{"a", "b", "c"}, []string{"a", "c", "b"}, true},
+		{[]string{"a", "b"}, []string{"a", "c"}, false},
+		{[]string{"a", "b"}, []string{"a", "a"}, true},
+		{[]string{"a", "a"}, []string{"a", "b"}, false},
+	}
+	for _, tt := range cases {
+		if got := listEqualUnordered(tt.a, tt.b); got != tt.equal {
+			t.Errorf("listEqualUnordered(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.equal)
+		}
 	}
 }
 
@@ -260,3 +300,66 @@ func TestCheckConnectionIdentity(t *testing.T) {
 		})
 	}
 }
+
+func TestProxyVersionAtLeast(t *testing.T) {
+	cases := []struct {
+		version string
+		want    bool
+	}{
+		{"1.10.0", true},
+		{"1.9.0", true},
+		{"1.9", true},
+		{"1.8.5", false},
+		{"release-1.9-20210301", true},
+		{"release-1.8", false},
+		{"2.0.0", true},
+		{"1.8", false},
+		{"1.10-dev", true},
+		{"", true},
+		{"not-a-version", true},
+	}
+	for _, tt := range cases {
+		t.Run(tt.version, func(t *testing.T) {
+			proxy := &model.Proxy{Metadata: &model.NodeMetadata{IstioVersion: tt.version}}
+			if got := ProxyVersionAtLeast(proxy, 1, 9); got != tt.want {
+				t.Errorf("ProxyVersionAtLeast(%q, 1, 9) = %v, want %v", tt.version, got, tt.want)
+			}
+			proxy = &model.Proxy{IstioVersion: model.ParseIstioVersion(tt.version)}
+			if got := ProxyVersionAtLeast(proxy, 1, 9); got != tt.want {
+				t.Errorf("ProxyVersionAtLeast(%q, 1, 9) with parsed version = %v, want %v", tt.version, got, tt.want)
+			}
+		})
+	}
+	if !ProxyVersionAtLeast(&model.Proxy{}, 1, 9) {
+		t.Errorf("expected proxy without metadata to be assumed the latest version")
+	}
+}
+
+func TestProxyNeedsPushAtVersion(t *testing.T) {
+	needsPush := ProxyNeedsPushAtVersion(func(*model.Proxy, *model.PushRequest) bool { return true },
+		1, 9, gvk.EnvoyFilter)
+	oldProxy := &model.Proxy{IstioVersion: &model.IstioVersion{Major: 1, Minor: 8}}
+	newProxy := &model.Proxy{IstioVersion: &model.IstioVersion{Major: 1, Minor: 9}}
+	envoyFilter := model.ConfigKey{Kind: gvk.EnvoyFilter, Name: "filter", Namespace: "ns"}
+	virtualService := model.ConfigKey{Kind: gvk.VirtualService, Name: "vs", Namespace: "ns"}
+
+	cases := []struct {
+		name    string
+		proxy   *model.Proxy
+		updated map[model.ConfigKey]struct{}
+		want    bool
+	}{
+		{"old proxy, gated kind", oldProxy, map[model.ConfigKey]struct{}{envoyFilter: {}}, false},
+		{"new proxy, gated kind", newProxy, map[model.ConfigKey]struct{}{envoyFilter: {}}, true},
+		{"old proxy, other kind", oldProxy, map[model.ConfigKey]struct{}{virtualService: {}}, true},
+		{"old proxy, mixed kinds", oldProxy, map[model.ConfigKey]struct{}{envoyFilter: {}, virtualService: {}}, true},
+		{"old proxy, full push", oldProxy, nil, true},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := needsPush(tt.proxy, &model.PushRequest{ConfigsUpdated: tt.updated}); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
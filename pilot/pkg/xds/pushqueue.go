@@ -55,6 +55,7 @@ func (p *PushQueue) Enqueue(con *Connection, pushRequest *model.PushRequest) {
 	if p.shuttingDown {
 		return
 	}
+	con.pushesEnqueued++
 
 	// If its already in progress, merge the info and return
 	if request, f := p.processing[con]; f {
@@ -95,6 +96,8 @@ func (p *PushQueue) Dequeue() (con *Connection, request *model.PushRequest, shut
 
 	// Mark the connection as in progress
 	p.processing[con] = nil
+	// The request covers all the pushes enqueued so far
+	con.pushesDequeued = con.pushesEnqueued
 
 	return con, request, false
 }
@@ -104,6 +107,7 @@ func (p *PushQueue) MarkDone(con *Connection) {
 	defer p.cond.L.Unlock()
 	request := p.processing[con]
 	delete(p.processing, con)
+	con.pushesDone = con.pushesDequeued
 
 	// If the info is present, that means Enqueue was called while connection was not yet marked done.
 	// This means we need to add it back to the queue.
@@ -121,6 +125,29 @@ func (p *PushQueue) Pending() int {
 	return len(p.queue)
 }
 
+// enqueuedPushes returns the number of pushes enqueued so far for each of the connections.
+func (p *PushQueue) enqueuedPushes(cons []*Connection) map[*Connection]int64 {
+	p.cond.L.Lock()
+	defer p.cond.L.Unlock()
+	out := make(map[*Connection]int64, len(cons))
+	for _, con := range cons {
+		out[con] = con.pushesEnqueued
+	}
+	return out
+}
+
+// delivered returns whether each of the connections has been delivered at least the given number of pushes.
+func (p *PushQueue) delivered(pushes map[*Connection]int64) bool {
+	p.cond.L.Lock()
+	defer p.cond.L.Unlock()
+	for con, n := range pushes {
+		if con.pushesDone < n {
+			return false
+		}
+	}
+	return true
+}
+
 // ShutDown will cause queue to ignore all new items added to it. As soon as the
 // worker goroutines have drained the existing items in the queue, they will be
 // instructed to exit.
@@ -16,6 +16,8 @@ package xds
 
 import (
 	"fmt"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
@@ -80,6 +82,40 @@ func parseResourceName(resource, defaultNamespace, cluster string) (SecretResour
 	return SecretResource{}, fmt.Errorf("unknown resource type: %v", resource)
 }
 
+// canonicalSecretNames returns the canonical form of SDS resource names: surrounding whitespace is trimmed,
+// the paths of file-cert and file-root resources are cleaned and duplicate names are removed.
+func canonicalSecretNames(names []string) []string {
+	out := make([]string, 0, len(names))
+	seen := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		name = canonicalSecretName(name)
+		if _, f := seen[name]; f {
+			continue
+		}
+		seen[name] = struct{}{}
+		out = append(out, name)
+	}
+	return out
+}
+
+func canonicalSecretName(name string) string {
+	name = strings.TrimSpace(name)
+	cfg, ok := model.SdsCertificateConfigFromResourceName(name)
+	if !ok {
+		return name
+	}
+	if cfg.IsKeyCertificate() {
+		return model.SdsCertificateConfig{
+			CertificatePath: filepath.Clean(cfg.CertificatePath),
+			PrivateKeyPath:  filepath.Clean(cfg.PrivateKeyPath),
+		}.GetResourceName()
+	}
+	if cfg.IsRootCertificate() {
+		return model.SdsCertificateConfig{CaCertificatePath: filepath.Clean(cfg.CaCertificatePath)}.GetRootResourceName()
+	}
+	return name
+}
+
 func needsUpdate(proxy *model.Proxy, updates model.XdsUpdates) bool {
 	if proxy.Type != model.Router {
 		return false
@@ -102,6 +138,10 @@ func (s *SecretGen) proxyAuthorizedForSecret(proxy *model.Proxy, sr SecretResour
 	return nil
 }
 
+// Generate generates the watched secrets of the proxy. For an incremental push, that is req.Full unset
+// with secrets in req.ConfigsUpdated, only the watched secrets related to the updated ones are generated,
+// e.g. on the rotation of a single certificate. SDS is not a wildcard type, so the proxy keeps the other
+// secrets it was sent, and they remain tracked for the connection; see trackRemovedSecrets.
 func (s *SecretGen) Generate(proxy *model.Proxy, push *model.PushContext, w *model.WatchedResource,
 	req *model.PushRequest) (model.Resources, model.XdsLogDetails, error) {
 	if proxy.VerifiedIdentity == nil {
@@ -127,6 +167,11 @@ func (s *SecretGen) Generate(proxy *model.Proxy, push *model.PushContext, w *mod
 	results := model.Resources{}
 	cached, regenerated := 0, 0
 	for _, resource := range w.ResourceNames {
+		if !req.Delta.IsEmpty() && !req.Delta.Subscribed.Contains(resource) {
+			// The client changed its subscriptions, only the new secrets are generated. The others were
+			// already sent and are completed by withPreviousSecrets.
+			continue
+		}
 		sr, err := parseResourceName(resource, proxy.ConfigNamespace, string(proxy.Metadata.ClusterID))
 		if err != nil {
 			pilotSDSCertificateErrors.Increment()
@@ -182,6 +227,76 @@ func (s *SecretGen) Generate(proxy *model.Proxy, push *model.PushContext, w *mod
 	return results, model.XdsLogDetails{AdditionalInfo: fmt.Sprintf("cached:%v/%v", cached, cached+regenerated)}, nil
 }
 
+// withPreviousSecrets completes the secrets generated for a change of subscriptions with the secrets previously sent
+// to the connection, so the SotW response still holds all the watched secrets without regenerating them.
+func withPreviousSecrets(con *Connection, w *model.WatchedResource, req *model.PushRequest, res model.Resources) model.Resources {
+	if req == nil || req.Delta.IsEmpty() {
+		return res
+	}
+	for _, resource := range w.ResourceNames {
+		if req.Delta.Subscribed.Contains(resource) {
+			continue
+		}
+		if r, f := con.sentSecrets[resource]; f {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// orderSecrets sorts the secrets of an SDS response with less, comparing resource names, or by name if less is
+// nil. The sort is stable, so secrets less does not order keep their relative order.
+func orderSecrets(res model.Resources, less func(a, b string) bool) {
+	if less == nil {
+		less = func(a, b string) bool { return a < b }
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return less(res[i].Name, res[j].Name)
+	})
+}
+
+// trackRemovedSecrets records the secrets sent to the connection, and reports the secrets previously sent that
+// this push should have regenerated but are missing from res, typically as the credential was deleted. SotW
+// SDS has no way to remove a secret, so the proxy keeps serving the last version it received.
+func trackRemovedSecrets(con *Connection, w *model.WatchedResource, req *model.PushRequest, res model.Resources) {
+	generated := make(map[string]*discovery.Resource, len(res))
+	for _, r := range res {
+		generated[r.Name] = r
+	}
+	var updatedSecrets map[model.ConfigKey]struct{}
+	if req != nil && !req.Full {
+		updatedSecrets = model.ConfigsOfKind(req.ConfigsUpdated, gvk.Secret)
+	}
+	for _, resource := range w.ResourceNames {
+		if _, f := con.sentSecrets[resource]; !f {
+			continue
+		}
+		if _, f := generated[resource]; f {
+			continue
+		}
+		if updatedSecrets != nil {
+			sr, err := parseResourceName(resource, con.proxy.ConfigNamespace, string(con.proxy.Metadata.ClusterID))
+			if err != nil || !containsAny(updatedSecrets, relatedConfigs(model.ConfigKey{Kind: gvk.Secret, Name: sr.Name, Namespace: sr.Namespace})) {
+				// Not regenerated by this incremental push.
+				continue
+			}
+		}
+		delete(con.sentSecrets, resource)
+		pilotSDSRemovedSecrets.Increment()
+		log.Warnf("secret %s previously sent to %s is no longer available, the proxy keeps its last version", resource, con.ConID)
+	}
+	sent := make(map[string]*discovery.Resource, len(w.ResourceNames))
+	for _, resource := range w.ResourceNames {
+		if r, f := generated[resource]; f {
+			sent[resource] = r
+		} else if r, f := con.sentSecrets[resource]; f {
+			sent[resource] = r
+		}
+	}
+	// Secrets no longer watched are dropped, so they are not reported if watched again later.
+	con.sentSecrets = sent
+}
+
 func toEnvoyCaSecret(name string, cert []byte) *discovery.Resource {
 	res := util.MessageToAny(&tls.Secret{
 		Name: name,
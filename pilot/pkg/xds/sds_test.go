@@ -16,10 +16,16 @@ package xds
 
 import (
 	"errors"
+	"reflect"
+	"sort"
+	"strconv"
 	"testing"
 	"time"
 
+	tls "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	"github.com/google/go-cmp/cmp"
+	"go.opencensus.io/stats/view"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -29,11 +35,12 @@ import (
 	"istio.io/istio/pilot/pkg/model"
 	kubesecrets "istio.io/istio/pilot/pkg/secrets/kube"
 	authnmodel "istio.io/istio/pilot/pkg/security/model"
+	"istio.io/istio/pilot/pkg/util/sets"
 	v3 "istio.io/istio/pilot/pkg/xds/v3"
 	"istio.io/istio/pilot/test/xdstest"
+	"istio.io/istio/pkg/cluster"
 	"istio.io/istio/pkg/config/schema/gvk"
 	"istio.io/istio/pkg/kube"
-	"istio.io/istio/pkg/spiffe"
 )
 
 func TestParseResourceName(t *testing.T) {
@@ -143,7 +150,7 @@ func TestGenerate(t *testing.T) {
 	}{
 		{
 			name:      "simple",
-			proxy:     &model.Proxy{VerifiedIdentity: &spiffe.Identity{Namespace: "istio-system"}, Type: model.Router, ConfigNamespace: "istio-system"},
+			proxy:     model.NewTestProxy(model.WithProxyType(model.Router), model.WithProxyNamespace("istio-system"), model.WithProxyIdentity("istio-system", "")),
 			resources: []string{"kubernetes://generic"},
 			request:   &model.PushRequest{Full: true},
 			expect: map[string]Expected{
@@ -155,28 +162,28 @@ func TestGenerate(t *testing.T) {
 		},
 		{
 			name:      "sidecar",
-			proxy:     &model.Proxy{VerifiedIdentity: &spiffe.Identity{Namespace: "istio-system"}, ConfigNamespace: "istio-system"},
+			proxy:     model.NewTestProxy(model.WithProxyNamespace("istio-system"), model.WithProxyIdentity("istio-system", "")),
 			resources: []string{"kubernetes://generic"},
 			request:   &model.PushRequest{Full: true},
 			expect:    map[string]Expected{},
 		},
 		{
 			name:      "mismatched namespace",
-			proxy:     &model.Proxy{VerifiedIdentity: &spiffe.Identity{Namespace: "istio-system"}, Type: model.Router},
+			proxy:     model.NewTestProxy(model.WithProxyType(model.Router), model.WithProxyIdentity("istio-system", "")),
 			resources: []string{"kubernetes://generic"},
 			request:   &model.PushRequest{Full: true},
 			expect:    map[string]Expected{},
 		},
 		{
 			name:      "unauthenticated",
-			proxy:     &model.Proxy{Type: model.Router, ConfigNamespace: "istio-system"},
+			proxy:     model.NewTestProxy(model.WithProxyType(model.Router), model.WithProxyNamespace("istio-system")),
 			resources: []string{"kubernetes://generic"},
 			request:   &model.PushRequest{Full: true},
 			expect:    map[string]Expected{},
 		},
 		{
 			name:      "multiple",
-			proxy:     &model.Proxy{VerifiedIdentity: &spiffe.Identity{Namespace: "istio-system"}, Type: model.Router, ConfigNamespace: "istio-system"},
+			proxy:     model.NewTestProxy(model.WithProxyType(model.Router), model.WithProxyNamespace("istio-system"), model.WithProxyIdentity("istio-system", "")),
 			resources: allResources,
 			request:   &model.PushRequest{Full: true},
 			expect: map[string]Expected{
@@ -202,7 +209,7 @@ func TestGenerate(t *testing.T) {
 		},
 		{
 			name:      "full push with updates",
-			proxy:     &model.Proxy{VerifiedIdentity: &spiffe.Identity{Namespace: "istio-system"}, Type: model.Router, ConfigNamespace: "istio-system"},
+			proxy:     model.NewTestProxy(model.WithProxyType(model.Router), model.WithProxyNamespace("istio-system"), model.WithProxyIdentity("istio-system", "")),
 			resources: []string{"kubernetes://generic", "kubernetes://generic-mtls", "kubernetes://generic-mtls-cacert"},
 			request: &model.PushRequest{Full: true, ConfigsUpdated: map[model.ConfigKey]struct{}{
 				{Name: "generic-mtls", Namespace: "istio-system", Kind: gvk.Secret}: {},
@@ -223,7 +230,7 @@ func TestGenerate(t *testing.T) {
 		},
 		{
 			name:      "incremental push with updates",
-			proxy:     &model.Proxy{VerifiedIdentity: &spiffe.Identity{Namespace: "istio-system"}, Type: model.Router, ConfigNamespace: "istio-system"},
+			proxy:     model.NewTestProxy(model.WithProxyType(model.Router), model.WithProxyNamespace("istio-system"), model.WithProxyIdentity("istio-system", "")),
 			resources: allResources,
 			request: &model.PushRequest{Full: false, ConfigsUpdated: map[model.ConfigKey]struct{}{
 				{Name: "generic", Namespace: "istio-system", Kind: gvk.Secret}: {},
@@ -237,7 +244,7 @@ func TestGenerate(t *testing.T) {
 		},
 		{
 			name:      "incremental push with updates - mtls",
-			proxy:     &model.Proxy{VerifiedIdentity: &spiffe.Identity{Namespace: "istio-system"}, Type: model.Router, ConfigNamespace: "istio-system"},
+			proxy:     model.NewTestProxy(model.WithProxyType(model.Router), model.WithProxyNamespace("istio-system"), model.WithProxyIdentity("istio-system", "")),
 			resources: allResources,
 			request: &model.PushRequest{Full: false, ConfigsUpdated: map[model.ConfigKey]struct{}{
 				{Name: "generic-mtls", Namespace: "istio-system", Kind: gvk.Secret}: {},
@@ -254,7 +261,7 @@ func TestGenerate(t *testing.T) {
 		},
 		{
 			name:      "incremental push with updates - mtls split",
-			proxy:     &model.Proxy{VerifiedIdentity: &spiffe.Identity{Namespace: "istio-system"}, Type: model.Router, ConfigNamespace: "istio-system"},
+			proxy:     model.NewTestProxy(model.WithProxyType(model.Router), model.WithProxyNamespace("istio-system"), model.WithProxyIdentity("istio-system", "")),
 			resources: allResources,
 			request: &model.PushRequest{Full: false, ConfigsUpdated: map[model.ConfigKey]struct{}{
 				{Name: "generic-mtls-split", Namespace: "istio-system", Kind: gvk.Secret}: {},
@@ -271,7 +278,7 @@ func TestGenerate(t *testing.T) {
 		},
 		{
 			name:      "incremental push with updates - mtls split ca update",
-			proxy:     &model.Proxy{VerifiedIdentity: &spiffe.Identity{Namespace: "istio-system"}, Type: model.Router, ConfigNamespace: "istio-system"},
+			proxy:     model.NewTestProxy(model.WithProxyType(model.Router), model.WithProxyNamespace("istio-system"), model.WithProxyIdentity("istio-system", "")),
 			resources: allResources,
 			request: &model.PushRequest{Full: false, ConfigsUpdated: map[model.ConfigKey]struct{}{
 				{Name: "generic-mtls-split-cacert", Namespace: "istio-system", Kind: gvk.Secret}: {},
@@ -286,10 +293,25 @@ func TestGenerate(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:      "subscription change",
+			proxy:     model.NewTestProxy(model.WithProxyType(model.Router), model.WithProxyNamespace("istio-system"), model.WithProxyIdentity("istio-system", "")),
+			resources: []string{"kubernetes://generic", "kubernetes://generic-mtls"},
+			request: &model.PushRequest{Full: true, Delta: model.ResourceDelta{
+				Subscribed:   sets.NewSet("kubernetes://generic-mtls"),
+				Unsubscribed: sets.NewSet("kubernetes://generic-mtls-cacert"),
+			}},
+			expect: map[string]Expected{
+				"kubernetes://generic-mtls": {
+					Key:  "generic-mtls-key",
+					Cert: "generic-mtls-cert",
+				},
+			},
+		},
 		{
 			// If an unknown resource is request, we return all the ones we do know about
 			name:      "unknown",
-			proxy:     &model.Proxy{VerifiedIdentity: &spiffe.Identity{Namespace: "istio-system"}, Type: model.Router, ConfigNamespace: "istio-system"},
+			proxy:     model.NewTestProxy(model.WithProxyType(model.Router), model.WithProxyNamespace("istio-system"), model.WithProxyIdentity("istio-system", "")),
 			resources: []string{"kubernetes://generic", "foo://invalid", "kubernetes://not-found"},
 			request:   &model.PushRequest{Full: true},
 			expect: map[string]Expected{
@@ -302,7 +324,7 @@ func TestGenerate(t *testing.T) {
 		{
 			// proxy without authorization
 			name:      "unauthorized",
-			proxy:     &model.Proxy{VerifiedIdentity: &spiffe.Identity{Namespace: "istio-system"}, Type: model.Router, ConfigNamespace: "istio-system"},
+			proxy:     model.NewTestProxy(model.WithProxyType(model.Router), model.WithProxyNamespace("istio-system"), model.WithProxyIdentity("istio-system", "")),
 			resources: []string{"kubernetes://generic"},
 			request:   &model.PushRequest{Full: true},
 			// Should get a response, but it will be empty
@@ -348,6 +370,176 @@ func TestGenerate(t *testing.T) {
 	}
 }
 
+func TestPushSecretRotation(t *testing.T) {
+	s := NewFakeDiscoveryServer(t, FakeOptions{
+		KubernetesObjects: []runtime.Object{genericCert, genericMtlsCert},
+	})
+	cc := s.KubeClient().Kube().(*fake.Clientset)
+	cc.Fake.Lock()
+	kubesecrets.DisableAuthorizationForTest(cc)
+	cc.Fake.Unlock()
+
+	proxy := s.SetupProxy(model.NewTestProxy(
+		model.WithProxyType(model.Router),
+		model.WithProxyNamespace("istio-system"),
+		model.WithProxyIdentity("istio-system", ""),
+	))
+	w := &model.WatchedResource{TypeUrl: v3.SecretType, ResourceNames: []string{"kubernetes://generic", "kubernetes://generic-mtls"}}
+	proxy.WatchedResources = map[string]*model.WatchedResource{v3.SecretType: w}
+	stream := &recordingStream{}
+	con := newConnection("", stream)
+	con.proxy = proxy
+	push := func(req *model.PushRequest) []string {
+		t.Helper()
+		req.Start = time.Now()
+		if err := s.Discovery.pushXds(con, s.PushContext(), versionInfo(), w, req); err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, scrt := range xdstest.ExtractTLSSecrets(t, stream.sent[len(stream.sent)-1].Resources) {
+			names = append(names, scrt.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	if got := push(&model.PushRequest{Full: true}); !reflect.DeepEqual(got, w.ResourceNames) {
+		t.Fatalf("expected all secrets on a full push, got %v", got)
+	}
+	mtls := con.sentSecrets["kubernetes://generic-mtls"]
+
+	got := push(&model.PushRequest{Full: false, ConfigsUpdated: map[model.ConfigKey]struct{}{
+		{Name: "generic", Namespace: "istio-system", Kind: gvk.Secret}: {},
+	}})
+	if !reflect.DeepEqual(got, []string{"kubernetes://generic"}) {
+		t.Fatalf("expected only the rotated secret, got %v", got)
+	}
+	if len(con.sentSecrets) != 2 || con.sentSecrets["kubernetes://generic-mtls"] != mtls {
+		t.Fatalf("expected the other secret to stay tracked as sent, got %v", con.sentSecrets)
+	}
+}
+
+func TestPushSecretTargets(t *testing.T) {
+	s := NewFakeDiscoveryServer(t, FakeOptions{
+		KubernetesObjects: []runtime.Object{genericCert},
+	})
+	cc := s.KubeClient().Kube().(*fake.Clientset)
+	cc.Fake.Lock()
+	kubesecrets.DisableAuthorizationForTest(cc)
+	cc.Fake.Unlock()
+
+	// Both gateways have the same proxy ID, but run in different clusters.
+	dispatcher := &DiscoveryServer{
+		pushQueue:  NewPushQueue(),
+		adsClients: map[string]*Connection{},
+	}
+	defer dispatcher.pushQueue.ShutDown()
+	streams := map[string]*recordingStream{}
+	for _, clusterID := range []string{"", "cluster-2"} {
+		proxy := s.SetupProxy(model.NewTestProxy(
+			model.WithProxyType(model.Router),
+			model.WithProxyNamespace("istio-system"),
+			model.WithProxyIdentity("istio-system", ""),
+		))
+		proxy.Metadata.ClusterID = cluster.ID(clusterID)
+		proxy.WatchedResources = map[string]*model.WatchedResource{
+			v3.SecretType: {TypeUrl: v3.SecretType, ResourceNames: []string{"kubernetes://generic"}},
+		}
+		stream := &recordingStream{}
+		con := newConnection("", stream)
+		con.ConID = "gateway-" + clusterID
+		con.proxy = proxy
+		dispatcher.adsClients[con.ConID] = con
+		streams[clusterID] = stream
+	}
+
+	target := model.ProxyTargetKey("", "test.default")
+	dispatcher.startPush(&model.PushRequest{
+		Full:           true,
+		Push:           s.PushContext(),
+		Targets:        sets.NewSet(target),
+		ConfigsUpdated: map[model.ConfigKey]struct{}{{Name: "generic", Namespace: "istio-system", Kind: gvk.Secret}: {}},
+	})
+	if pending := dispatcher.pushQueue.Pending(); pending != 1 {
+		t.Fatalf("expected only the targeted gateway to be enqueued, got %d", pending)
+	}
+	con, req, _ := dispatcher.pushQueue.Dequeue()
+	if con.proxy.Metadata.ClusterID != "" {
+		t.Fatalf("expected the gateway of the targeted cluster, got %v", con.proxy.Metadata.ClusterID)
+	}
+	w := con.proxy.WatchedResources[v3.SecretType]
+	if err := s.Discovery.pushXds(con, req.Push, versionInfo(), w, req); err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.pushQueue.MarkDone(con)
+
+	if got := xdstest.ExtractTLSSecrets(t, streams[""].sent[0].Resources); len(got) != 1 {
+		t.Fatalf("expected the rotated secret to be pushed to the targeted gateway, got %v", got)
+	}
+	if sent := len(streams["cluster-2"].sent); sent != 0 {
+		t.Fatalf("expected no secret push to the gateway in the other cluster, got %d", sent)
+	}
+}
+
+func TestPushSecretOrder(t *testing.T) {
+	cases := []struct {
+		name   string
+		less   func(a, b string) bool
+		expect []string
+	}{
+		{
+			name:   "by name",
+			expect: []string{"kubernetes://generic", "kubernetes://generic-mtls", "kubernetes://generic-mtls-cacert"},
+		},
+		{
+			name: "priority secret first",
+			less: func(a, b string) bool {
+				return a == "kubernetes://generic-mtls" && b != a
+			},
+			expect: []string{"kubernetes://generic-mtls", "kubernetes://generic-mtls-cacert", "kubernetes://generic"},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewFakeDiscoveryServer(t, FakeOptions{
+				KubernetesObjects: []runtime.Object{genericCert, genericMtlsCert},
+			})
+			s.Discovery.SecretLess = tt.less
+			cc := s.KubeClient().Kube().(*fake.Clientset)
+			cc.Fake.Lock()
+			kubesecrets.DisableAuthorizationForTest(cc)
+			cc.Fake.Unlock()
+
+			proxy := s.SetupProxy(model.NewTestProxy(
+				model.WithProxyType(model.Router),
+				model.WithProxyNamespace("istio-system"),
+				model.WithProxyIdentity("istio-system", ""),
+			))
+			w := &model.WatchedResource{TypeUrl: v3.SecretType, ResourceNames: []string{
+				"kubernetes://generic-mtls-cacert", "kubernetes://generic", "kubernetes://generic-mtls",
+			}}
+			proxy.WatchedResources = map[string]*model.WatchedResource{v3.SecretType: w}
+			stream := &recordingStream{}
+			con := newConnection("", stream)
+			con.proxy = proxy
+			if err := s.Discovery.pushXds(con, s.PushContext(), versionInfo(), w, &model.PushRequest{Full: true, Start: time.Now()}); err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, r := range stream.sent[0].Resources {
+				scrt := &tls.Secret{}
+				if err := r.UnmarshalTo(scrt); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, scrt.Name)
+			}
+			if !reflect.DeepEqual(got, tt.expect) {
+				t.Fatalf("expected secrets %v, got %v", tt.expect, got)
+			}
+		})
+	}
+}
+
 // TestCaching ensures we don't have cross-proxy cache generation issues. This is split from TestGenerate
 // since it is order dependant.
 // Regression test for https://github.com/istio/istio/issues/33368
@@ -362,8 +554,8 @@ func TestCaching(t *testing.T) {
 	gen := s.Discovery.Generators[v3.SecretType]
 
 	fullPush := &model.PushRequest{Full: true, Start: time.Now()}
-	istiosystem := &model.Proxy{VerifiedIdentity: &spiffe.Identity{Namespace: "istio-system"}, Type: model.Router, ConfigNamespace: "istio-system"}
-	otherNamespace := &model.Proxy{VerifiedIdentity: &spiffe.Identity{Namespace: "other-namespace"}, Type: model.Router, ConfigNamespace: "other-namespace"}
+	istiosystem := model.NewTestProxy(model.WithProxyType(model.Router), model.WithProxyNamespace("istio-system"), model.WithProxyIdentity("istio-system", ""))
+	otherNamespace := model.NewTestProxy(model.WithProxyType(model.Router), model.WithProxyNamespace("other-namespace"), model.WithProxyIdentity("other-namespace", ""))
 
 	secrets, _, _ := gen.Generate(s.SetupProxy(istiosystem), s.PushContext(),
 		&model.WatchedResource{ResourceNames: []string{"kubernetes://generic"}}, fullPush)
@@ -372,6 +564,14 @@ func TestCaching(t *testing.T) {
 		t.Fatalf("failed to get expected secrets for authorized proxy: %v", raw)
 	}
 
+	// Another authorized proxy should share the resource marshaled for the first one
+	istiosystem2 := model.NewTestProxy(model.WithProxyType(model.Router), model.WithProxyNamespace("istio-system"), model.WithProxyIdentity("istio-system", ""))
+	cached, _, _ := gen.Generate(s.SetupProxy(istiosystem2), s.PushContext(),
+		&model.WatchedResource{ResourceNames: []string{"kubernetes://generic"}}, fullPush)
+	if len(cached) != 1 || cached[0].Resource != secrets[0].Resource {
+		t.Fatalf("expected cached secret to be shared across proxies, got %v", cached)
+	}
+
 	// We should not get secret returned, even though we are asking for the same one
 	secrets, _, _ = gen.Generate(s.SetupProxy(otherNamespace), s.PushContext(),
 		&model.WatchedResource{ResourceNames: []string{"kubernetes://generic"}}, fullPush)
@@ -380,3 +580,178 @@ func TestCaching(t *testing.T) {
 		t.Fatalf("failed to get expected secrets for unauthorized proxy: %v", raw)
 	}
 }
+
+// BenchmarkSecretGenerate measures generating the same secret for many connections in a push. With the cache,
+// the secret is marshaled once per push and shared by all connections.
+func BenchmarkSecretGenerate(b *testing.B) {
+	const connections = 100
+	for _, tt := range []struct {
+		name  string
+		cache model.XdsCache
+	}{
+		{"cached", model.NewXdsCache()},
+		{"uncached", model.DisabledCache{}},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			s := NewFakeDiscoveryServer(b, FakeOptions{
+				KubernetesObjects: []runtime.Object{genericCert},
+				KubeClientModifier: func(c kube.Client) {
+					kubesecrets.DisableAuthorizationForTest(c.Kube().(*fake.Clientset))
+				},
+			})
+			gen := s.Discovery.Generators[v3.SecretType].(*SecretGen)
+			gen.cache = tt.cache
+			proxies := make([]*model.Proxy, 0, connections)
+			for i := 0; i < connections; i++ {
+				proxies = append(proxies, s.SetupProxy(model.NewTestProxy(
+					model.WithProxyType(model.Router),
+					model.WithProxyNamespace("istio-system"),
+					model.WithProxyIdentity("istio-system", ""),
+				)))
+			}
+			w := &model.WatchedResource{ResourceNames: []string{"kubernetes://generic"}}
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				// Each iteration is a new push, which starts with an empty cache.
+				tt.cache.ClearAll()
+				push := &model.PushRequest{Full: true, Start: time.Now()}
+				for _, proxy := range proxies {
+					if res, _, _ := gen.Generate(proxy, s.PushContext(), w, push); len(res) != 1 {
+						b.Fatalf("expected a secret, got %v", res)
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkWatchedResourcesForName(b *testing.B) {
+	const secrets = 10000
+	names := make([]string, 0, secrets)
+	for i := 0; i < secrets; i++ {
+		names = append(names, "kubernetes://secret-"+strconv.Itoa(i))
+	}
+	s := &DiscoveryServer{}
+	con := &Connection{proxy: model.NewTestProxy()}
+	s.shouldRespond(con, &discovery.DiscoveryRequest{TypeUrl: v3.SecretType, ResourceNames: names})
+	target := names[secrets-1]
+
+	b.Run("index", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			if len(con.WatchedResourcesForName(target)) != 1 {
+				b.Fatalf("expected %v to be watched", target)
+			}
+		}
+	})
+	b.Run("scan", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			found := false
+			for _, w := range con.proxy.WatchedResources {
+				for _, name := range w.ResourceNames {
+					if name == target {
+						found = true
+					}
+				}
+			}
+			if !found {
+				b.Fatalf("expected %v to be watched", target)
+			}
+		}
+	})
+}
+
+func TestCanonicalSecretNames(t *testing.T) {
+	got := canonicalSecretNames([]string{
+		" default ",
+		"default",
+		"ROOTCA",
+		"kubernetes://ns/cred",
+		"file-cert:/etc/certs/./cert-chain.pem~/etc/certs//key.pem",
+		"file-root:/etc/certs/../certs/root-cert.pem",
+		"file-root:/etc/certs/root-cert.pem",
+	})
+	want := []string{
+		"default",
+		"ROOTCA",
+		"kubernetes://ns/cred",
+		"file-cert:/etc/certs/cert-chain.pem~/etc/certs/key.pem",
+		"file-root:/etc/certs/root-cert.pem",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestWithPreviousSecrets(t *testing.T) {
+	con := &Connection{sentSecrets: map[string]*discovery.Resource{
+		"kubernetes://a": {Name: "kubernetes://a"},
+		"kubernetes://b": {Name: "kubernetes://b"},
+	}}
+	w := &model.WatchedResource{TypeUrl: v3.SecretType, ResourceNames: []string{"kubernetes://a", "kubernetes://c"}}
+	names := func(res model.Resources) []string {
+		out := []string{}
+		for _, r := range res {
+			out = append(out, r.Name)
+		}
+		sort.Strings(out)
+		return out
+	}
+
+	generated := model.Resources{{Name: "kubernetes://c"}}
+	if got := names(withPreviousSecrets(con, w, &model.PushRequest{Full: true}, generated)); !reflect.DeepEqual(got, []string{"kubernetes://c"}) {
+		t.Fatalf("expected full push to be unchanged, got %v", got)
+	}
+	req := &model.PushRequest{Full: true, Delta: model.ResourceDelta{
+		Subscribed:   sets.NewSet("kubernetes://c"),
+		Unsubscribed: sets.NewSet("kubernetes://b"),
+	}}
+	if got := names(withPreviousSecrets(con, w, req, generated)); !reflect.DeepEqual(got, []string{"kubernetes://a", "kubernetes://c"}) {
+		t.Fatalf("expected subscription change to be completed with the watched secrets previously sent, got %v", got)
+	}
+}
+
+func TestTrackRemovedSecrets(t *testing.T) {
+	con := &Connection{ConID: "test", proxy: model.NewTestProxy(model.WithProxyNamespace("ns"))}
+	w := &model.WatchedResource{TypeUrl: v3.SecretType, ResourceNames: []string{"kubernetes://a", "kubernetes://b"}}
+	resources := func(names ...string) model.Resources {
+		res := model.Resources{}
+		for _, n := range names {
+			res = append(res, &discovery.Resource{Name: n})
+		}
+		return res
+	}
+	secretUpdate := func(name string) *model.PushRequest {
+		return &model.PushRequest{ConfigsUpdated: map[model.ConfigKey]struct{}{
+			{Kind: gvk.Secret, Name: name, Namespace: "ns"}: {},
+		}}
+	}
+	removed := func() float64 {
+		rows, err := view.RetrieveData("pilot_sds_removed_secrets_total")
+		if err != nil || len(rows) == 0 {
+			return 0
+		}
+		return rows[0].Data.(*view.SumData).Value
+	}
+
+	steps := []struct {
+		name    string
+		req     *model.PushRequest
+		res     model.Resources
+		removed float64
+	}{
+		{"initial push", &model.PushRequest{Full: true}, resources("kubernetes://a", "kubernetes://b"), 0},
+		{"unrelated secret updated", secretUpdate("c"), resources(), 0},
+		{"secret deleted", secretUpdate("a"), resources(), 1},
+		{"deleted secret is reported once", &model.PushRequest{Full: true}, resources("kubernetes://b"), 0},
+		{"all secrets deleted", &model.PushRequest{Full: true}, resources(), 1},
+	}
+	for _, step := range steps {
+		before := removed()
+		trackRemovedSecrets(con, w, step.req, step.res)
+		if got := removed() - before; got != step.removed {
+			t.Fatalf("%s: got %v removed secrets, want %v", step.name, got, step.removed)
+		}
+	}
+}
@@ -134,6 +134,12 @@ func NewXDS(stop chan struct{}) *SimpleServer {
 	s.ConfigStoreCache = aggregateConfigController
 	env.IstioConfigStore = model.MakeIstioStore(aggregateConfigController)
 
+	// Only serve once the environment is initialized and the config stores have synced.
+	ds.AddReadinessProbe(func() bool {
+		return env.ClusterLocal() != nil
+	})
+	ds.AddReadinessProbe(s.ConfigStoreCache.HasSynced)
+
 	return s
 }
 
@@ -142,7 +148,12 @@ func (s *SimpleServer) StartGRPC(addr string) error {
 	if err != nil {
 		return err
 	}
-	gs := grpc.NewServer()
+	var opts []grpc.ServerOption
+	if s.DiscoveryServer.MaxConcurrentStreams > 0 {
+		// Also bounds the streams multiplexed on a single client connection.
+		opts = append(opts, grpc.MaxConcurrentStreams(uint32(s.DiscoveryServer.MaxConcurrentStreams)))
+	}
+	gs := grpc.NewServer(opts...)
 	s.DiscoveryServer.Register(gs)
 	reflection.Register(gs)
 	s.GRPCListener = lis
@@ -35,7 +35,10 @@ const (
 	HealthInfoType  = apiTypePrefix + "istio.v1.HealthInformation"
 	ProxyConfigType = apiTypePrefix + "istio.mesh.v1alpha1.ProxyConfig"
 	// DebugType requests debug info from istio, a secured implementation for istio debug interface.
-	DebugType     = "istio.io/debug"
+	DebugType = "istio.io/debug"
+	// ResetType is a control type: a request for it drops all watches of the connection, so the
+	// client can subscribe again from scratch. No response is sent.
+	ResetType     = "istio.io/reset"
 	BootstrapType = apiTypePrefix + "envoy.config.bootstrap.v3.Bootstrap"
 
 	// nolint
@@ -15,13 +15,20 @@
 package xds
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/golang/protobuf/jsonpb"
 
+	"istio.io/istio/pilot/pkg/features"
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pilot/pkg/networking/util"
 	v3 "istio.io/istio/pilot/pkg/xds/v3"
@@ -37,9 +44,16 @@ type IstioControlPlaneInstance struct {
 	ID string
 	// The Istio version
 	Info istioversion.BuildInfo
+	// The most frequent reason of the push, only set if features.XDSControlPlaneReason is enabled
+	PushReason string `json:",omitempty"`
 }
 
-var controlPlane *corev3.ControlPlane
+var (
+	controlPlaneInstance IstioControlPlaneInstance
+	controlPlane         *corev3.ControlPlane
+	// controlPlaneByReason caches the control plane identifiers with a push reason, keyed by reason.
+	controlPlaneByReason sync.Map
+)
 
 // ControlPlane identifies the instance and Istio version.
 func ControlPlane() *corev3.ControlPlane {
@@ -49,17 +63,58 @@ func ControlPlane() *corev3.ControlPlane {
 func init() {
 	// The Pod Name (instance identity) is in PilotArgs, but not reachable globally nor from DiscoveryServer
 	podName := env.RegisterStringVar("POD_NAME", "", "").Get()
-	byVersion, err := json.Marshal(IstioControlPlaneInstance{
+	controlPlaneInstance = IstioControlPlaneInstance{
 		Component: "istiod",
 		ID:        podName,
 		Info:      istioversion.Info,
-	})
+	}
+	byVersion, err := json.Marshal(controlPlaneInstance)
 	if err != nil {
 		log.Warnf("XDS: Could not serialize control plane id: %v", err)
 	}
 	controlPlane = &corev3.ControlPlane{Identifier: string(byVersion)}
 }
 
+// controlPlaneForPush returns the control plane to send in a response for the given push. If
+// features.XDSControlPlaneReason is enabled, the identifier includes the most frequent push reason.
+func controlPlaneForPush(req *model.PushRequest) *corev3.ControlPlane {
+	if !features.XDSControlPlaneReason {
+		return ControlPlane()
+	}
+	reason := dominantReason(req)
+	if reason == "" {
+		return ControlPlane()
+	}
+	if cp, f := controlPlaneByReason.Load(reason); f {
+		return cp.(*corev3.ControlPlane)
+	}
+	instance := controlPlaneInstance
+	instance.PushReason = string(reason)
+	byReason, err := json.Marshal(instance)
+	if err != nil {
+		log.Warnf("XDS: Could not serialize control plane id: %v", err)
+		return ControlPlane()
+	}
+	cp, _ := controlPlaneByReason.LoadOrStore(reason, &corev3.ControlPlane{Identifier: string(byReason)})
+	return cp.(*corev3.ControlPlane)
+}
+
+// dominantReason returns the most frequent reason of the push, preferring the lowest reason on ties.
+func dominantReason(req *model.PushRequest) model.TriggerReason {
+	if req == nil {
+		return ""
+	}
+	counts := map[model.TriggerReason]int{}
+	var dominant model.TriggerReason
+	for _, r := range req.Reason {
+		counts[r]++
+		if counts[r] > counts[dominant] || (counts[r] == counts[dominant] && r < dominant) {
+			dominant = r
+		}
+	}
+	return dominant
+}
+
 func (s *DiscoveryServer) findGenerator(typeURL string, con *Connection) model.XdsResourceGenerator {
 	if g, f := s.Generators[con.proxy.Metadata.Generator+"/"+typeURL]; f {
 		return g
@@ -87,7 +142,7 @@ func (s *DiscoveryServer) findGenerator(typeURL string, con *Connection) model.X
 // based on the passed in generator. Based on the updates field, generators may
 // choose to send partial or even no response if there are no changes.
 func (s *DiscoveryServer) pushXds(con *Connection, push *model.PushContext,
-	currentVersion string, w *model.WatchedResource, req *model.PushRequest) error {
+	currentVersion string, w *model.WatchedResource, req *model.PushRequest) (err error) {
 	if w == nil {
 		return nil
 	}
@@ -97,8 +152,14 @@ func (s *DiscoveryServer) pushXds(con *Connection, push *model.PushContext,
 	}
 
 	t0 := time.Now()
+	resources := 0
+	defer func() { recordPushDuration(w.TypeUrl, resources, err, time.Since(t0)) }()
 
+	req = stablePushRequest(req, push)
 	res, logdata, err := gen.Generate(con.proxy, push, w, req)
+	if err == nil && logdata.NotModified {
+		reportSkippedPush(con, w.TypeUrl, logdata.AdditionalInfo)
+	}
 	if err != nil || res == nil {
 		// If we have nothing to send, report that we got an ACK for this version.
 		if s.StatusReporter != nil {
@@ -107,11 +168,30 @@ func (s *DiscoveryServer) pushXds(con *Connection, push *model.PushContext,
 		return err
 	}
 	defer func() { recordPushTime(w.TypeUrl, time.Since(t0)) }()
+	res = s.transformResources(con.proxy, w.TypeUrl, res)
+	if w.TypeUrl == v3.SecretType {
+		res = withPreviousSecrets(con, w, req, res)
+		trackRemovedSecrets(con, w, req, res)
+		orderSecrets(res, s.SecretLess)
+	}
+	resources = len(res)
+
+	var hash string
+	if s.SkipUnchangedPushes {
+		hash = resourcesHash(res)
+		if !isProxyRequest(req) && hash == con.lastSentHash(w.TypeUrl) {
+			reportSkippedPush(con, w.TypeUrl, "unchanged")
+			if s.StatusReporter != nil {
+				s.StatusReporter.RegisterEvent(con.ConID, w.TypeUrl, push.LedgerVersion)
+			}
+			return nil
+		}
+	}
 
 	resp := &discovery.DiscoveryResponse{
-		ControlPlane: ControlPlane(),
+		ControlPlane: controlPlaneForPush(req),
 		TypeUrl:      w.TypeUrl,
-		VersionInfo:  currentVersion,
+		VersionInfo:  versionInfoWithReason(currentVersion, req),
 		Nonce:        nonce(push.LedgerVersion),
 		Resources:    model.ResourcesToAny(res),
 	}
@@ -119,39 +199,98 @@ func (s *DiscoveryServer) pushXds(con *Connection, push *model.PushContext,
 	configSize := ResourceSize(res)
 	configSizeBytes.With(typeTag.Value(w.TypeUrl)).Record(float64(configSize))
 
-	if err := con.send(resp); err != nil {
+	s.beforePush(con, w)
+	err = con.send(resp)
+	s.afterPush(con, w, err)
+	if err != nil {
 		recordSendError(w.TypeUrl, con.ConID, err)
 		return err
 	}
+	if hash != "" {
+		con.setLastSentHash(w.TypeUrl, hash)
+	}
+	if s.LogPushPayloads && log.DebugEnabled() {
+		logPushPayload(con, w.TypeUrl, res)
+	}
 
-	ptype := "PUSH"
-	info := ""
-	if logdata.Incremental {
-		ptype = "PUSH INC"
+	if logdata.Incremental && !log.DebugEnabled() {
+		return nil
 	}
+	l := con.logger(w.TypeUrl, "proxy", con.proxy.ID, "resources", len(res), "size", util.ByteCount(configSize))
 	if len(logdata.AdditionalInfo) > 0 {
-		info = " " + logdata.AdditionalInfo
+		l = l.WithLabels("info", logdata.AdditionalInfo)
 	}
-
-	switch {
-	case logdata.Incremental:
-		if log.DebugEnabled() {
-			log.Debugf("%s: %s%s for node:%s resources:%d size:%s%s",
-				v3.GetShortType(w.TypeUrl), ptype, req.PushReason(), con.ConID, len(res), util.ByteCount(configSize), info)
-		}
-	default:
-		debug := ""
-		if log.DebugEnabled() {
-			// Add additional information to logs when debug mode enabled.
-			debug = " nonce:" + resp.Nonce + " version:" + resp.VersionInfo
+	if log.DebugEnabled() {
+		// Add additional information to logs when debug mode enabled.
+		l = l.WithLabels("version", resp.VersionInfo, "nonce", resp.Nonce)
+		if req != nil && req.PushReason() != "" {
+			l = l.WithLabels("reason", strings.TrimSpace(req.PushReason()))
 		}
-		log.Infof("%s: %s for node:%s resources:%d size:%v%s%s", v3.GetShortType(w.TypeUrl), ptype, con.proxy.ID, len(res),
-			util.ByteCount(ResourceSize(res)), info, debug)
+	}
+	if logdata.Incremental {
+		l.Debug("PUSH INC")
+	} else {
+		l.Info("PUSH")
 	}
 
 	return nil
 }
 
+// reportSkippedPush records a push skipped as the configuration of the type was not modified.
+func reportSkippedPush(con *Connection, typeURL string, reason string) {
+	recordSkippedPush(typeURL, reason)
+	if log.DebugEnabled() {
+		con.logger(typeURL, "proxy", con.proxy.ID, "reason", reason).Debug("PUSH SKIPPED")
+	}
+}
+
+// logPushPayload logs the resources pushed to the connection, one line per resource.
+func logPushPayload(con *Connection, typeURL string, res model.Resources) {
+	for _, r := range res {
+		con.logger(typeURL, "proxy", con.proxy.ID, "resource", r.Name).Debugf("PUSH PAYLOAD %s", resourcePayload(typeURL, r))
+	}
+}
+
+// resourcePayload returns the JSON representation of a pushed resource for logging. Secrets may hold
+// private keys and are never marshaled: only their size is reported.
+func resourcePayload(typeURL string, r *discovery.Resource) string {
+	if typeURL == v3.SecretType {
+		return fmt.Sprintf("<redacted %d bytes>", len(r.Resource.GetValue()))
+	}
+	out, err := (&jsonpb.Marshaler{}).MarshalToString(r.Resource)
+	if err != nil {
+		return fmt.Sprintf("<%d bytes, not marshaled: %v>", len(r.Resource.GetValue()), err)
+	}
+	return out
+}
+
+// resourcesHash hashes the names and serialized resources, independently of their order.
+func resourcesHash(res model.Resources) string {
+	sorted := make(model.Resources, len(res))
+	copy(sorted, res)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	h := md5.New()
+	for _, r := range sorted {
+		value := r.Resource.GetValue()
+		fmt.Fprintf(h, "%d:%s%d:", len(r.Name), r.Name, len(value))
+		_, _ = h.Write(value)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isProxyRequest returns true if the push responds to a request of the proxy, which must be answered.
+func isProxyRequest(req *model.PushRequest) bool {
+	if req == nil {
+		return false
+	}
+	for _, r := range req.Reason {
+		if r == model.ProxyRequest {
+			return true
+		}
+	}
+	return false
+}
+
 func ResourceSize(r model.Resources) int {
 	// Approximate size by looking at the Any marshaled size. This avoids high cost
 	// proto.Size, at the expense of slightly under counting.
@@ -161,3 +300,35 @@ func ResourceSize(r model.Resources) int {
 	}
 	return size
 }
+
+// stablePushRequest returns a request referring to push, the PushContext the generators are
+// invoked with, so that generators reading req.Push see the same context for the whole push.
+// The request may be shared by many connections, so it is copied rather than modified.
+func stablePushRequest(req *model.PushRequest, push *model.PushContext) *model.PushRequest {
+	if req == nil || req.Push == push {
+		return req
+	}
+	r := *req
+	r.Push = push
+	return &r
+}
+
+// transformResources applies the ResourceTransformer, if any, to the generated resources.
+func (s *DiscoveryServer) transformResources(proxy *model.Proxy, typeURL string, res model.Resources) model.Resources {
+	if s.ResourceTransformer == nil {
+		return res
+	}
+	return s.ResourceTransformer(proxy, typeURL, res)
+}
+
+func (s *DiscoveryServer) beforePush(con *Connection, w *model.WatchedResource) {
+	if s.BeforePush != nil {
+		s.BeforePush(con, w)
+	}
+}
+
+func (s *DiscoveryServer) afterPush(con *Connection, w *model.WatchedResource, err error) {
+	if s.AfterPush != nil {
+		s.AfterPush(con, w, err)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xdstest
+
+import (
+	"context"
+	"io"
+	"time"
+
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"google.golang.org/grpc"
+
+	"istio.io/istio/pkg/test"
+)
+
+// MemoryStream is an in-memory ADS server stream, so tests can drive a discovery server without gRPC.
+// Requests written to RecvQueue are received by the server, and responses sent by the server are written
+// to SendQueue. Closing RecvQueue ends the stream like a client closing its side.
+type MemoryStream struct {
+	grpc.ServerStream
+
+	SendQueue chan *discovery.DiscoveryResponse
+	RecvQueue chan *discovery.DiscoveryRequest
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+var _ discovery.AggregatedDiscoveryService_StreamAggregatedResourcesServer = &MemoryStream{}
+
+// NewMemoryStream returns a MemoryStream with buffered queues, using ctx as the stream context. The
+// stream context is canceled on test cleanup.
+func NewMemoryStream(ctx context.Context, t test.Failer) *MemoryStream {
+	ctx, cancel := context.WithCancel(ctx)
+	t.Cleanup(cancel)
+	return &MemoryStream{
+		SendQueue: make(chan *discovery.DiscoveryResponse, 10),
+		RecvQueue: make(chan *discovery.DiscoveryRequest, 10),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+func (m *MemoryStream) Send(res *discovery.DiscoveryResponse) error {
+	select {
+	case m.SendQueue <- res:
+		return nil
+	case <-m.ctx.Done():
+		return m.ctx.Err()
+	}
+}
+
+func (m *MemoryStream) Recv() (*discovery.DiscoveryRequest, error) {
+	select {
+	case req, ok := <-m.RecvQueue:
+		if !ok {
+			return nil, io.EOF
+		}
+		return req, nil
+	case <-m.ctx.Done():
+		return nil, io.EOF
+	}
+}
+
+func (m *MemoryStream) Context() context.Context {
+	return m.ctx
+}
+
+// Cancel cancels the stream context, like a client going away.
+func (m *MemoryStream) Cancel() {
+	m.cancel()
+}
+
+// ExpectResponse waits for a response sent by the server and returns it.
+func (m *MemoryStream) ExpectResponse(t test.Failer) *discovery.DiscoveryResponse {
+	t.Helper()
+	select {
+	case res := <-m.SendQueue:
+		return res
+	case <-time.After(time.Second * 5):
+		t.Fatalf("did not get response in time")
+	}
+	return nil
+}
+
+// ExpectNoResponse fails if the server sends a response within a short time.
+func (m *MemoryStream) ExpectNoResponse(t test.Failer) {
+	t.Helper()
+	select {
+	case res := <-m.SendQueue:
+		t.Fatalf("got unexpected response for %v: %v", res.TypeUrl, res.Nonce)
+	case <-time.After(time.Millisecond * 50):
+	}
+}
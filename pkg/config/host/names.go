@@ -32,7 +32,13 @@ func (h Names) Len() int {
 }
 
 func (h Names) Less(i, j int) bool {
-	a, b := h[i], h[j]
+	return MoreSpecific(h[i], h[j])
+}
+
+// MoreSpecific reports whether a is more specific than b, in the order Names sorts hostnames: exact hosts
+// before wildcard hosts, then longest to shortest, then alphabetically. For example "a.foo.com" is more
+// specific than "*.foo.com", which is more specific than "*".
+func MoreSpecific(a, b Name) bool {
 	if len(a) == 0 && len(b) == 0 {
 		return true // doesn't matter, they're both the empty string
 	}
@@ -188,6 +188,32 @@ func TestNamesSortOrder(t *testing.T) {
 	}
 }
 
+func TestMoreSpecific(t *testing.T) {
+	tests := []struct {
+		a, b host.Name
+		want bool
+	}{
+		{"a.foo.com", "*.foo.com", true},
+		{"*.foo.com", "a.foo.com", false},
+		{"*.foo.com", "*", true},
+		{"*", "*.foo.com", false},
+		{"a.foo.com", "*", true},
+		{"*", "a.foo.com", false},
+		// Longer hosts are more specific, equally long hosts are ordered alphabetically.
+		{"a.bar.foo.com", "b.foo.com", true},
+		{"a.foo.com", "b.foo.com", true},
+		{"b.foo.com", "a.foo.com", false},
+		{"*.bar.foo.com", "*.foo.com", true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s vs %s", tt.a, tt.b), func(t *testing.T) {
+			if got := host.MoreSpecific(tt.a, tt.b); got != tt.want {
+				t.Fatalf("MoreSpecific(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
 func BenchmarkNamesSort(b *testing.B) {
 	unsorted := host.Names{"foo.com", "bar.com", "*.com", "*.foo.com", "*", "baz.bar.com"}
 
@@ -41,6 +41,7 @@ var (
 	ClusterFieldRegex        = regexp.MustCompile(string(response.ClusterField) + "=(.*)")
 	IstioVersionFieldRegex   = regexp.MustCompile(string(response.IstioVersionField) + "=(.*)")
 	IPFieldRegex             = regexp.MustCompile(string(response.IPField) + "=(.*)")
+	SNIFieldRegex            = regexp.MustCompile(string(response.SNIField) + "=(.*)")
 )
 
 // ParsedResponse represents a response to a single echo request.
@@ -70,6 +71,8 @@ type ParsedResponse struct {
 	IstioVersion string
 	// IP is the requester's ip address
 	IP string
+	// SNI is the server name the requester presented in the TLS handshake, if TLS was terminated by the server
+	SNI string
 	// RawResponse gives a map of all values returned in the response (headers, etc)
 	RawResponse map[string]string
 }
@@ -97,6 +100,7 @@ func (r *ParsedResponse) String() string {
 	out += fmt.Sprintf("Cluster:      %s\n", r.Cluster)
 	out += fmt.Sprintf("IstioVersion: %s\n", r.IstioVersion)
 	out += fmt.Sprintf("IP:           %s\n", r.IP)
+	out += fmt.Sprintf("SNI:          %s\n", r.SNI)
 
 	return out
 }
@@ -279,6 +283,23 @@ func (r ParsedResponses) CheckKey(key, expected string) error {
 	})
 }
 
+func (r ParsedResponses) CheckSNI(expected string) error {
+	return r.Check(func(i int, response *ParsedResponse) error {
+		if response.SNI != expected {
+			return fmt.Errorf("response[%d] SNI: expected %s, received %s", i, expected, response.SNI)
+		}
+		return nil
+	})
+}
+
+func (r ParsedResponses) CheckSNIOrFail(t test.Failer, expected string) ParsedResponses {
+	t.Helper()
+	if err := r.CheckSNI(expected); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
 func (r ParsedResponses) CheckCluster(expected string) error {
 	return r.Check(func(i int, response *ParsedResponse) error {
 		if response.Cluster != expected {
@@ -405,6 +426,11 @@ func parseResponse(output string) *ParsedResponse {
 		out.IP = match[1]
 	}
 
+	match = SNIFieldRegex.FindStringSubmatch(output)
+	if match != nil {
+		out.SNI = match[1]
+	}
+
 	out.RawResponse = map[string]string{}
 
 	matches := responseHeaderFieldRegex.FindAllStringSubmatch(output, -1)
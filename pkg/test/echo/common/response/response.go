@@ -43,5 +43,6 @@ const (
 	ResponseHeader      Field = "ResponseHeader"
 	ClusterField        Field = "Cluster"
 	IstioVersionField   Field = "IstioVersion"
-	IPField             Field = "IP" // The Requester’s IP Address.
+	IPField             Field = "IP"  // The Requester’s IP Address.
+	SNIField            Field = "SNI" // The server name presented by the requester in the TLS handshake.
 )
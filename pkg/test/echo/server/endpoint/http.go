@@ -320,6 +320,7 @@ func (h *httpHandler) addResponsePayload(r *http.Request, body *bytes.Buffer) {
 	var alpn string
 	if r.TLS != nil {
 		alpn = r.TLS.NegotiatedProtocol
+		writeField(body, response.SNIField, r.TLS.ServerName)
 	}
 	writeField(body, "Alpn", alpn)
 
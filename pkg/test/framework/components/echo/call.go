@@ -182,6 +182,13 @@ func ExpectHost(expected string) Validator {
 	})
 }
 
+// ExpectSNI returns a Validator that checks the responses were served for the given SNI.
+func ExpectSNI(expected string) Validator {
+	return ValidatorFunc(func(responses client.ParsedResponses, _ error) error {
+		return responses.CheckSNI(expected)
+	})
+}
+
 // ExpectCode returns a Validator that checks the responses for the given response code.
 func ExpectCode(expected string) Validator {
 	return ValidatorFunc(func(responses client.ParsedResponses, _ error) error {
@@ -25,6 +25,10 @@ import (
 	"istio.io/istio/pkg/test/framework/components/echo"
 )
 
+// originalSrcIPSchemes are the schemes original source IP preservation is checked for. Each scheme is sent
+// to the echo port of the same name.
+var originalSrcIPSchemes = []scheme.Instance{scheme.HTTP, scheme.TCP, scheme.GRPC}
+
 func TestTproxy(t *testing.T) {
 	framework.
 		NewTest(t).
@@ -41,11 +45,26 @@ func TestTproxy(t *testing.T) {
 			for _, w := range workloads {
 				srcIps = append(srcIps, w.Address())
 			}
-			checkOriginalSrcIP(t, apps.PodA[0], apps.PodTproxy[0], srcIps)
+			for _, s := range originalSrcIPSchemes {
+				s := s
+				t.NewSubTest("tproxy-"+string(s)).Run(func(t framework.TestContext) {
+					checkOriginalSrcIP(t, apps.PodA[0], apps.PodTproxy[0], s, srcIps)
+				})
+			}
+			// With REDIRECT interception the inbound sidecar connects to the application from localhost, so
+			// the original source is not preserved. Assert it explicitly so a change in either mode is noticed.
+			for _, s := range originalSrcIPSchemes {
+				s := s
+				t.NewSubTest("redirect-"+string(s)).Run(func(t framework.TestContext) {
+					checkOriginalSrcIP(t, apps.PodA[0], apps.PodB[0], s, []string{"127.0.0.1"})
+				})
+			}
 		})
 }
 
-func checkOriginalSrcIP(t framework.TestContext, src echo.Caller, dest echo.Instance, expected []string) {
+// checkOriginalSrcIP sends a request with the given scheme from src to dest and checks that dest saw one of
+// the expected source IPs.
+func checkOriginalSrcIP(t framework.TestContext, src echo.Caller, dest echo.Instance, s scheme.Instance, expected []string) {
 	t.Helper()
 	validator := echo.ValidatorFunc(func(resp client.ParsedResponses, inErr error) error {
 		// Check that each response saw one of the workload IPs for the src echo instance
@@ -57,8 +76,8 @@ func checkOriginalSrcIP(t framework.TestContext, src echo.Caller, dest echo.Inst
 				}
 			}
 			if !found {
-				return fmt.Errorf("unexpected IP %s, expected to be contained in %v",
-					r.IP, expected)
+				return fmt.Errorf("unexpected IP %s for %s, expected to be contained in %v",
+					r.IP, s, expected)
 			}
 		}
 
@@ -66,8 +85,8 @@ func checkOriginalSrcIP(t framework.TestContext, src echo.Caller, dest echo.Inst
 	})
 	_ = src.CallWithRetryOrFail(t, echo.CallOptions{
 		Target:    dest,
-		PortName:  "http",
-		Scheme:    scheme.HTTP,
+		PortName:  string(s),
+		Scheme:    s,
 		Count:     1,
 		Validator: validator,
 	})
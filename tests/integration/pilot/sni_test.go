@@ -0,0 +1,96 @@
+// +build integ
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilot
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"istio.io/istio/pkg/test/echo/client"
+	"istio.io/istio/pkg/test/echo/common/scheme"
+	"istio.io/istio/pkg/test/framework"
+	"istio.io/istio/pkg/test/framework/components/echo"
+	"istio.io/istio/pkg/test/util/tmpl"
+)
+
+// TestSNIRouting checks that TLS passthrough traffic sent to a single service port is routed to a
+// different upstream for each SNI.
+func TestSNIRouting(t *testing.T) {
+	framework.
+		NewTest(t).
+		Features("traffic.routing").
+		RequiresSingleCluster().
+		Run(func(t framework.TestContext) {
+			src := apps.PodA[0]
+			dest := apps.PodB[0]
+			upstreams := map[string]echo.Instance{
+				"b.sni.test": apps.PodB[0],
+				"c.sni.test": apps.PodC[0],
+			}
+			t.Config().ApplyYAMLOrFail(t, apps.Namespace.Name(), tmpl.MustEvaluate(`apiVersion: networking.istio.io/v1alpha3
+kind: VirtualService
+metadata:
+  name: sni-routing
+spec:
+  hosts:
+  - {{ .Host }}
+  - "*.sni.test"
+  tls:
+{{- range $sni, $upstream := .Upstreams }}
+  - match:
+    - port: {{ $.Port }}
+      sniHosts:
+      - {{ $sni }}
+    route:
+    - destination:
+        host: {{ $upstream.Config.FQDN }}
+        port:
+          number: {{ $.Port }}
+{{- end }}
+`, map[string]interface{}{
+				"Host":      dest.Config().FQDN(),
+				"Port":      dest.Config().PortByName("https").ServicePort,
+				"Upstreams": upstreams,
+			}))
+
+			for sni, upstream := range upstreams {
+				sni, upstream := sni, upstream
+				t.NewSubTest(sni).Run(func(t framework.TestContext) {
+					_ = src.CallWithRetryOrFail(t, echo.CallOptions{
+						Target:     dest,
+						PortName:   "https",
+						Scheme:     scheme.HTTPS,
+						ServerName: sni,
+						Count:      1,
+						Validator:  echo.And(echo.ExpectOK(), echo.ExpectSNI(sni), expectUpstream(upstream)),
+					})
+				})
+			}
+		})
+}
+
+// expectUpstream returns a validator that checks the responses were served by a workload of the given instance.
+func expectUpstream(upstream echo.Instance) echo.Validator {
+	return echo.ValidatorFunc(func(resp client.ParsedResponses, _ error) error {
+		return resp.Check(func(i int, r *client.ParsedResponse) error {
+			if !strings.HasPrefix(r.Hostname, upstream.Config().Service+"-") {
+				return fmt.Errorf("response[%d] served by %s, expected a workload of %s", i, r.Hostname, upstream.Config().Service)
+			}
+			return nil
+		})
+	})
+}